@@ -1,17 +1,25 @@
 package aof
 
 import (
+	"bufio"
+	"errors"
 	"go-redis/config"
 	databaseface "go-redis/interface/database"
+	"go-redis/lib/latency"
 	"go-redis/lib/logger"
+	"go-redis/lib/metrics"
 	"go-redis/lib/utils"
 	"go-redis/resp/connection"
 	"go-redis/resp/parser"
 	"go-redis/resp/reply"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // CmdLine 是 [][]byte 的别名，表示一条命令行
@@ -21,9 +29,25 @@ const (
 	aofQueueSize = 1 << 16
 )
 
+// appendfsync 的取值，与 Redis 相同
+const (
+	fsyncAlways   = "always"
+	fsyncEverySec = "everysec"
+	fsyncNo       = "no"
+)
+
+var (
+	aofWriteDuration = metrics.NewHistogramVec("godis_aof_write_duration_seconds",
+		"Latency of writes to the AOF file.", metrics.DefaultBuckets)
+	aofFsyncDuration = metrics.NewHistogramVec("godis_aof_fsync_duration_seconds",
+		"Latency of fsync calls on the AOF file.", metrics.DefaultBuckets)
+)
+
 type payload struct {
 	cmdLine CmdLine
 	dbIndex int
+	// done 不为 nil 时不写入命令，aof 协程处理到该消息时关闭 done，表示之前的命令都已写入
+	done chan struct{}
 }
 
 // AofHandler 接收来自通道的消息并将其写入AOF文件
@@ -37,6 +61,14 @@ type AofHandler struct {
 	// 为开始/结束AOF重写进程暂停AOF
 	pausingAof sync.RWMutex
 	currentDB  int
+	// AOF 文件的当前大小，原子访问
+	currentSize int64
+	// 最近一次写入是否失败，原子访问
+	lastWriteFailed int32
+	// appendfsync 策略
+	fsyncPolicy string
+	// 关闭时通知 everysec 的 fsync 协程退出
+	stopFsync chan struct{}
 }
 
 // LoadAof 从AOF文件中加载命令并执行。
@@ -66,32 +98,33 @@ func (handler *AofHandler) LoadAof(maxBytes int) {
 	} else {
 		reader = file
 	}
-	// 使用resp/parser包中的ParseStream函数解析AOF文件内容
-	ch := parser.ParseStream(reader)
+	// 使用resp/parser包中的Reader逐条读取AOF文件中的命令
+	cmdReader := parser.NewReader(reader)
+	defer cmdReader.Release()
 	// 创建一个FakeConn用于执行解析出的命令
 	fakeConn := &connection.FakeConn{} // 仅用于保存dbIndex
 
-	for p := range ch {
-		if p.Err != nil {
-			if p.Err == io.EOF {
+	for {
+		args, err := cmdReader.ReadCommand()
+		if err != nil {
+			if err == io.EOF {
 				break
 			}
-			logger.Error("parse error: " + p.Err.Error())
-			continue
-		}
-		if p.Data == nil {
-			logger.Error("empty payload")
-			continue
+			logger.Error("parse error: " + err.Error())
+			var protoErr *parser.ProtocolError
+			if errors.As(err, &protoErr) {
+				continue
+			}
+			// 文件末尾的命令不完整或读取出错
+			break
 		}
-		r, ok := p.Data.(*reply.MultiBulkReply)
-		if !ok {
-			logger.Error("require multi bulk reply")
+		if len(args) == 0 {
 			continue
 		}
 		// 使用AOF处理器的数据库接口执行解析出的命令
-		ret := handler.database.Exec(fakeConn, r.Args)
-		if reply.IsErrorReply(ret) {
-			logger.Error("exec err", err)
+		ret := handler.database.Exec(fakeConn, args)
+		if errReply, ok := ret.(reply.ErrorReply); ok {
+			logger.Error("exec err: " + errReply.Error())
 		}
 	}
 }
@@ -105,15 +138,19 @@ func (handler *AofHandler) handleAof() {
 	handler.currentDB = 0
 	// 循环监听AOF通道，处理传入的命令
 	for p := range handler.aofChan {
+		if p.done != nil {
+			close(p.done)
+			continue
+		}
 		// 防止其他协程暂停AOF
 		handler.pausingAof.RLock()
 		// 检查是否需要切换到新的数据库
 		if p.dbIndex != handler.currentDB {
 			// 选择数据库，构建SELECT命令并写入AOF文件
 			data := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()
-			_, err := handler.aofFile.Write(data)
-			if err != nil {
+			if err := handler.write(data); err != nil {
 				logger.Warn(err)
+				handler.pausingAof.RUnlock()
 				continue // 跳过此命令
 			}
 			handler.currentDB = p.dbIndex
@@ -121,9 +158,12 @@ func (handler *AofHandler) handleAof() {
 
 		// 将命令转换为字节并写入AOF文件
 		data := reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
-		_, err := handler.aofFile.Write(data)
-		if err != nil {
+		if err := handler.write(data); err != nil {
 			logger.Warn(err)
+		} else if handler.fsyncPolicy == fsyncAlways {
+			start := time.Now()
+			handler.fsync()
+			latency.AddSampleIfNeeded(latency.EventAofFsyncAlways, time.Since(start))
 		}
 		// 解锁，允许其他协程暂停AOF
 		handler.pausingAof.RUnlock()
@@ -132,6 +172,63 @@ func (handler *AofHandler) handleAof() {
 	handler.aofFinished <- struct{}{}
 }
 
+// write 将数据写入 AOF 文件，并记录文件大小与写入状态
+func (handler *AofHandler) write(data []byte) error {
+	start := time.Now()
+	n, err := handler.aofFile.Write(data)
+	aofWriteDuration.WithLabelValues().Observe(metrics.Since(start))
+	latency.AddSampleIfNeeded(latency.EventAofWrite, time.Since(start))
+	atomic.AddInt64(&handler.currentSize, int64(n))
+	if err != nil {
+		atomic.StoreInt32(&handler.lastWriteFailed, 1)
+		return err
+	}
+	atomic.StoreInt32(&handler.lastWriteFailed, 0)
+	return nil
+}
+
+// fsync 将 AOF 文件刷入磁盘
+func (handler *AofHandler) fsync() {
+	start := time.Now()
+	if err := handler.aofFile.Sync(); err != nil {
+		logger.Warn(err)
+		return
+	}
+	aofFsyncDuration.WithLabelValues().Observe(metrics.Since(start))
+}
+
+// fsyncEverySecond 为 everysec 策略每秒执行一次 fsync，直到 AOF 关闭
+func (handler *AofHandler) fsyncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// 重写期间 aofFile 会被替换
+			handler.pausingAof.RLock()
+			handler.fsync()
+			handler.pausingAof.RUnlock()
+		case <-handler.stopFsync:
+			return
+		}
+	}
+}
+
+// CurrentSize 返回 AOF 文件的当前大小
+func (handler *AofHandler) CurrentSize() int64 {
+	return atomic.LoadInt64(&handler.currentSize)
+}
+
+// BufferLength 返回等待写入 AOF 文件的命令数
+func (handler *AofHandler) BufferLength() int {
+	return len(handler.aofChan)
+}
+
+// LastWriteOK 返回最近一次写入 AOF 文件是否成功
+func (handler *AofHandler) LastWriteOK() bool {
+	return atomic.LoadInt32(&handler.lastWriteFailed) == 0
+}
+
 // AddAof 通过通道将命令发送到aof协程
 func (handler *AofHandler) AddAof(dbIndex int, cmdLine CmdLine) {
 	if config.Properties.AppendOnly && handler.aofChan != nil {
@@ -142,11 +239,76 @@ func (handler *AofHandler) AddAof(dbIndex int, cmdLine CmdLine) {
 	}
 }
 
+// Rewrite 将 dump 产生的命令写入新的 AOF 文件并替换当前文件，用于保存数据库的快照
+// 调用方需要保证期间没有新的写命令，Rewrite 会先等待通道中已有的命令写入当前文件，
+// 新文件写入并 fsync 成功后才会替换当前文件，失败时当前文件保持不变
+func (handler *AofHandler) Rewrite(dump func(emit func(dbIndex int, cmdLine CmdLine) error) error) error {
+	done := make(chan struct{})
+	handler.aofChan <- &payload{done: done}
+	<-done
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+
+	// 临时文件与 AOF 文件位于同一目录，保证可以直接重命名
+	tmpFile, err := os.CreateTemp(filepath.Dir(handler.aofFilename), filepath.Base(handler.aofFilename)+".rewrite-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// 替换成功后临时文件已不存在
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+	}()
+	writer := bufio.NewWriter(tmpFile)
+	// 加载 AOF 时从 0 号数据库开始
+	currentDB := 0
+	emit := func(dbIndex int, cmdLine CmdLine) error {
+		if dbIndex != currentDB {
+			data := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(dbIndex))).ToBytes()
+			if _, err := writer.Write(data); err != nil {
+				return err
+			}
+			currentDB = dbIndex
+		}
+		_, err := writer.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+		return err
+	}
+	if err = dump(emit); err != nil {
+		return err
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFile.Name(), handler.aofFilename); err != nil {
+		return err
+	}
+
+	// 重新打开替换后的文件，之后的命令追加到新文件中
+	aofFile, err := os.OpenFile(handler.aofFilename, os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	_ = handler.aofFile.Close()
+	handler.aofFile = aofFile
+	handler.currentDB = currentDB
+	if info, err := aofFile.Stat(); err == nil {
+		atomic.StoreInt64(&handler.currentSize, info.Size())
+	}
+	return nil
+}
+
 // Close 优雅地停止AOF持久化过程
 func (handler *AofHandler) Close() {
 	if handler.aofFile != nil {
 		close(handler.aofChan)
 		<-handler.aofFinished // 等待AOF完成
+		close(handler.stopFsync)
+		if handler.fsyncPolicy != fsyncNo {
+			handler.fsync()
+		}
 		err := handler.aofFile.Close()
 		if err != nil {
 			logger.Warn(err)
@@ -165,8 +327,24 @@ func NewAofHandler(database databaseface.Database) (*AofHandler, error) {
 		return nil, err
 	}
 	handler.aofFile = aofFile
+	if info, err := aofFile.Stat(); err == nil {
+		handler.currentSize = info.Size()
+	}
 	handler.aofChan = make(chan *payload, aofQueueSize)
 	handler.aofFinished = make(chan struct{})
+	handler.stopFsync = make(chan struct{})
+	handler.fsyncPolicy = strings.ToLower(config.Properties.AppendFsync)
+	switch handler.fsyncPolicy {
+	case fsyncAlways, fsyncEverySec, fsyncNo:
+	case "":
+		handler.fsyncPolicy = fsyncEverySec
+	default:
+		logger.Warn("unknown appendfsync " + config.Properties.AppendFsync + ", use everysec")
+		handler.fsyncPolicy = fsyncEverySec
+	}
+	if handler.fsyncPolicy == fsyncEverySec {
+		go handler.fsyncEverySecond()
+	}
 	go func() {
 		handler.handleAof()
 	}()
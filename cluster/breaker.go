@@ -0,0 +1,94 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 5 * time.Second
+)
+
+// 熔断器状态
+const (
+	breakerClosed   = iota // 正常转发请求
+	breakerOpen            // 节点不可达，直接拒绝请求
+	breakerHalfOpen        // 冷却结束，放行一个探测请求
+)
+
+// circuitBreaker 记录到某个节点的连续失败次数
+// 连续失败达到阈值后熔断，冷却期内的请求立即失败而不必等待超时；
+// 冷却结束后放行一个请求作为探测，成功则恢复，失败则重新开始冷却
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     int
+	failures  int
+	openedAt  time.Time
+	probing   bool // 半开状态下是否已有探测请求在执行
+	threshold int
+	cooldown  time.Duration
+}
+
+func makeCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		threshold = defaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow 判断是否可以向节点发送请求，返回 true 时调用方必须在请求结束后调用 onSuccess 或 onFailure
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = breakerHalfOpen
+		cb.probing = true
+		return true
+	case breakerHalfOpen:
+		if cb.probing {
+			return false
+		}
+		cb.probing = true
+		return true
+	}
+	return true
+}
+
+// onSuccess 请求成功，恢复正常状态
+func (cb *circuitBreaker) onSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.state = breakerClosed
+	cb.failures = 0
+	cb.probing = false
+}
+
+// onFailure 请求因网络原因失败
+func (cb *circuitBreaker) onFailure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures++
+	cb.probing = false
+	if cb.state == breakerHalfOpen || cb.failures >= cb.threshold {
+		cb.state = breakerOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// isOpen 返回节点当前是否被熔断
+func (cb *circuitBreaker) isOpen() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state != breakerClosed
+}
@@ -4,18 +4,90 @@ import (
 	"context"
 	"errors"
 	pool "github.com/jolestar/go-commons-pool/v2"
+	"go-redis/config"
 	"go-redis/resp/client"
+	"go-redis/resp/reply"
+	"math"
+	"time"
 )
 
+const (
+	defaultPoolMaxTotal      = 16
+	defaultPoolBorrowTimeout = time.Second
+	defaultPoolCheckInterval = 10 * time.Second
+	defaultRelayTimeout      = 3 * time.Second
+	// validateTimeout 校验空闲连接时等待 PING 响应的时间
+	validateTimeout = time.Second
+)
+
+// millis 将以毫秒为单位的配置项转换为 time.Duration，未配置时返回 def
+func millis(value int, def time.Duration) time.Duration {
+	if value <= 0 {
+		return def
+	}
+	return time.Duration(value) * time.Millisecond
+}
+
+// poolOptions 到其他节点的连接池配置
+type poolOptions struct {
+	maxTotal      int
+	maxIdle       int
+	borrowTimeout time.Duration
+	checkInterval time.Duration
+	relayTimeout  time.Duration
+}
+
+func makePoolOptions(props *config.ServerProperties) poolOptions {
+	opts := poolOptions{
+		maxTotal:      props.ClusterPoolMaxTotal,
+		maxIdle:       props.ClusterPoolMaxIdle,
+		borrowTimeout: millis(props.ClusterPoolBorrowTimeout, defaultPoolBorrowTimeout),
+		checkInterval: millis(props.ClusterPoolCheckInterval, defaultPoolCheckInterval),
+		relayTimeout:  millis(props.ClusterRelayTimeout, defaultRelayTimeout),
+	}
+	if opts.maxTotal <= 0 {
+		opts.maxTotal = defaultPoolMaxTotal
+	}
+	if opts.maxIdle <= 0 || opts.maxIdle > opts.maxTotal {
+		opts.maxIdle = opts.maxTotal
+	}
+	return opts
+}
+
+// makePeerPool 创建到指定节点的连接池
+// 新建的连接在放入连接池前会先 PING 一次，空闲的连接由后台任务定期 PING，失效的连接会被销毁
+// handshake 在每个新连接上认证为节点连接
+func makePeerPool(peer string, opts poolOptions, handshake func() [][]byte) *pool.ObjectPool {
+	poolConfig := pool.NewDefaultPoolConfig()
+	poolConfig.MaxTotal = opts.maxTotal
+	poolConfig.MaxIdle = opts.maxIdle
+	poolConfig.TestOnCreate = true
+	poolConfig.TestWhileIdle = true
+	poolConfig.TimeBetweenEvictionRuns = opts.checkInterval
+	// 连接可以被多个请求复用，空闲连接不因空闲时间被回收，只在校验失败时销毁
+	poolConfig.MinEvictableIdleTime = time.Duration(math.MaxInt64)
+	poolConfig.SoftMinEvictableIdleTime = time.Duration(math.MaxInt64)
+	return pool.NewObjectPool(context.Background(), &connectionFactory{
+		Peer:      peer,
+		Timeout:   opts.relayTimeout,
+		Handshake: handshake,
+	}, poolConfig)
+}
+
 type connectionFactory struct {
-	Peer string //连接池连接的节点
+	Peer      string          //连接池连接的节点
+	Timeout   time.Duration   //建立连接及等待响应的超时时间
+	Handshake func() [][]byte //每个新连接在 AUTH 之后发送的握手命令
 }
 
 func (cf *connectionFactory) MakeObject(ctx context.Context) (*pool.PooledObject, error) {
-	c, err := client.MakeClient(cf.Peer)
+	c, err := client.MakeClientWithTimeout(cf.Peer, cf.Timeout)
 	if err != nil {
 		return nil, err
 	}
+	// 集群中的节点使用相同的 requirepass
+	c.SetAuth("", config.Properties.RequirePass)
+	c.SetHandshake(cf.Handshake)
 	c.Start()
 	return pool.NewPooledObject(c), nil
 }
@@ -29,9 +101,18 @@ func (cf *connectionFactory) DestroyObject(ctx context.Context, object *pool.Poo
 	return nil
 }
 
+// ValidateObject 向节点发送 PING，收到 PONG 才认为连接可用
 func (cf *connectionFactory) ValidateObject(ctx context.Context, object *pool.PooledObject) bool {
-	// do validate
-	return true
+	c, ok := object.Object.(*client.Client)
+	if !ok {
+		return false
+	}
+	ret, err := c.SendAsync(-1, [][]byte{[]byte("PING")}).Result(validateTimeout)
+	if err != nil {
+		return false
+	}
+	status, ok := ret.(*reply.StatusReply)
+	return ok && status.Status == "PONG"
 }
 
 func (cf *connectionFactory) ActivateObject(ctx context.Context, object *pool.PooledObject) error {
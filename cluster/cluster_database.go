@@ -2,65 +2,169 @@ package cluster
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	pool "github.com/jolestar/go-commons-pool/v2"
 	"go-redis/config"
 	"go-redis/database"
+	"go-redis/datastruct/dict"
 	databaseface "go-redis/interface/database"
 	"go-redis/interface/resp"
-	"go-redis/lib/consistenthash"
 	"go-redis/lib/logger"
+	"go-redis/lib/sync/atomic"
 	"go-redis/resp/reply"
+	"net"
 	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // ClusterDatabase 表示 godis 集群中的一个节点
 // 它持有部分数据并协调其他节点完成事务
 type ClusterDatabase struct {
 	self           string                      //节点自己的名称
-	nodes          []string                    //整个集群的节点切片，包含当前节点
-	peerPicker     *consistenthash.NodeMap     //一致性哈希的管理器
+	topology       *topology                   //集群成员及其状态
+	slots          *slotTable                  //哈希槽到节点的映射，归属只随已提交的 Raft 日志变化
+	raft           *raftNode                   //维护集群元数据的一致性
+	peerMu         sync.RWMutex                //保护 peerConnection、txConnection 和 breakers
 	peerConnection map[string]*pool.ObjectPool //对其他各个节点的连接池映射
-	db             databaseface.Database       //对应的单体数据库（standalone_database）
+	txConnection   map[string]*pool.ObjectPool //对其他各个节点发送 TCC 内部命令的连接池，连接不以管道方式复用
+	breakers       map[string]*circuitBreaker  //对其他各个节点的熔断器
+	poolOpts       poolOptions                 //连接池配置
+	db             databaseface.DBEngine       //对应的单体数据库（standalone_database）
+	rebalancing    atomic.Boolean              //是否正在进行槽位再均衡
+	transactions   dict.Dict                   //本节点参与的跨节点事务，事务 id -> *Transaction
+	txSeq          uint64                      //用于生成事务 id
+	peerToken      string                      //其他节点的连接执行内部命令前需要出示的凭据
+	peerNonces     nonceCache                  //获取 peer token 的请求中已使用过的 nonce
+
+	nodeTimeout time.Duration //节点超过该时间未响应即被认为疑似下线
+	busListener net.Listener  //集群总线监听器
+	stopBus     chan struct{} //通知 gossip 定时任务退出
 }
 
 // MakeClusterDatabase 创建并启动集群中的一个节点
 func MakeClusterDatabase() *ClusterDatabase {
+	self := config.Properties.Self
+	if self == "" {
+		self = net.JoinHostPort(config.Properties.Bind, strconv.Itoa(config.Properties.Port))
+	}
 	//新建待返回的ClusterDatabase结构体
 	cluster := &ClusterDatabase{
-		self:           config.Properties.Self,
+		self:           self,
+		topology:       makeTopology(self),
+		slots:          makeSlotTable(),
 		db:             database.NewStandaloneDatabase(),
-		peerPicker:     consistenthash.NewNodeMap(nil),
 		peerConnection: make(map[string]*pool.ObjectPool),
+		txConnection:   make(map[string]*pool.ObjectPool),
+		breakers:       make(map[string]*circuitBreaker),
+		poolOpts:       makePoolOptions(config.Properties),
+		transactions:   dict.MakeSyncDict(),
+		peerToken:      makePeerToken(),
+		nodeTimeout:    time.Duration(config.Properties.ClusterNodeTimeout) * time.Millisecond,
+		stopBus:        make(chan struct{}),
+	}
+	if cluster.nodeTimeout <= 0 {
+		cluster.nodeTimeout = defaultNodeTimeout
+	}
+	// 迁移槽位时按槽位查找 key
+	cluster.db.EnableSlotIndex(getSlot)
+	busAddr := defaultBusAddr(self)
+	port := config.Properties.Port
+	if config.Properties.TLSCluster && config.Properties.TLSPort > 0 {
+		// 开启 tls-cluster 时 self 为 TLS 地址
+		port = config.Properties.TLSPort
+	}
+	busListenAddr := net.JoinHostPort(config.Properties.Bind, strconv.Itoa(port+busPortOffset))
+	if config.Properties.ClusterBusPort > 0 {
+		host, _, _ := net.SplitHostPort(self)
+		busAddr = net.JoinHostPort(host, strconv.Itoa(config.Properties.ClusterBusPort))
+		busListenAddr = net.JoinHostPort(config.Properties.Bind, strconv.Itoa(config.Properties.ClusterBusPort))
+	}
+	cluster.topology.addNode(self, busAddr)
+	cluster.raft = makeRaftNode(cluster)
+
+	//成员与槽位归属从持久化的 Raft 日志与快照中恢复，首次启动时由配置中的 peers 引导集群
+	if err := cluster.raft.start(busAddr); err != nil {
+		logger.Error("start raft failed: " + err.Error())
+	}
+	if err := cluster.startBus(busListenAddr); err != nil {
+		logger.Error("start cluster bus failed: " + err.Error())
 	}
-	//将所有节点包括当前节点添加到nodes切片中
-	nodes := make([]string, 0, len(config.Properties.Peers)+1)
-	for _, peer := range config.Properties.Peers {
-		nodes = append(nodes, peer)
-	}
-	nodes = append(nodes, config.Properties.Self)
-	//将所有节点，nodes切片，添加到一致性哈希管理器
-	cluster.peerPicker.AddNode(nodes...)
-	//初始化对每一个兄弟节点的连接池
-	ctx := context.Background()
-	for _, peer := range config.Properties.Peers {
-		cluster.peerConnection[peer] = pool.NewObjectPoolWithDefaultConfig(ctx, &connectionFactory{
-			Peer: peer,
-		})
-	}
-	//写入ClusterDatabase结构体
-	cluster.nodes = nodes
 	return cluster
 }
 
+// addNode 将节点加入集群并创建到该节点的连接池
+func (cluster *ClusterDatabase) addNode(addr string, busAddr string) bool {
+	if addr == cluster.self || !cluster.topology.addNode(addr, busAddr) {
+		return false
+	}
+	cluster.peerMu.Lock()
+	cluster.peerConnection[addr] = makePeerPool(addr, cluster.poolOpts, cluster.peerHandshake(addr))
+	cluster.txConnection[addr] = makePeerPool(addr, cluster.poolOpts, cluster.peerHandshake(addr))
+	cluster.breakers[addr] = makeCircuitBreaker(config.Properties.ClusterBreakerThreshold,
+		millis(config.Properties.ClusterBreakerCooldown, defaultBreakerCooldown))
+	cluster.peerMu.Unlock()
+	logger.Info("node joined cluster: " + addr)
+	return true
+}
+
+// removeNode 将节点移出集群并销毁到该节点的连接池
+func (cluster *ClusterDatabase) removeNode(addr string) bool {
+	if !cluster.topology.removeNode(addr) {
+		return false
+	}
+	cluster.peerMu.Lock()
+	pools := []*pool.ObjectPool{cluster.peerConnection[addr], cluster.txConnection[addr]}
+	delete(cluster.peerConnection, addr)
+	delete(cluster.txConnection, addr)
+	delete(cluster.breakers, addr)
+	cluster.peerMu.Unlock()
+	for _, peerPool := range pools {
+		if peerPool != nil {
+			// 关闭连接池可能等待正在使用的连接，不阻塞调用方
+			go peerPool.Close(context.Background())
+		}
+	}
+	logger.Info("node removed from cluster: " + addr)
+	return true
+}
+
 // Close 停止当前集群节点
 func (cluster *ClusterDatabase) Close() {
+	close(cluster.stopBus)
+	if cluster.busListener != nil {
+		_ = cluster.busListener.Close()
+	}
+	cluster.peerMu.Lock()
+	for _, peerPool := range cluster.peerConnection {
+		peerPool.Close(context.Background())
+	}
+	for _, txPool := range cluster.txConnection {
+		txPool.Close(context.Background())
+	}
+	cluster.peerMu.Unlock()
 	// 调用底层数据库的 Close 方法停止当前集群节点
 	cluster.db.Close()
 }
 
-var router = makeRouter()
+// Save 保存当前节点数据的快照
+func (cluster *ClusterDatabase) Save() error {
+	snapshotter, ok := cluster.db.(databaseface.Snapshotter)
+	if !ok {
+		return errors.New("the storage engine does not support snapshots")
+	}
+	return snapshotter.Save()
+}
+
+var router map[string]CmdFunc
+
+func init() {
+	// 在 init 中初始化以避免部分命令处理器通过 Exec 间接引用 router 造成初始化循环
+	router = makeRouter()
+}
 
 // Exec 在集群上执行命令
 func (cluster *ClusterDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result resp.Reply) {
@@ -72,6 +176,16 @@ func (cluster *ClusterDatabase) Exec(c resp.Connection, cmdLine [][]byte) (resul
 		}
 	}()
 	// 获取命令名称并转为小写
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if _, ok := internalCmds[cmdName]; ok && !isClusterPeer(c) {
+		// 内部命令只接受其他节点的连接
+		return reply.MakeErrReply("ERR unknown command '" + cmdName + "', or not supported in cluster mode")
+	}
+	return cluster.exec(c, cmdLine)
+}
+
+// exec 按路由表执行命令，不校验内部命令的来源，供本节点作为协调者对自身执行内部命令时使用
+func (cluster *ClusterDatabase) exec(c resp.Connection, cmdLine [][]byte) resp.Reply {
 	cmdName := strings.ToLower(string(cmdLine[0]))
 	// 查找命令处理函数
 	cmdFunc, ok := router[cmdName]
@@ -80,8 +194,7 @@ func (cluster *ClusterDatabase) Exec(c resp.Connection, cmdLine [][]byte) (resul
 		return reply.MakeErrReply("ERR unknown command '" + cmdName + "', or not supported in cluster mode")
 	}
 	// 调用命令处理函数，并返回结果
-	result = cmdFunc(cluster, c, cmdLine)
-	return result
+	return cmdFunc(cluster, c, cmdLine)
 }
 
 // AfterClientClose 在客户端关闭连接后执行一些清理工作
@@ -89,3 +202,10 @@ func (cluster *ClusterDatabase) AfterClientClose(c resp.Connection) {
 	// 调用底层数据库的 AfterClientClose 方法执行清理工作
 	cluster.db.AfterClientClose(c)
 }
+
+// SetClientRegistry 将网络层的客户端连接信息传递给底层数据库
+func (cluster *ClusterDatabase) SetClientRegistry(registry databaseface.ClientRegistry) {
+	if aware, ok := cluster.db.(databaseface.ClientRegistryAware); ok {
+		aware.SetClientRegistry(registry)
+	}
+}
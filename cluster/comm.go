@@ -3,22 +3,40 @@ package cluster
 import (
 	"context"
 	"errors"
+	pool "github.com/jolestar/go-commons-pool/v2"
 	"go-redis/interface/resp"
+	"go-redis/lib/metrics"
 	"go-redis/lib/utils"
 	"go-redis/resp/client"
 	"go-redis/resp/reply"
-	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	relayDuration = metrics.NewHistogramVec("godis_cluster_relay_duration_seconds",
+		"Latency of commands relayed to other nodes, per peer.", metrics.DefaultBuckets, "peer")
+	relayErrors = metrics.NewCounterVec("godis_cluster_relay_errors_total",
+		"Number of relays that failed because a peer was unreachable or timed out, per peer.", "peer")
 )
 
 // getPeerClient 获取与指定节点建立的客户端连接
 func (cluster *ClusterDatabase) getPeerClient(peer string) (*client.Client, error) {
 	//找到与对应节点的连接池
+	cluster.peerMu.RLock()
 	pool, ok := cluster.peerConnection[peer]
+	cluster.peerMu.RUnlock()
 	if !ok {
 		return nil, errors.New("connection pool not found")
 	}
-	//从找到的连接池中取出一个连接
-	object, err := pool.BorrowObject(context.Background())
+	return cluster.borrowClient(pool)
+}
+
+// borrowClient 从连接池中取出一个连接，连接池耗尽时最多等待 borrowTimeout
+func (cluster *ClusterDatabase) borrowClient(pool *pool.ObjectPool) (*client.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cluster.poolOpts.borrowTimeout)
+	defer cancel()
+	object, err := pool.BorrowObject(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -31,42 +49,168 @@ func (cluster *ClusterDatabase) getPeerClient(peer string) (*client.Client, erro
 
 // returnPeerClient 将客户端连接返还到连接池
 func (cluster *ClusterDatabase) returnPeerClient(peer string, peerClient *client.Client) error {
+	cluster.peerMu.RLock()
 	pool, ok := cluster.peerConnection[peer]
+	cluster.peerMu.RUnlock()
 	if !ok {
-		return errors.New("connection pool not found")
+		// 节点已被移出集群，连接池已关闭
+		peerClient.Close()
+		return nil
 	}
 	return pool.ReturnObject(context.Background(), peerClient)
 }
 
+const (
+	// broadcastTimeout 广播时等待所有节点返回结果的最长时间
+	broadcastTimeout = 5 * time.Second
+)
+
+// getBreaker 返回到指定节点的熔断器
+func (cluster *ClusterDatabase) getBreaker(peer string) *circuitBreaker {
+	cluster.peerMu.RLock()
+	defer cluster.peerMu.RUnlock()
+	return cluster.breakers[peer]
+}
+
+// unreachableErr 节点被熔断时返回的错误
+func unreachableErr(peer string) reply.ErrorReply {
+	return reply.MakeErrReply("CLUSTERDOWN peer " + peer + " is unreachable")
+}
+
+// relayAsync 将命令发送到指定的远程节点，不等待结果
+// 客户端放入发送队列后立即归还连接池，来自多个客户端连接的命令可以复用少量到节点的连接以管道方式发送，
+// 连接会记住当前选择的数据库，只在与 c.GetDBIndex() 不一致时才插入 SELECT
+// 节点被熔断时直接返回错误；返回的 Future 须通过 waitRelay 获取结果，以便更新熔断器状态
+func (cluster *ClusterDatabase) relayAsync(peer string, c resp.Connection, args [][]byte) (*client.Future, error) {
+	breaker := cluster.getBreaker(peer)
+	if breaker != nil && !breaker.allow() {
+		relayErrors.WithLabelValues(peer).Inc()
+		return nil, unreachableErr(peer)
+	}
+	peerClient, err := cluster.getPeerClient(peer)
+	if err != nil {
+		relayErrors.WithLabelValues(peer).Inc()
+		if breaker != nil {
+			breaker.onFailure()
+		}
+		return nil, err
+	}
+	future := peerClient.SendAsync(c.GetDBIndex(), args)
+	_ = cluster.returnPeerClient(peer, peerClient)
+	return future, nil
+}
+
 // relay 将命令中继到指定的节点
 // 通过 c.GetDBIndex() 选择数据库
 // 不能调用 self 节点的 Prepare、Commit、execRollback
 func (cluster *ClusterDatabase) relay(peer string, c resp.Connection, args [][]byte) resp.Reply {
+	if peer == "" {
+		return reply.MakeErrReply("CLUSTERDOWN Hash slot not served")
+	}
 	if peer == cluster.self {
 		// 到自身数据库执行
 		return cluster.db.Exec(c, args)
 	}
-	//获取一个到目标节点的连接
-	peerClient, err := cluster.getPeerClient(peer)
+	start := time.Now()
+	defer func() {
+		relayDuration.WithLabelValues(peer).Observe(metrics.Since(start))
+	}()
+	future, err := cluster.relayAsync(peer, c, args)
 	if err != nil {
 		return reply.MakeErrReply(err.Error())
 	}
-	//中继完成后归还连接到连接池
-	defer func() {
-		_ = cluster.returnPeerClient(peer, peerClient)
-	}()
-	//发送命令到指定节点，并选择对于db
-	peerClient.Send(utils.ToCmdLine("SELECT", strconv.Itoa(c.GetDBIndex())))
-	return peerClient.Send(args)
+	return cluster.waitRelay(peer, future, cluster.poolOpts.relayTimeout)
 }
 
-// broadcast 将命令广播到集群中的所有节点
+// waitRelay 等待 relayAsync 发出的请求的结果，超时或连接失败计入熔断器，节点返回的错误回复不计入
+func (cluster *ClusterDatabase) waitRelay(peer string, future *client.Future, timeout time.Duration) resp.Reply {
+	ret, err := future.Result(timeout)
+	cluster.recordRelay(peer, err)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return ret
+}
+
+// recordRelay 根据请求是否超时或连接失败更新熔断器
+func (cluster *ClusterDatabase) recordRelay(peer string, err error) {
+	breaker := cluster.getBreaker(peer)
+	if err != nil {
+		relayErrors.WithLabelValues(peer).Inc()
+		if breaker != nil {
+			breaker.onFailure()
+		}
+		return
+	}
+	if breaker != nil {
+		breaker.onSuccess()
+	}
+}
+
+// execLocally 在指定节点的本地数据库上执行命令，不经过该节点的集群路由
+func (cluster *ClusterDatabase) execLocally(peer string, c resp.Connection, args [][]byte) resp.Reply {
+	if peer == cluster.self {
+		return cluster.db.Exec(c, args)
+	}
+	return cluster.relay(peer, c, utils.ToCmdLine2(localExecCmd, args...))
+}
+
+// broadcast 将命令并行地发送到集群中的所有节点，在各节点本地执行
+// 所有节点共用一个截止时间，超时未返回的节点以错误回复记入结果
 func (cluster *ClusterDatabase) broadcast(c resp.Connection, args [][]byte) map[string]resp.Reply {
-	result := make(map[string]resp.Reply)
-	//向所有节点进行中继，并获取回复
-	for _, node := range cluster.nodes {
-		reply := cluster.relay(node, c, args)
-		result[node] = reply
+	deadline := time.Now().Add(broadcastTimeout)
+	nodes := cluster.topology.getNodes()
+	result := make(map[string]resp.Reply, len(nodes))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			ret := cluster.execBefore(node, c, args, deadline)
+			mu.Lock()
+			result[node] = ret
+			mu.Unlock()
+		}(node)
 	}
+	wg.Wait()
 	return result
 }
+
+// execBefore 与 execLocally 相同，但最多等待到 deadline
+func (cluster *ClusterDatabase) execBefore(node string, c resp.Connection, args [][]byte, deadline time.Time) resp.Reply {
+	timeoutReply := reply.MakeErrReply("CLUSTERDOWN timeout waiting for node " + node)
+	if node != cluster.self {
+		future, err := cluster.relayAsync(node, c, utils.ToCmdLine2(localExecCmd, args...))
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		ret := cluster.waitRelay(node, future, time.Until(deadline))
+		if reply.IsErrorReply(ret) && !time.Now().Before(deadline) {
+			return timeoutReply
+		}
+		return ret
+	}
+	done := make(chan resp.Reply, 1)
+	go func() {
+		done <- cluster.db.Exec(c, args)
+	}()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case ret := <-done:
+		return ret
+	case <-timer.C:
+		return timeoutReply
+	}
+}
+
+// firstError 返回广播结果中的第一个错误
+func firstError(replies map[string]resp.Reply) reply.ErrorReply {
+	for _, v := range replies {
+		if errReply, ok := v.(reply.ErrorReply); ok {
+			return errReply
+		}
+	}
+	return nil
+}
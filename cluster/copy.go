@@ -0,0 +1,89 @@
+package cluster
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// Copy 复制 key，源 key 与目标 key 位于不同节点时先从源节点 DUMP，再在目标节点 RESTORE
+// COPY source destination [DB destination-db] [REPLACE]
+func Copy(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 {
+		return reply.MakeArgNumErrReply("copy")
+	}
+	src, dest := string(args[1]), string(args[2])
+	srcPeer, destPeer := cluster.pickNode(src), cluster.pickNode(dest)
+	if srcPeer == destPeer {
+		return cluster.relay(srcPeer, c, args)
+	}
+	destDB := c.GetDBIndex()
+	replace := false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "DB":
+			if i+1 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			index, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return reply.MakeErrReply("ERR invalid DB index")
+			}
+			destDB = index
+			i++
+		case "REPLACE":
+			replace = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	// 源 key 不会被修改，DUMP 得到的快照与单节点 COPY 读取时的值一致
+	dumpReply := cluster.relay(srcPeer, c, utils.ToCmdLine("DUMP", src))
+	if reply.IsErrorReply(dumpReply) {
+		return dumpReply
+	}
+	payload, ok := dumpReply.(*reply.BulkReply)
+	if !ok {
+		// 源 key 不存在
+		return reply.MakeIntReply(0)
+	}
+	restoreArgs := utils.ToCmdLine2("RESTORE", []byte(dest), []byte("0"), payload.Arg)
+	if replace {
+		restoreArgs = append(restoreArgs, []byte("REPLACE"))
+	}
+	destConn := &connection.FakeConn{}
+	destConn.SelectDB(destDB)
+	ret := cluster.relay(destPeer, destConn, restoreArgs)
+	if errReply, ok := ret.(reply.ErrorReply); ok {
+		if strings.HasPrefix(errReply.Error(), "BUSYKEY") {
+			return reply.MakeIntReply(0)
+		}
+		return errReply
+	}
+	return reply.MakeIntReply(1)
+}
+
+// Touch 按节点分组转发 TOUCH，返回各节点存在的 key 数量之和
+func Touch(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("touch")
+	}
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		keys[i] = string(arg)
+	}
+	var count int64
+	for peer, group := range cluster.groupByNode(keys) {
+		ret := cluster.relay(peer, c, utils.ToCmdLine(append([]string{"TOUCH"}, group...)...))
+		intReply, ok := ret.(*reply.IntReply)
+		if !ok {
+			return reply.MakeErrReply("error occurs: unexpected reply " + string(ret.ToBytes()))
+		}
+		count += intReply.Code
+	}
+	return reply.MakeIntReply(count)
+}
@@ -0,0 +1,27 @@
+package cluster
+
+// crc16Table 是 CRC16-CCITT (XMODEM) 的查找表，多项式 0x1021，初始值 0，与 Redis 计算槽位使用的算法相同
+var crc16Table [256]uint16
+
+func init() {
+	for i := range crc16Table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+// crc16 计算 data 的 CRC16 (XMODEM) 校验值
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^data[i]]
+	}
+	return crc
+}
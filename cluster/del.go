@@ -2,32 +2,48 @@ package cluster
 
 import (
 	"go-redis/interface/resp"
+	"go-redis/lib/utils"
 	"go-redis/resp/reply"
+	"strings"
 )
 
-// Del 从集群中原子性地移除给定的键，这些键可以分布在任何节点上
+// Del 从集群中原子性地移除给定的键，这些键可以分布在任何节点上，同时处理 DEL 与 UNLINK
 // 如果给定的键分布在不同的节点上，Del 将使用 try-commit-catch 来移除它们
 func Del(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
-	// 广播命令到集群中的所有节点
-	replies := cluster.broadcast(c, args)
-	// 遍历所有节点的回复
-	var errReply reply.ErrorReply
+	cmdName := strings.ToLower(string(args[0]))
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		keys[i] = string(arg)
+	}
+	groups := cluster.groupByNode(keys)
+	if len(keys) == 1 {
+		return cluster.relayByKey(c, keys[0], args)
+	}
+	if len(groups) == 1 {
+		// 所有键位于同一节点，直接转发
+		for peer := range groups {
+			return cluster.relay(peer, c, args)
+		}
+	}
+	cmdLines := make(map[string]CmdLine, len(groups))
+	for peer, group := range groups {
+		cmdLines[peer] = utils.ToCmdLine(append([]string{cmdName}, group...)...)
+	}
+	replies, errReply := cluster.tccExec(c, cmdLines)
+	if errReply != nil {
+		return reply.MakeErrReply("error occurs: " + errReply.Error())
+	}
+	// 汇总各节点删除的数量
 	var deleted int64 = 0
 	for _, v := range replies {
-		if reply.IsErrorReply(v) {
-			errReply = v.(reply.ErrorReply)
-			break
-		}
 		intReply, ok := v.(*reply.IntReply)
 		if !ok {
-			errReply = reply.MakeErrReply("error")
+			return reply.MakeErrReply("error occurs: unexpected reply " + string(v.ToBytes()))
 		}
 		deleted += intReply.Code
 	}
-	// 如果没有错误回复，返回包含删除数量的整数回复
-	if errReply == nil {
-		return reply.MakeIntReply(deleted)
-	}
-	// 如果有错误回复，返回包含错误信息的错误回复
-	return reply.MakeErrReply("error occurs: " + errReply.Error())
+	return reply.MakeIntReply(deleted)
 }
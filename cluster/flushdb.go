@@ -7,20 +7,24 @@ import (
 
 // FlushDB 移除当前集群数据库中的所有数据
 func FlushDB(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
-	// 广播命令到集群中的所有节点
+	return execOnAllNodes(cluster, c, args)
+}
+
+// FlushAll 移除集群中所有数据库的数据
+func FlushAll(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	return execOnAllNodes(cluster, c, args)
+}
+
+// SwapDB 在所有节点上交换两个数据库
+func SwapDB(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	return execOnAllNodes(cluster, c, args)
+}
+
+func execOnAllNodes(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	// 广播命令到集群中的所有节点，检查所有节点的回复，如果有错误回复则返回错误
 	replies := cluster.broadcast(c, args)
-	// 检查所有节点的回复，如果有错误回复则返回错误
-	var errReply reply.ErrorReply
-	for _, v := range replies {
-		if reply.IsErrorReply(v) {
-			errReply = v.(reply.ErrorReply)
-			break
-		}
-	}
-	// 如果没有错误回复，返回成功回复
-	if errReply == nil {
-		return &reply.OkReply{}
+	if errReply := firstError(replies); errReply != nil {
+		return reply.MakeErrReply("error occurs: " + errReply.Error())
 	}
-	// 如果有错误回复，返回包含错误信息的错误回复
-	return reply.MakeErrReply("error occurs: " + errReply.Error())
+	return &reply.OkReply{}
 }
@@ -0,0 +1,378 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"go-redis/config"
+	"go-redis/lib/logger"
+	"go-redis/resp/client"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// 集群总线上的请求类型
+const (
+	busGossip       = "gossip"
+	busRaftVote     = "raft-vote"
+	busRaftAppend   = "raft-append"
+	busRaftSnapshot = "raft-snapshot"
+	busRaftPropose  = "raft-propose"
+	busPeerToken    = "peer-token"
+)
+
+// gossip 消息类型
+const (
+	msgPing = "ping"
+	msgPong = "pong"
+	msgFail = "fail" // 通知其他节点某个节点已被确认下线
+)
+
+const (
+	defaultNodeTimeout = 15 * time.Second
+	gossipTick         = 100 * time.Millisecond
+	// 每隔多少个 tick 随机 ping 一个节点
+	randomPingTicks = 10
+	// 每条消息中携带的其他节点信息数量
+	gossipWanted = 3
+)
+
+// busMessage 是集群总线上传输的请求，每个连接只传输一次请求和一次响应
+type busMessage struct {
+	Type string          `json:"type"`
+	Body json.RawMessage `json:"body"`
+}
+
+// gossipNode 是消息中携带的节点信息
+type gossipNode struct {
+	Addr    string `json:"addr"`
+	BusAddr string `json:"bus"`
+	Flag    string `json:"flag,omitempty"`
+}
+
+// gossipMessage 用于探测节点是否在线并传播节点的下线状态
+type gossipMessage struct {
+	Type     string       `json:"type"`
+	Sender   gossipNode   `json:"sender"`
+	Gossip   []gossipNode `json:"gossip,omitempty"`
+	FailNode string       `json:"failNode,omitempty"`
+}
+
+// startBus 启动集群总线监听及 gossip 定时任务
+func (cluster *ClusterDatabase) startBus(listenAddr string) error {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	// 开启 tls-cluster 时集群总线也使用 TLS，节点之间使用 tls-cert-file 互相认证
+	if config.Properties.TLSCluster {
+		tlsConfig, err := config.Properties.TLSServerConfig()
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	cluster.busListener = listener
+	logger.Info("cluster bus listening on " + listenAddr)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go cluster.handleBusConn(conn)
+		}
+	}()
+	go cluster.gossipCron()
+	return nil
+}
+
+// handleBusConn 处理一条集群总线连接：读取一个请求，交给 gossip 或 Raft 处理后写回响应
+func (cluster *ClusterDatabase) handleBusConn(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(cluster.nodeTimeout))
+	msg := &busMessage{}
+	if err := json.NewDecoder(conn).Decode(msg); err != nil {
+		return
+	}
+	var resp interface{}
+	var err error
+	switch msg.Type {
+	case busGossip:
+		req := &gossipMessage{}
+		if err = json.Unmarshal(msg.Body, req); err == nil {
+			cluster.processMessage(req)
+			if req.Type == msgPing {
+				resp = cluster.buildMessage(msgPong, req.Sender.Addr)
+			}
+		}
+	case busRaftVote:
+		req := &voteRequest{}
+		if err = json.Unmarshal(msg.Body, req); err == nil {
+			resp = cluster.raft.handleVote(req)
+		}
+	case busRaftAppend:
+		req := &appendRequest{}
+		if err = json.Unmarshal(msg.Body, req); err == nil {
+			resp = cluster.raft.handleAppend(req)
+		}
+	case busRaftSnapshot:
+		req := &snapshotRequest{}
+		if err = json.Unmarshal(msg.Body, req); err == nil {
+			resp = cluster.raft.handleSnapshot(req)
+		}
+	case busRaftPropose:
+		req := &proposeRequest{}
+		if err = json.Unmarshal(msg.Body, req); err == nil {
+			resp = cluster.raft.handlePropose(req)
+		}
+	case busPeerToken:
+		req := &peerTokenRequest{}
+		if err = json.Unmarshal(msg.Body, req); err == nil {
+			if ret := cluster.handlePeerToken(req); ret != nil {
+				resp = ret
+			}
+		}
+	}
+	if err != nil || resp == nil {
+		return
+	}
+	_ = json.NewEncoder(conn).Encode(resp)
+}
+
+// callBus 通过集群总线向节点发送请求，resp 为 nil 时不等待响应
+func (cluster *ClusterDatabase) callBus(busAddr string, msgType string, req interface{}, resp interface{}, timeout time.Duration) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	conn, err := client.Dial(busAddr, timeout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if err = json.NewEncoder(conn).Encode(&busMessage{Type: msgType, Body: body}); err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(conn).Decode(resp)
+}
+
+// buildMessage 构造携带本节点状态的消息
+func (cluster *ClusterDatabase) buildMessage(msgType string, target string) *gossipMessage {
+	t := cluster.topology
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	self := t.nodes[cluster.self]
+	msg := &gossipMessage{
+		Type: msgType,
+		Sender: gossipNode{
+			Addr:    self.addr,
+			BusAddr: self.busAddr,
+		},
+	}
+	// 随机挑选若干节点，疑似下线的节点总是包含在内以便尽快达成下线共识
+	candidates := make([]*clusterNode, 0, len(t.nodes))
+	for addr, node := range t.nodes {
+		if addr == cluster.self || addr == target {
+			continue
+		}
+		if node.flag != "" {
+			msg.Gossip = append(msg.Gossip, toGossipNode(node))
+		} else {
+			candidates = append(candidates, node)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	for i := 0; i < len(candidates) && i < gossipWanted; i++ {
+		msg.Gossip = append(msg.Gossip, toGossipNode(candidates[i]))
+	}
+	return msg
+}
+
+func toGossipNode(node *clusterNode) gossipNode {
+	return gossipNode{
+		Addr:    node.addr,
+		BusAddr: node.busAddr,
+		Flag:    node.flag,
+	}
+}
+
+// processMessage 根据收到的消息更新节点的在线状态，成员列表之外的节点一律忽略
+func (cluster *ClusterDatabase) processMessage(msg *gossipMessage) {
+	t := cluster.topology
+	sender := msg.Sender.Addr
+	if sender == "" || sender == cluster.self {
+		return
+	}
+	t.mu.Lock()
+	node, ok := t.nodes[sender]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+	node.pongRecv = time.Now()
+	if msg.Type == msgPong {
+		node.pingSent = time.Time{}
+	}
+	if node.flag != "" {
+		logger.Info(fmt.Sprintf("node %s is reachable again", sender))
+		node.flag = ""
+	}
+	t.mu.Unlock()
+
+	if msg.Type == msgFail {
+		cluster.markFailed(msg.FailNode)
+		return
+	}
+	for _, g := range msg.Gossip {
+		if g.Addr == cluster.self {
+			continue
+		}
+		t.mu.Lock()
+		if target, ok := t.nodes[g.Addr]; ok {
+			if g.Flag != "" {
+				target.failReports[sender] = time.Now()
+			} else {
+				delete(target.failReports, sender)
+			}
+		}
+		t.mu.Unlock()
+		cluster.markFailIfNeeded(g.Addr)
+	}
+}
+
+// gossipCron 定期向其他节点发送 ping 并检测节点下线
+func (cluster *ClusterDatabase) gossipCron() {
+	ticker := time.NewTicker(gossipTick)
+	defer ticker.Stop()
+	tick := 0
+	for {
+		select {
+		case <-cluster.stopBus:
+			return
+		case <-ticker.C:
+		}
+		tick++
+		now := time.Now()
+		targets := make([]string, 0)
+		pfail := make([]string, 0)
+		t := cluster.topology
+		t.mu.Lock()
+		others := make([]string, 0, len(t.nodes))
+		for addr, node := range t.nodes {
+			if addr == cluster.self {
+				continue
+			}
+			others = append(others, addr)
+			// 超过半个超时时间未收到消息的节点需要立即 ping
+			if node.pingSent.IsZero() && now.Sub(node.pongRecv) > cluster.nodeTimeout/2 {
+				targets = append(targets, addr)
+			}
+			if !node.pingSent.IsZero() && now.Sub(node.pingSent) > cluster.nodeTimeout && node.flag == "" {
+				node.flag = flagPFail
+				pfail = append(pfail, addr)
+			}
+		}
+		t.mu.Unlock()
+		if tick%randomPingTicks == 0 && len(others) > 0 {
+			targets = append(targets, others[rand.Intn(len(others))])
+		}
+		for _, addr := range targets {
+			cluster.ping(addr)
+		}
+		for _, addr := range pfail {
+			logger.Warn(fmt.Sprintf("node %s is possibly failing", addr))
+			cluster.markFailIfNeeded(addr)
+		}
+	}
+}
+
+// ping 异步向节点发送 ping，收到回复后处理其中的状态
+func (cluster *ClusterDatabase) ping(addr string) {
+	t := cluster.topology
+	t.mu.Lock()
+	node, ok := t.nodes[addr]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+	if node.pingSent.IsZero() {
+		node.pingSent = time.Now()
+	}
+	busAddr := node.busAddr
+	t.mu.Unlock()
+
+	msg := cluster.buildMessage(msgPing, addr)
+	go func() {
+		pong := &gossipMessage{}
+		if err := cluster.callBus(busAddr, busGossip, msg, pong, cluster.nodeTimeout/2); err != nil {
+			return
+		}
+		cluster.processMessage(pong)
+	}()
+}
+
+// markFailIfNeeded 当多数节点都认为某节点疑似下线时，将其标记为下线并通知所有节点
+func (cluster *ClusterDatabase) markFailIfNeeded(addr string) {
+	t := cluster.topology
+	t.mu.Lock()
+	node, ok := t.nodes[addr]
+	if !ok || node.flag != flagPFail {
+		t.mu.Unlock()
+		return
+	}
+	// 超过两倍超时时间的报告视为过期
+	now := time.Now()
+	for reporter, reportTime := range node.failReports {
+		if now.Sub(reportTime) > 2*cluster.nodeTimeout {
+			delete(node.failReports, reporter)
+		}
+	}
+	quorum := len(t.nodes)/2 + 1
+	if len(node.failReports)+1 < quorum {
+		t.mu.Unlock()
+		return
+	}
+	node.flag = flagFail
+	t.mu.Unlock()
+
+	logger.Warn(fmt.Sprintf("node %s is marked as failed", addr))
+	msg := cluster.buildMessage(msgFail, addr)
+	msg.FailNode = addr
+	for _, peer := range t.getNodes() {
+		if peer == cluster.self || peer == addr {
+			continue
+		}
+		if n, ok := t.getNode(peer); ok {
+			go func(busAddr string) {
+				_ = cluster.callBus(busAddr, busGossip, msg, nil, cluster.nodeTimeout/2)
+			}(n.busAddr)
+		}
+	}
+}
+
+// markFailed 处理其他节点发来的 FAIL 消息
+func (cluster *ClusterDatabase) markFailed(addr string) {
+	if addr == cluster.self {
+		return
+	}
+	t := cluster.topology
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if node, ok := t.nodes[addr]; ok && node.flag != flagFail {
+		node.flag = flagFail
+		logger.Warn(fmt.Sprintf("node %s is marked as failed by cluster", addr))
+	}
+}
@@ -0,0 +1,199 @@
+package cluster
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+// makeTestGossipCluster 创建拓扑中包含 self 与 others 的节点，other 的集群总线地址上没有监听
+func makeTestGossipCluster(t *testing.T, nodeTimeout time.Duration, others ...string) *ClusterDatabase {
+	cluster := &ClusterDatabase{
+		self:        testSelf,
+		topology:    makeTopology(testSelf),
+		nodeTimeout: nodeTimeout,
+		stopBus:     make(chan struct{}),
+	}
+	for _, addr := range append([]string{testSelf}, others...) {
+		cluster.topology.addNode(addr, defaultBusAddr(addr))
+	}
+	return cluster
+}
+
+// setFlag 修改节点的状态标志
+func setFlag(cluster *ClusterDatabase, addr string, flag string) {
+	t := cluster.topology
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nodes[addr].flag = flag
+}
+
+func flagOf(t *testing.T, cluster *ClusterDatabase, addr string) string {
+	t.Helper()
+	node, ok := cluster.topology.getNode(addr)
+	if !ok {
+		t.Fatalf("%s is not in the topology", addr)
+	}
+	return node.flag
+}
+
+func pingFrom(addr string, gossip ...gossipNode) *gossipMessage {
+	return &gossipMessage{Type: msgPing, Sender: gossipNode{Addr: addr, BusAddr: defaultBusAddr(addr)}, Gossip: gossip}
+}
+
+// TestGossipCronMarksPFail 超过 cluster-node-timeout 未回应 ping 的节点被标记为 pfail，收到其消息后恢复
+func TestGossipCronMarksPFail(t *testing.T) {
+	const peer = "127.0.0.1:6401"
+	cluster := makeTestGossipCluster(t, 200*time.Millisecond, peer, "127.0.0.1:6402")
+	go cluster.gossipCron()
+	defer close(cluster.stopBus)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for flagOf(t, cluster, peer) != flagPFail {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is not marked as pfail", peer)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	// 没有其他节点的报告时不能确认下线
+	time.Sleep(200 * time.Millisecond)
+	if flag := flagOf(t, cluster, peer); flag != flagPFail {
+		t.Errorf("flag of %s = %q without fail reports", peer, flag)
+	}
+
+	cluster.processMessage(&gossipMessage{Type: msgPong, Sender: gossipNode{Addr: peer}})
+	node, _ := cluster.topology.getNode(peer)
+	if node.flag != "" || !node.pingSent.IsZero() {
+		t.Errorf("flag = %q, pingSent = %v after receiving a pong", node.flag, node.pingSent)
+	}
+}
+
+// TestFailReports 多数节点报告疑似下线时将 pfail 提升为 fail
+func TestFailReports(t *testing.T) {
+	const (
+		failing   = "127.0.0.1:6401"
+		reporter1 = "127.0.0.1:6402"
+		reporter2 = "127.0.0.1:6403"
+		reporter3 = "127.0.0.1:6404"
+	)
+	pfail := gossipNode{Addr: failing, Flag: flagPFail}
+	online := gossipNode{Addr: failing}
+	tests := []struct {
+		name     string
+		flag     string // 本节点对 failing 的判断
+		messages []*gossipMessage
+		want     string
+	}{
+		{"no reports", flagPFail, nil, flagPFail},
+		{"minority", flagPFail, []*gossipMessage{pingFrom(reporter1, pfail)}, flagPFail},
+		{"majority", flagPFail, []*gossipMessage{pingFrom(reporter1, pfail), pingFrom(reporter2, pfail)}, flagFail},
+		{"same reporter twice", flagPFail, []*gossipMessage{pingFrom(reporter1, pfail), pingFrom(reporter1, pfail)}, flagPFail},
+		{
+			name:     "report withdrawn",
+			flag:     flagPFail,
+			messages: []*gossipMessage{pingFrom(reporter1, pfail), pingFrom(reporter1, online), pingFrom(reporter2, pfail)},
+			want:     flagPFail,
+		},
+		// 本节点仍能与其通信时不采纳其他节点的报告
+		{"reachable from self", "", []*gossipMessage{pingFrom(reporter1, pfail), pingFrom(reporter2, pfail), pingFrom(reporter3, pfail)}, ""},
+		{
+			name:     "fail message",
+			flag:     "",
+			messages: []*gossipMessage{{Type: msgFail, Sender: gossipNode{Addr: reporter1}, FailNode: failing}},
+			want:     flagFail,
+		},
+		// 集群成员之外的节点发来的消息被忽略
+		{"unknown reporters", flagPFail, []*gossipMessage{pingFrom("127.0.0.1:7000", pfail), pingFrom("127.0.0.1:7001", pfail)}, flagPFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := makeTestGossipCluster(t, time.Second, failing, reporter1, reporter2, reporter3)
+			setFlag(cluster, failing, tt.flag)
+			for _, msg := range tt.messages {
+				cluster.processMessage(msg)
+			}
+			if got := flagOf(t, cluster, failing); got != tt.want {
+				t.Errorf("flag of %s = %q, want %q", failing, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExpiredFailReports 超过两倍 cluster-node-timeout 的报告不再计入
+func TestExpiredFailReports(t *testing.T) {
+	const failing = "127.0.0.1:6401"
+	cluster := makeTestGossipCluster(t, time.Second, failing, "127.0.0.1:6402", "127.0.0.1:6403")
+	setFlag(cluster, failing, flagPFail)
+	cluster.topology.mu.Lock()
+	cluster.topology.nodes[failing].failReports["127.0.0.1:6402"] = time.Now().Add(-3 * time.Second)
+	cluster.topology.mu.Unlock()
+	cluster.processMessage(pingFrom("127.0.0.1:6403", gossipNode{Addr: failing, Flag: flagPFail}))
+	if got := flagOf(t, cluster, failing); got != flagPFail {
+		t.Errorf("flag of %s = %q with an expired report", failing, got)
+	}
+	node, _ := cluster.topology.getNode(failing)
+	if len(node.failReports) != 1 {
+		t.Errorf("fail reports = %v, the expired report is not removed", node.failReports)
+	}
+}
+
+// TestFailedNodeRecovers 被确认下线的节点重新发来消息后恢复在线
+func TestFailedNodeRecovers(t *testing.T) {
+	const peer = "127.0.0.1:6401"
+	cluster := makeTestGossipCluster(t, time.Second, peer)
+	setFlag(cluster, peer, flagFail)
+	cluster.processMessage(pingFrom(peer))
+	if got := flagOf(t, cluster, peer); got != "" {
+		t.Errorf("flag of %s = %q after receiving its ping", peer, got)
+	}
+	// 其他节点发来的关于本节点的 FAIL 消息被忽略
+	cluster.processMessage(&gossipMessage{Type: msgFail, Sender: gossipNode{Addr: peer}, FailNode: testSelf})
+	if got := flagOf(t, cluster, testSelf); got != "" {
+		t.Errorf("self is marked as %q", got)
+	}
+}
+
+// TestHandleBusPing 集群总线收到 ping 后回复携带本节点信息的 pong
+func TestHandleBusPing(t *testing.T) {
+	const peer = "127.0.0.1:6401"
+	cluster := makeTestGossipCluster(t, time.Second, peer, "127.0.0.1:6402")
+	setFlag(cluster, "127.0.0.1:6402", flagPFail)
+	server, client := net.Pipe()
+	defer func() {
+		_ = client.Close()
+	}()
+	go cluster.handleBusConn(server)
+
+	body, err := json.Marshal(pingFrom(peer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SetDeadline(time.Now().Add(time.Second))
+	if err = json.NewEncoder(client).Encode(&busMessage{Type: busGossip, Body: body}); err != nil {
+		t.Fatal(err)
+	}
+	pong := &gossipMessage{}
+	if err = json.NewDecoder(client).Decode(pong); err != nil {
+		t.Fatal(err)
+	}
+	if pong.Type != msgPong || pong.Sender.Addr != testSelf || pong.Sender.BusAddr != defaultBusAddr(testSelf) {
+		t.Errorf("reply = %+v", pong)
+	}
+	// 疑似下线的节点总是包含在 gossip 中，不包含接收方自己
+	found := false
+	for _, g := range pong.Gossip {
+		if g.Addr == peer {
+			t.Errorf("gossip contains the receiver")
+		}
+		if g.Addr == "127.0.0.1:6402" && g.Flag == flagPFail {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("gossip %+v does not contain the pfail node", pong.Gossip)
+	}
+	if node, _ := cluster.topology.getNode(peer); time.Since(node.pongRecv) > time.Second {
+		t.Errorf("pongRecv of %s is not updated", peer)
+	}
+}
@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"fmt"
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Info 返回本节点的 INFO，其中 keyspace 部分汇总了所有节点的数据
+func Info(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	replies := cluster.broadcast(c, args)
+	text, ok := infoText(replies[cluster.self])
+	if !ok {
+		return replies[cluster.self]
+	}
+	text = clusterModeReplacer.Replace(text)
+	// 汇总各节点 keyspace 部分中每个数据库的 key 数量
+	keys := make(map[int]int64)
+	for _, v := range replies {
+		if nodeText, ok := infoText(v); ok {
+			for dbIndex, count := range parseKeyspace(nodeText) {
+				keys[dbIndex] += count
+			}
+		}
+	}
+	start := strings.Index(text, "# Keyspace\r\n")
+	if start < 0 {
+		return reply.MakeVerbatimReply("txt", []byte(text))
+	}
+	end := len(text)
+	if next := strings.Index(text[start+2:], "# "); next >= 0 {
+		end = start + 2 + next
+	}
+	dbIndexes := make([]int, 0, len(keys))
+	for dbIndex := range keys {
+		dbIndexes = append(dbIndexes, dbIndex)
+	}
+	sort.Ints(dbIndexes)
+	var builder strings.Builder
+	builder.WriteString("# Keyspace\r\n")
+	for _, dbIndex := range dbIndexes {
+		builder.WriteString(fmt.Sprintf("db%d:keys=%d,expires=0,avg_ttl=0\r\n", dbIndex, keys[dbIndex]))
+	}
+	if end < len(text) {
+		builder.WriteString("\r\n")
+	}
+	return reply.MakeVerbatimReply("txt", []byte(text[:start]+builder.String()+text[end:]))
+}
+
+// infoText 返回 INFO 回复的文本，本节点返回 verbatim 字符串，其他节点经 RESP2 返回 bulk 字符串
+func infoText(r resp.Reply) (string, bool) {
+	switch v := r.(type) {
+	case *reply.VerbatimReply:
+		return string(v.Text), true
+	case *reply.BulkReply:
+		return string(v.Arg), true
+	}
+	return "", false
+}
+
+// clusterModeReplacer 将单机数据库报告的运行模式改为集群模式
+var clusterModeReplacer = strings.NewReplacer(
+	"redis_mode:standalone\r\n", "redis_mode:cluster\r\n",
+	"cluster_enabled:0\r\n", "cluster_enabled:1\r\n",
+)
+
+// parseKeyspace 解析 INFO 中形如 db0:keys=1,expires=0,avg_ttl=0 的行
+func parseKeyspace(text string) map[int]int64 {
+	result := make(map[int]int64)
+	for _, line := range strings.Split(text, "\r\n") {
+		if !strings.HasPrefix(line, "db") {
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		dbIndex, err := strconv.Atoi(line[2:colon])
+		if err != nil {
+			continue
+		}
+		for _, field := range strings.Split(line[colon+1:], ",") {
+			if strings.HasPrefix(field, "keys=") {
+				count, err := strconv.ParseInt(field[len("keys="):], 10, 64)
+				if err == nil {
+					result[dbIndex] += count
+				}
+			}
+		}
+	}
+	return result
+}
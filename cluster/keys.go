@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"math/rand"
+	"strconv"
+)
+
+// Keys 在所有节点上并行执行 KEYS 并合并结果
+func Keys(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("keys")
+	}
+	replies := cluster.broadcast(c, args)
+	if errReply := firstError(replies); errReply != nil {
+		return reply.MakeErrReply("error occurs: " + errReply.Error())
+	}
+	result := make([][]byte, 0)
+	for _, v := range replies {
+		if multiBulk, ok := v.(*reply.MultiBulkReply); ok {
+			result = append(result, multiBulk.Args...)
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// DBSize 汇总所有节点当前数据库的 key 数量
+func DBSize(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("dbsize")
+	}
+	replies := cluster.broadcast(c, args)
+	if errReply := firstError(replies); errReply != nil {
+		return reply.MakeErrReply("error occurs: " + errReply.Error())
+	}
+	var total int64 = 0
+	for _, v := range replies {
+		intReply, ok := v.(*reply.IntReply)
+		if !ok {
+			return reply.MakeErrReply("error occurs: unexpected reply " + string(v.ToBytes()))
+		}
+		total += intReply.Code
+	}
+	return reply.MakeIntReply(total)
+}
+
+// RandomKey 从所有节点各取一个随机 key，再从中随机返回一个
+func RandomKey(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("randomkey")
+	}
+	replies := cluster.broadcast(c, args)
+	candidates := make([][]byte, 0, len(replies))
+	for _, v := range replies {
+		if bulk, ok := v.(*reply.BulkReply); ok {
+			candidates = append(candidates, bulk.Arg)
+		}
+	}
+	if len(candidates) == 0 {
+		if errReply := firstError(replies); errReply != nil {
+			return reply.MakeErrReply("error occurs: " + errReply.Error())
+		}
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeBulkReply(candidates[rand.Intn(len(candidates))])
+}
+
+// scanNodeBits 是集群 SCAN 游标中用于记录节点下标的低位数
+const scanNodeBits = 10
+
+// Scan 依次遍历每个节点，游标的低 10 位为节点在有序成员列表中的下标，其余位为该节点上的游标
+// 一个节点遍历完成后，返回的游标指向下一个节点的起点，全部节点遍历完成后返回 0
+func Scan(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("scan")
+	}
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR invalid cursor")
+	}
+	nodes := cluster.topology.getNodes()
+	nodeIndex := int(cursor & (1<<scanNodeBits - 1))
+	localCursor := cursor >> scanNodeBits
+	if nodeIndex >= len(nodes) {
+		// 成员减少后旧游标可能越界，视为遍历结束
+		return reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("0")),
+			reply.MakeEmptyMultiBulkReply(),
+		})
+	}
+
+	cmdLine := make([][]byte, len(args))
+	copy(cmdLine, args)
+	cmdLine[1] = []byte(strconv.FormatUint(localCursor, 10))
+	ret := cluster.execLocally(nodes[nodeIndex], c, cmdLine)
+	if reply.IsErrorReply(ret) {
+		return ret
+	}
+	scanReply, ok := ret.(*reply.MultiRawReply)
+	if !ok || len(scanReply.Replies) != 2 {
+		return reply.MakeErrReply("error occurs: unexpected reply " + string(ret.ToBytes()))
+	}
+	cursorReply, ok := scanReply.Replies[0].(*reply.BulkReply)
+	if !ok {
+		return reply.MakeErrReply("error occurs: unexpected cursor " + string(scanReply.Replies[0].ToBytes()))
+	}
+	next, err := strconv.ParseUint(string(cursorReply.Arg), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("error occurs: unexpected cursor " + string(cursorReply.Arg))
+	}
+	var nextCursor uint64
+	if next != 0 {
+		nextCursor = next<<scanNodeBits | uint64(nodeIndex)
+	} else if nodeIndex+1 < len(nodes) {
+		nextCursor = uint64(nodeIndex + 1)
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.FormatUint(nextCursor, 10))),
+		scanReply.Replies[1],
+	})
+}
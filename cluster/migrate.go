@@ -0,0 +1,442 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"net"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRebalanceBatch = 100
+	migrateTimeout        = "5000"
+)
+
+// execMigrate 将 MIGRATE 转发到 key 所在的节点执行，所有 key 必须位于同一节点
+func execMigrate(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 6 {
+		return reply.MakeArgNumErrReply("migrate")
+	}
+	keys := make([]string, 0)
+	if len(args[3]) > 0 {
+		keys = append(keys, string(args[3]))
+	}
+	for i := 6; i < len(args); i++ {
+		if strings.ToUpper(string(args[i])) == "KEYS" {
+			for _, key := range args[i+1:] {
+				keys = append(keys, string(key))
+			}
+			break
+		}
+	}
+	if len(keys) == 0 {
+		// 交由本地数据库返回语法错误
+		return cluster.db.Exec(c, args)
+	}
+	peer := cluster.pickNode(keys[0])
+	for _, key := range keys[1:] {
+		if cluster.pickNode(key) != peer {
+			return reply.MakeErrReply("ERR MIGRATE keys must be within one node in cluster mode")
+		}
+	}
+	return cluster.relay(peer, c, args)
+}
+
+// execCluster 处理 CLUSTER 子命令
+// MEET、FORGET 与 SETSLOT NODE 通过 Raft 在全体节点上生效，其余子命令只作用于当前节点
+func execCluster(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("cluster")
+	}
+	subCmd := strings.ToLower(string(args[1]))
+	switch subCmd {
+	case "keyslot":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("cluster|keyslot")
+		}
+		return reply.MakeIntReply(int64(getSlot(string(args[2]))))
+	case "nodes":
+		return execClusterNodes(cluster)
+	case "info":
+		return execClusterInfo(cluster)
+	case "myid":
+		return reply.MakeBulkReply([]byte(cluster.self))
+	case "peerauth":
+		return execPeerAuth(cluster, c, args[2:])
+	case "meet":
+		return execMeet(cluster, args[2:])
+	case "forget":
+		return execForget(cluster, args[2:])
+	case "countkeysinslot":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("cluster|countkeysinslot")
+		}
+		r, err := parseSlotRange(string(args[2]))
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		return reply.MakeIntReply(int64(cluster.db.CountKeysInSlots(c.GetDBIndex(), r.start, r.end)))
+	case "getkeysinslot":
+		if len(args) != 4 {
+			return reply.MakeArgNumErrReply("cluster|getkeysinslot")
+		}
+		r, err := parseSlotRange(string(args[2]))
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		count, err := strconv.Atoi(string(args[3]))
+		if err != nil || count < 0 {
+			return reply.MakeErrReply("ERR Invalid number of keys")
+		}
+		return reply.MakeMultiBulkReply(cluster.localKeysInSlots(c, r, count))
+	case "setslot":
+		return execSetSlot(cluster, args[2:])
+	case "rebalance":
+		return execRebalance(cluster, args[2:])
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'")
+}
+
+// execClusterNodes 以 CLUSTER NODES 的格式返回各节点的状态及负责的槽位
+func execClusterNodes(cluster *ClusterDatabase) resp.Reply {
+	ranges := cluster.slots.ranges()
+	epochs, _ := cluster.raft.configEpochs()
+	var builder strings.Builder
+	for _, addr := range cluster.topology.getNodes() {
+		node, ok := cluster.topology.getNode(addr)
+		if !ok {
+			continue
+		}
+		flags := "master"
+		if addr == cluster.self {
+			flags = "myself,master"
+		}
+		linkState := "connected"
+		if node.flag != "" {
+			flags += "," + node.flag
+			linkState = "disconnected"
+		}
+		pingSent, pongRecv := unixMilli(node.pingSent), unixMilli(node.pongRecv)
+		if addr == cluster.self {
+			pingSent, pongRecv = 0, 0
+		}
+		_, busPort, _ := net.SplitHostPort(node.busAddr)
+		builder.WriteString(fmt.Sprintf("%s %s@%s %s - %d %d %d %s", addr, addr, busPort, flags,
+			pingSent, pongRecv, epochs[addr], linkState))
+		for _, r := range ranges[addr] {
+			builder.WriteString(" " + r.String())
+		}
+		builder.WriteString("\n")
+	}
+	return reply.MakeBulkReply([]byte(builder.String()))
+}
+
+// execClusterInfo 以 CLUSTER INFO 的格式返回集群状态及 Raft 状态
+func execClusterInfo(cluster *ClusterDatabase) resp.Reply {
+	ranges := cluster.slots.ranges()
+	assigned := 0
+	for _, list := range ranges {
+		for _, r := range list {
+			assigned += int(r.end-r.start) + 1
+		}
+	}
+	failed := 0
+	for _, addr := range cluster.topology.getNodes() {
+		if node, ok := cluster.topology.getNode(addr); ok && node.flag == flagFail {
+			failed++
+		}
+	}
+	state := "ok"
+	if assigned < SlotCount || failed > 0 {
+		state = "fail"
+	}
+	status := cluster.raft.status()
+	epochs, currentEpoch := cluster.raft.configEpochs()
+	lines := []string{
+		"cluster_state:" + state,
+		"cluster_slots_assigned:" + strconv.Itoa(assigned),
+		"cluster_known_nodes:" + strconv.Itoa(len(cluster.topology.getNodes())),
+		"cluster_size:" + strconv.Itoa(len(ranges)),
+		"cluster_current_epoch:" + strconv.FormatUint(currentEpoch, 10),
+		"cluster_my_epoch:" + strconv.FormatUint(epochs[cluster.self], 10),
+		"raft_role:" + status.role.String(),
+		"raft_term:" + strconv.FormatUint(status.term, 10),
+		"raft_leader:" + status.leader,
+		"raft_members:" + strconv.Itoa(status.members),
+		"raft_commit_index:" + strconv.FormatUint(status.commitIndex, 10),
+		"raft_last_applied:" + strconv.FormatUint(status.lastApplied, 10),
+	}
+	return reply.MakeBulkReply([]byte(strings.Join(lines, "\r\n") + "\r\n"))
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// execMeet 处理 CLUSTER MEET host port [bus-port]，将节点加入集群
+func execMeet(cluster *ClusterDatabase, args [][]byte) resp.Reply {
+	if len(args) != 2 && len(args) != 3 {
+		return reply.MakeArgNumErrReply("cluster|meet")
+	}
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply("ERR Invalid node address specified: " + string(args[0]) + ":" + string(args[1]))
+	}
+	addr := net.JoinHostPort(string(args[0]), strconv.Itoa(port))
+	busAddr := defaultBusAddr(addr)
+	if len(args) == 3 {
+		if _, err = strconv.Atoi(string(args[2])); err != nil {
+			return reply.MakeErrReply("ERR Invalid bus port specified: " + string(args[2]))
+		}
+		busAddr = net.JoinHostPort(string(args[0]), string(args[2]))
+	}
+	if cluster.raft.isMember(addr) {
+		return reply.MakeOkReply()
+	}
+	// 加入条目追加到 leader 的日志后新节点即成为成员并开始接收日志复制，提交后才加入拓扑参与路由
+	if err = cluster.propose(metaCommand{Op: opAddNode, Node: addr, BusAddr: busAddr}); err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+// execForget 处理 CLUSTER FORGET node，将节点移出集群
+func execForget(cluster *ClusterDatabase, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("cluster|forget")
+	}
+	addr := string(args[0])
+	if addr == cluster.self {
+		return reply.MakeErrReply("ERR I tried hard but I can't forget myself...")
+	}
+	if len(cluster.slots.ranges()[addr]) > 0 {
+		return reply.MakeErrReply("ERR Can't forget a node that still serves slots, move them first")
+	}
+	if !cluster.raft.isMember(addr) {
+		return reply.MakeErrReply("ERR Unknown node " + addr)
+	}
+	if err := cluster.propose(metaCommand{Op: opDelNode, Node: addr}); err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+// localKeysInSlots 通过按槽位建立的索引返回当前节点所选数据库中属于给定槽位区间的最多 count 个 key
+func (cluster *ClusterDatabase) localKeysInSlots(c resp.Connection, r *slotRange, count int) [][]byte {
+	result := make([][]byte, 0)
+	if count == 0 || c.GetDBIndex() >= config.Properties.Databases {
+		return result
+	}
+	for _, key := range cluster.db.KeysInSlots(c.GetDBIndex(), r.start, r.end, count) {
+		result = append(result, []byte(key))
+	}
+	return result
+}
+
+// execSetSlot 处理 CLUSTER SETSLOT <slot> IMPORTING|MIGRATING|NODE <node> 以及 CLUSTER SETSLOT <slot> STABLE
+// slot 也可以写成 start-end 的形式以批量设置一段连续的槽位
+func execSetSlot(cluster *ClusterDatabase, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("cluster|setslot")
+	}
+	r, err := parseSlotRange(string(args[0]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	action := strings.ToLower(string(args[1]))
+	if action == "stable" {
+		cluster.slots.setStable(r)
+		return reply.MakeOkReply()
+	}
+	if len(args) != 3 {
+		return reply.MakeSyntaxErrReply()
+	}
+	node := string(args[2])
+	if action != "importing" && action != "migrating" && action != "node" {
+		return reply.MakeSyntaxErrReply()
+	}
+	if !cluster.raft.isMember(node) {
+		return reply.MakeErrReply("ERR I don't know about node " + node)
+	}
+	switch action {
+	case "importing":
+		cluster.slots.setImporting(r, node)
+	case "migrating":
+		cluster.slots.setMigrating(r, node)
+	case "node":
+		// 槽位归属的变更需要经过 Raft 提交，提交后各节点在应用时清除迁移状态
+		if err = cluster.propose(metaCommand{Op: opSetSlot, Node: node, Slots: r.String()}); err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+	}
+	return reply.MakeOkReply()
+}
+
+// slotMove 描述一段槽位从一个节点迁往另一个节点
+type slotMove struct {
+	r    *slotRange
+	from string
+	to   string
+}
+
+// execRebalance 处理 CLUSTER REBALANCE [batch-size]，在后台将槽位均衡地迁移到所有节点
+func execRebalance(cluster *ClusterDatabase, args [][]byte) resp.Reply {
+	batch := defaultRebalanceBatch
+	if len(args) > 1 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if len(args) == 1 {
+		var err error
+		batch, err = strconv.Atoi(string(args[0]))
+		if err != nil || batch <= 0 {
+			return reply.MakeErrReply("ERR invalid batch size")
+		}
+	}
+	if cluster.rebalancing.Get() {
+		return reply.MakeErrReply("ERR rebalance already in progress")
+	}
+	cluster.rebalancing.Set(true)
+	moves := cluster.planRebalance()
+	go func() {
+		defer func() {
+			cluster.rebalancing.Set(false)
+			if err := recover(); err != nil {
+				logger.Error(fmt.Sprintf("rebalance panic: %v\n%s", err, string(debug.Stack())))
+			}
+		}()
+		for _, move := range moves {
+			if err := cluster.moveSlots(move, batch); err != nil {
+				logger.Error(fmt.Sprintf("rebalance stopped at slots %s: %v", move.r, err))
+				return
+			}
+			logger.Info(fmt.Sprintf("slots %s moved from %s to %s", move.r, move.from, move.to))
+		}
+		logger.Info("rebalance finished")
+	}()
+	return reply.MakeStatusReply(fmt.Sprintf("Rebalance started, %d slot ranges to move", len(moves)))
+}
+
+// planRebalance 计算使每个节点负责的槽位数量相等所需的最少迁移
+func (cluster *ClusterDatabase) planRebalance() []*slotMove {
+	owners := cluster.slots.snapshotOwners()
+	nodes := cluster.topology.getNodes()
+
+	// 每个节点的目标槽位数，余数分给排在前面的节点
+	target := make(map[string]int)
+	for i, node := range nodes {
+		target[node] = SlotCount / len(nodes)
+		if i < SlotCount%len(nodes) {
+			target[node]++
+		}
+	}
+	count := make(map[string]int)
+	for _, owner := range owners {
+		count[owner]++
+	}
+	// 槽位过多或已不在集群中的节点从高位开始让出槽位
+	donated := make([]uint32, 0)
+	for slot := SlotCount - 1; slot >= 0; slot-- {
+		owner := owners[slot]
+		if t, ok := target[owner]; !ok || count[owner] > t {
+			donated = append(donated, uint32(slot))
+			count[owner]--
+		}
+	}
+	sort.Slice(donated, func(i, j int) bool { return donated[i] < donated[j] })
+	newOwners := owners
+	i := 0
+	for _, node := range nodes {
+		for count[node] < target[node] && i < len(donated) {
+			newOwners[donated[i]] = node
+			count[node]++
+			i++
+		}
+	}
+
+	// 将相邻且迁移方向相同的槽位合并为区间
+	moves := make([]*slotMove, 0)
+	var last *slotMove
+	for slot := uint32(0); slot < SlotCount; slot++ {
+		from, to := owners[slot], newOwners[slot]
+		if from == to {
+			last = nil
+			continue
+		}
+		if last != nil && last.from == from && last.to == to && last.r.end == slot-1 {
+			last.r.end = slot
+			continue
+		}
+		last = &slotMove{r: &slotRange{start: slot, end: slot}, from: from, to: to}
+		moves = append(moves, last)
+	}
+	return moves
+}
+
+// moveSlots 将一段槽位中的数据分批迁移到目标节点，完成后通过 Raft 提交槽位的新归属
+// 迁移过程中源节点仍然处理已存在的 key，不存在的 key 会转交给目标节点，集群可以正常提供服务
+func (cluster *ClusterDatabase) moveSlots(move *slotMove, batch int) error {
+	fakeConn := &connection.FakeConn{}
+	slots := move.r.String()
+	if move.from != "" {
+		host, port, err := net.SplitHostPort(move.to)
+		if err != nil {
+			return err
+		}
+		ret := cluster.execOn(move.to, fakeConn, utils.ToCmdLine("CLUSTER", "SETSLOT", slots, "IMPORTING", move.from))
+		if reply.IsErrorReply(ret) {
+			return errors.New(string(ret.ToBytes()))
+		}
+		ret = cluster.execOn(move.from, fakeConn, utils.ToCmdLine("CLUSTER", "SETSLOT", slots, "MIGRATING", move.to))
+		if reply.IsErrorReply(ret) {
+			return errors.New(string(ret.ToBytes()))
+		}
+		for dbIndex := 0; dbIndex < config.Properties.Databases; dbIndex++ {
+			fakeConn.SelectDB(dbIndex)
+			for {
+				ret = cluster.execOn(move.from, fakeConn, utils.ToCmdLine("CLUSTER", "GETKEYSINSLOT", slots, strconv.Itoa(batch)))
+				if _, ok := ret.(*reply.EmptyMultiBulkReply); ok {
+					break
+				}
+				keysReply, ok := ret.(*reply.MultiBulkReply)
+				if !ok {
+					return errors.New("get keys in slot failed: " + string(ret.ToBytes()))
+				}
+				if len(keysReply.Args) == 0 {
+					break
+				}
+				cmdLine := utils.ToCmdLine("MIGRATE", host, port, "", strconv.Itoa(dbIndex), migrateTimeout, "REPLACE")
+				if config.Properties.RequirePass != "" {
+					cmdLine = append(cmdLine, []byte("AUTH"), []byte(config.Properties.RequirePass))
+				}
+				cmdLine = append(cmdLine, []byte("KEYS"))
+				cmdLine = append(cmdLine, keysReply.Args...)
+				ret = cluster.execOn(move.from, fakeConn, cmdLine)
+				if reply.IsErrorReply(ret) {
+					return errors.New(string(ret.ToBytes()))
+				}
+			}
+		}
+	}
+	return cluster.propose(metaCommand{Op: opSetSlot, Node: move.to, Slots: slots})
+}
+
+// execOn 在指定节点上执行命令，目标为自身时同样经过集群路由
+func (cluster *ClusterDatabase) execOn(peer string, c resp.Connection, args [][]byte) resp.Reply {
+	if peer == cluster.self {
+		return cluster.exec(c, args)
+	}
+	return cluster.relay(peer, c, args)
+}
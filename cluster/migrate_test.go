@@ -0,0 +1,36 @@
+package cluster
+
+import (
+	"go-redis/lib/utils"
+	"testing"
+)
+
+func TestSetSlotUnknownNode(t *testing.T) {
+	rf := makeTestRaftNode(t, t.TempDir())
+	rf.members = map[string]string{testSelf: "127.0.0.1:16399", testPeer: "127.0.0.1:16400"}
+	cluster := rf.cluster
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"100", "IMPORTING", "127.0.0.1:6401"}, "-ERR I don't know about node 127.0.0.1:6401\r\n"},
+		{[]string{"100", "MIGRATING", "127.0.0.1:6401"}, "-ERR I don't know about node 127.0.0.1:6401\r\n"},
+		{[]string{"100", "NODE", "127.0.0.1:6401"}, "-ERR I don't know about node 127.0.0.1:6401\r\n"},
+		{[]string{"100", "IMPORTING", testPeer}, "+OK\r\n"},
+		{[]string{"200", "MIGRATING", testPeer}, "+OK\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(execSetSlot(cluster, utils.ToCmdLine(tt.args...)).ToBytes()); got != tt.want {
+			t.Errorf("SETSLOT %v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+	if !cluster.slots.isImporting(100) {
+		t.Errorf("slot 100 is not importing")
+	}
+	if node, ok := cluster.slots.getMigrating(200); !ok || node != testPeer {
+		t.Errorf("slot 200 is migrating to %q, %v", node, ok)
+	}
+	if cluster.slots.isImporting(101) {
+		t.Errorf("slot 101 is importing")
+	}
+}
@@ -0,0 +1,76 @@
+package cluster
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"strings"
+)
+
+// groupPairsByNode 将 MSET/MSETNX 的键值对按键所在的节点分组，每组均为完整的命令
+func (cluster *ClusterDatabase) groupPairsByNode(args [][]byte) map[string]CmdLine {
+	cmdName := args[0]
+	result := make(map[string]CmdLine)
+	for i := 1; i+1 < len(args); i += 2 {
+		peer := cluster.pickNode(string(args[i]))
+		if _, ok := result[peer]; !ok {
+			result[peer] = CmdLine{cmdName}
+		}
+		result[peer] = append(result[peer], args[i], args[i+1])
+	}
+	return result
+}
+
+// MSet 原子性地设置多个键，键分布在多个节点上时使用 TCC 事务
+func MSet(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(args[0]))
+	if len(args) < 3 || len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	cmdLines := cluster.groupPairsByNode(args)
+	if len(cmdLines) == 1 {
+		for peer := range cmdLines {
+			return cluster.relay(peer, c, args)
+		}
+	}
+	_, errReply := cluster.tccExec(c, cmdLines)
+	if errReply != nil {
+		return reply.MakeErrReply("error occurs: " + errReply.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+// MSetNX 仅当所有键都不存在时设置它们，各节点在 Prepare 阶段检查键是否存在
+func MSetNX(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(args[0]))
+	if len(args) < 3 || len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	cmdLines := cluster.groupPairsByNode(args)
+	if len(cmdLines) == 1 {
+		for peer := range cmdLines {
+			return cluster.relay(peer, c, args)
+		}
+	}
+	_, errReply := cluster.tccExec(c, cmdLines)
+	if errReply != nil {
+		if errReply.Error() == errKeyExists {
+			return reply.MakeIntReply(0)
+		}
+		return reply.MakeErrReply("error occurs: " + errReply.Error())
+	}
+	return reply.MakeIntReply(1)
+}
+
+// prepareMSetNx 在锁定键之后检查是否已有键存在
+func prepareMSetNx(tx *Transaction) resp.Reply {
+	for _, key := range tx.writeKeys {
+		exists, errReply := tx.existsLocked(key)
+		if errReply != nil {
+			return errReply
+		}
+		if exists {
+			return reply.MakeErrReply(errKeyExists)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 节点间的内部命令只接受来自其他节点连接池的连接：
+// 每个节点启动时生成随机的 peer token，集群成员通过集群总线获取，
+// 连接池的每个新连接在 AUTH 之后发送 CLUSTER PEERAUTH token，通过校验的连接被标记为节点连接。
+// 普通客户端发送内部命令时与不存在的命令一样被拒绝。
+// 集群总线不要求认证，获取 peer token 的请求需要携带以 requirepass 为密钥的 HMAC，
+// 能连接集群总线但不知道 requirepass 的客户端无法获得 token，也就无法绕过 requirepass 执行内部命令。
+// 没有设置 requirepass 时任何客户端都能执行所有命令，token 只用于区分节点连接
+
+// peerTokenMaxSkew 请求的时间戳与本节点时间相差超过该值时拒绝，nonce 在该时间内只能使用一次
+const peerTokenMaxSkew = 30 * time.Second
+
+// internalCmds 只允许节点连接执行的命令，TCC 中的 RenameFrom 等子命令只能通过 Prepare 执行
+var internalCmds = map[string]struct{}{
+	prepareCmd:    {},
+	commitCmd:     {},
+	rollbackCmd:   {},
+	localExecCmd:  {},
+	askingExecCmd: {},
+}
+
+// peerTokenRequest 是节点通过集群总线获取 peer token 的请求
+type peerTokenRequest struct {
+	Sender    string `json:"sender"`
+	Timestamp int64  `json:"timestamp"` // 发送时的 unix 毫秒时间戳
+	Nonce     string `json:"nonce"`     // 随机生成，防止请求被重放
+	MAC       string `json:"mac"`       // 以 requirepass 为密钥对以上字段计算的 HMAC-SHA256
+}
+
+// peerTokenResponse 携带接收节点的 peer token
+type peerTokenResponse struct {
+	Token string `json:"token"`
+}
+
+// peerConn 是可以被标记为节点连接的客户端连接
+type peerConn interface {
+	ClusterPeer() bool
+	SetClusterPeer(peer bool)
+}
+
+// makePeerToken 生成随机的 peer token
+func makePeerToken() string {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// makePeerTokenRequest 生成 sender 获取 peer token 的请求
+func makePeerTokenRequest(sender string) *peerTokenRequest {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	req := &peerTokenRequest{
+		Sender:    sender,
+		Timestamp: time.Now().UnixMilli(),
+		Nonce:     hex.EncodeToString(nonce),
+	}
+	req.MAC = peerTokenMAC(req)
+	return req
+}
+
+// peerTokenMAC 以 requirepass 为密钥计算请求的 HMAC，集群中所有节点的 requirepass 必须相同
+func peerTokenMAC(req *peerTokenRequest) string {
+	mac := hmac.New(sha256.New, []byte(config.Properties.RequirePass))
+	mac.Write([]byte(req.Sender + "\n" + strconv.FormatInt(req.Timestamp, 10) + "\n" + req.Nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// nonceCache 记录 peerTokenMaxSkew 内使用过的 nonce，零值可以直接使用
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// add 记录 nonce，nonce 已使用过时返回 false
+func (c *nonceCache) add(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen == nil {
+		c.seen = make(map[string]time.Time)
+	}
+	// 超过时间窗口的请求会因时间戳被拒绝，不再需要记录其 nonce
+	for n, at := range c.seen {
+		if now.Sub(at) > 2*peerTokenMaxSkew {
+			delete(c.seen, n)
+		}
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	c.seen[nonce] = now
+	return true
+}
+
+// isClusterPeer 判断连接是否已通过 CLUSTER PEERAUTH 认证为节点连接
+func isClusterPeer(c resp.Connection) bool {
+	p, ok := c.(peerConn)
+	return ok && p.ClusterPeer()
+}
+
+// handlePeerToken 校验请求的 HMAC、时间戳与 nonce，只向集群成员返回 peer token
+func (cluster *ClusterDatabase) handlePeerToken(req *peerTokenRequest) *peerTokenResponse {
+	if _, ok := cluster.topology.getNode(req.Sender); !ok || req.Sender == cluster.self {
+		return nil
+	}
+	if !hmac.Equal([]byte(req.MAC), []byte(peerTokenMAC(req))) {
+		logger.Warn("reject peer token request from " + req.Sender + ": invalid mac")
+		return nil
+	}
+	now := time.Now()
+	skew := now.Sub(time.UnixMilli(req.Timestamp))
+	if skew > peerTokenMaxSkew || skew < -peerTokenMaxSkew {
+		logger.Warn("reject peer token request from " + req.Sender + ": timestamp is out of range")
+		return nil
+	}
+	if !cluster.peerNonces.add(req.Nonce, now) {
+		logger.Warn("reject peer token request from " + req.Sender + ": nonce is reused")
+		return nil
+	}
+	return &peerTokenResponse{Token: cluster.peerToken}
+}
+
+// peerHandshake 返回连接到 peer 时使用的握手命令，获取 peer token 失败时返回 nil，此时该连接不能执行内部命令
+func (cluster *ClusterDatabase) peerHandshake(peer string) func() [][]byte {
+	return func() [][]byte {
+		node, ok := cluster.topology.getNode(peer)
+		if !ok {
+			return nil
+		}
+		resp := &peerTokenResponse{}
+		if err := cluster.callBus(node.busAddr, busPeerToken, makePeerTokenRequest(cluster.self), resp, cluster.poolOpts.relayTimeout); err != nil {
+			logger.Warn("get peer token from " + peer + " failed: " + err.Error())
+			return nil
+		}
+		return utils.ToCmdLine("CLUSTER", "PEERAUTH", resp.Token)
+	}
+}
+
+// execPeerAuth 处理 CLUSTER PEERAUTH token，token 正确时将连接标记为节点连接
+func execPeerAuth(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("cluster|peerauth")
+	}
+	p, ok := c.(peerConn)
+	if !ok || subtle.ConstantTimeCompare(args[0], []byte(cluster.peerToken)) != 1 {
+		return reply.MakeErrReply("ERR invalid peer token")
+	}
+	p.SetClusterPeer(true)
+	return reply.MakeOkReply()
+}
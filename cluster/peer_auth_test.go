@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"go-redis/config"
+	"testing"
+	"time"
+)
+
+func TestHandlePeerToken(t *testing.T) {
+	old := config.Properties.RequirePass
+	t.Cleanup(func() {
+		config.Properties.RequirePass = old
+	})
+	config.Properties.RequirePass = "secret"
+	cluster := &ClusterDatabase{
+		self:      testSelf,
+		topology:  makeTopology(testSelf),
+		peerToken: makePeerToken(),
+	}
+	cluster.topology.addNode(testPeer, defaultBusAddr(testPeer))
+
+	// signed 修改请求后重新计算 MAC
+	signed := func(modify func(req *peerTokenRequest)) *peerTokenRequest {
+		req := makePeerTokenRequest(testPeer)
+		modify(req)
+		req.MAC = peerTokenMAC(req)
+		return req
+	}
+	replayed := makePeerTokenRequest(testPeer)
+	if ret := cluster.handlePeerToken(replayed); ret == nil || ret.Token != cluster.peerToken {
+		t.Fatalf("valid request is rejected")
+	}
+	tests := []struct {
+		name string
+		req  func() *peerTokenRequest
+	}{
+		{"replayed", func() *peerTokenRequest { return replayed }},
+		{"unknown sender", func() *peerTokenRequest { return makePeerTokenRequest("127.0.0.1:6401") }},
+		{"self", func() *peerTokenRequest { return makePeerTokenRequest(testSelf) }},
+		{"no mac", func() *peerTokenRequest {
+			return &peerTokenRequest{Sender: testPeer, Timestamp: time.Now().UnixMilli(), Nonce: "n"}
+		}},
+		{"wrong password", func() *peerTokenRequest {
+			config.Properties.RequirePass = "guess"
+			defer func() {
+				config.Properties.RequirePass = "secret"
+			}()
+			return makePeerTokenRequest(testPeer)
+		}},
+		{"tampered sender", func() *peerTokenRequest {
+			req := makePeerTokenRequest(testSelf)
+			req.Sender = testPeer
+			return req
+		}},
+		{"stale", func() *peerTokenRequest {
+			return signed(func(req *peerTokenRequest) {
+				req.Timestamp = time.Now().Add(-time.Minute).UnixMilli()
+			})
+		}},
+		{"from the future", func() *peerTokenRequest {
+			return signed(func(req *peerTokenRequest) {
+				req.Timestamp = time.Now().Add(time.Minute).UnixMilli()
+			})
+		}},
+	}
+	for _, tt := range tests {
+		if ret := cluster.handlePeerToken(tt.req()); ret != nil {
+			t.Errorf("%s: token is returned", tt.name)
+		}
+	}
+}
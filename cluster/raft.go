@@ -0,0 +1,1250 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-redis/config"
+	"go-redis/lib/logger"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 集群的成员与槽位归属等元数据通过一个精简的 Raft 实现达成一致：
+// 所有变更都先作为日志条目提交到多数节点，再由各节点按相同顺序应用，
+// 因此各节点路由所依据的槽位表总是某个已提交的状态，网络分区中的少数派无法修改它。
+// 成员变更一次只增删一个节点，各节点使用自己日志中最新的成员配置，无论该条目是否已提交，
+// 上一个成员变更提交之前 leader 不接受新的成员变更，因此新旧配置的多数派总是相交。
+
+const (
+	raftTick             = 50 * time.Millisecond
+	raftHeartbeat        = 200 * time.Millisecond
+	raftElectionTimeout  = 1500 * time.Millisecond // 实际超时在 [timeout, 2*timeout) 之间随机
+	raftRPCTimeout       = time.Second
+	raftProposeTimeout   = 5 * time.Second
+	raftSnapshotInterval = 256 // 日志超过该条数时生成快照并截断日志
+	raftMaxAppend        = 64  // 单次 AppendEntries 最多携带的条目数
+)
+
+type raftRole int
+
+const (
+	roleFollower raftRole = iota
+	roleCandidate
+	roleLeader
+)
+
+func (role raftRole) String() string {
+	switch role {
+	case roleLeader:
+		return "leader"
+	case roleCandidate:
+		return "candidate"
+	}
+	return "follower"
+}
+
+// 元数据操作类型
+const (
+	opNoop      = "noop"      // 新 leader 上任时追加，用于提交之前任期的条目
+	opBootstrap = "bootstrap" // 初始成员，槽位在其中平均分配
+	opAddNode   = "addnode"
+	opDelNode   = "delnode"
+	opSetSlot   = "setslot" // 将一段槽位分配给节点
+)
+
+// metaCommand 是一条集群元数据变更
+type metaCommand struct {
+	Op      string            `json:"op"`
+	Node    string            `json:"node,omitempty"`
+	BusAddr string            `json:"bus,omitempty"`
+	Slots   string            `json:"slots,omitempty"`
+	Members map[string]string `json:"members,omitempty"`
+}
+
+type raftEntry struct {
+	Term    uint64      `json:"term"`
+	Index   uint64      `json:"index"`
+	Command metaCommand `json:"cmd"`
+}
+
+// metaSnapshot 是元数据状态机在某个日志位置的完整状态
+type metaSnapshot struct {
+	Index        uint64              `json:"index"`
+	Term         uint64              `json:"term"`
+	Members      map[string]string   `json:"members"`                // 节点地址 -> 集群总线地址
+	Slots        map[string][]string `json:"slots"`                  // 节点地址 -> 槽位区间
+	Epochs       map[string]uint64   `json:"epochs,omitempty"`       // 节点地址 -> 配置纪元
+	CurrentEpoch uint64              `json:"currentEpoch,omitempty"` // 最大的配置纪元
+}
+
+// raftState 是需要持久化的 Raft 状态，日志条目保存在单独的日志文件中
+type raftState struct {
+	Term        uint64 `json:"term"`
+	VotedFor    string `json:"votedFor"`
+	CommitIndex uint64 `json:"commitIndex"`
+}
+
+type voteRequest struct {
+	Term         uint64 `json:"term"`
+	Candidate    string `json:"candidate"`
+	LastLogIndex uint64 `json:"lastLogIndex"`
+	LastLogTerm  uint64 `json:"lastLogTerm"`
+}
+
+type voteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+type appendRequest struct {
+	Term         uint64      `json:"term"`
+	Leader       string      `json:"leader"`
+	PrevLogIndex uint64      `json:"prevLogIndex"`
+	PrevLogTerm  uint64      `json:"prevLogTerm"`
+	Entries      []raftEntry `json:"entries,omitempty"`
+	LeaderCommit uint64      `json:"leaderCommit"`
+}
+
+type appendResponse struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+	// 失败时 leader 下次应从该位置开始发送
+	ConflictIndex uint64 `json:"conflictIndex"`
+}
+
+type snapshotRequest struct {
+	Term     uint64        `json:"term"`
+	Leader   string        `json:"leader"`
+	Snapshot *metaSnapshot `json:"snapshot"`
+}
+
+type snapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+type proposeRequest struct {
+	Command metaCommand `json:"cmd"`
+}
+
+type proposeResponse struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Err   string `json:"err,omitempty"`
+}
+
+var errNoLeader = errors.New("CLUSTERDOWN no raft leader")
+
+var errMembershipChanging = errors.New("ERR a cluster membership change is in progress, try again later")
+
+// raftNode 保存本节点的 Raft 状态
+type raftNode struct {
+	cluster *ClusterDatabase
+
+	mu          sync.Mutex
+	role        raftRole
+	currentTerm uint64
+	votedFor    string
+	leader      string
+	log         []raftEntry
+	snapshot    *metaSnapshot
+	commitIndex uint64
+	lastApplied uint64
+	members     map[string]string // 日志中最新的成员配置，成员 -> 集群总线地址
+	// 配置纪元由状态机按日志顺序分配：引导时各初始节点依次获得 1..n，节点每次获得槽位时获得新的最大纪元，
+	// 因此与 Redis 一样，纪元越大的节点越晚获得其槽位，所有节点上的结果相同
+	epochs       map[string]uint64
+	currentEpoch uint64
+
+	// 以下字段仅在 leader 上使用
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	inflight   map[string]bool
+	votes      map[string]bool
+
+	electionDeadline time.Time
+	lastHeartbeat    time.Time
+	applied          chan struct{} // 每次应用新条目后关闭并替换，用于唤醒等待提案结果的协程
+
+	stateFile    string
+	snapshotFile string
+	logFile      string // 快照之后的日志条目，每行一个条目，新条目只追加到文件末尾
+
+	logWriter    *os.File      // 以追加方式打开的日志文件，为 nil 时下次写入需要重写整个文件
+	written      uint64        // 已写入日志文件的最后一个条目位置
+	durable      uint64        // 已 fsync 的最后一个条目位置，leader 只在自己的条目落盘后将自己计入多数
+	stateVersion uint64        // 任期、投票或提交位置每次变化时递增
+	needSync     chan struct{} // 唤醒后台的 fsync 协程
+
+	persistMu        sync.Mutex // 串行化状态文件的写入，不持有 mu 时也可以获取
+	persistedVersion uint64     // 已写入状态文件的 stateVersion，由 persistMu 保护
+}
+
+func makeRaftNode(cluster *ClusterDatabase) *raftNode {
+	dir := filepath.Dir(config.Properties.AppendFilename)
+	name := "raft-" + strings.ReplaceAll(cluster.self, ":", "-")
+	rf := &raftNode{
+		cluster:      cluster,
+		snapshot:     &metaSnapshot{},
+		members:      make(map[string]string),
+		epochs:       make(map[string]uint64),
+		applied:      make(chan struct{}),
+		stateFile:    filepath.Join(dir, name+".state"),
+		snapshotFile: filepath.Join(dir, name+".snapshot"),
+		logFile:      filepath.Join(dir, name+".log"),
+		needSync:     make(chan struct{}, 1),
+	}
+	rf.resetElectionTimer()
+	return rf
+}
+
+// start 恢复持久化的状态并启动定时任务
+// 没有任何持久化状态且配置了 peers 时，以 self 与 peers 作为初始成员引导集群。
+// 同一集群的所有初始节点必须使用一致的 peers 配置，之后加入的节点不应配置 peers，而是通过 CLUSTER MEET 加入。
+func (rf *raftNode) start(busAddr string) error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	loaded, err := rf.load()
+	if err != nil {
+		return err
+	}
+	if !loaded && len(config.Properties.Peers) > 0 {
+		members := map[string]string{rf.cluster.self: busAddr}
+		for _, peer := range config.Properties.Peers {
+			addr, peerBusAddr := parseNodeAddr(peer)
+			members[addr] = peerBusAddr
+		}
+		// 引导条目在所有初始节点上完全相同，可以直接视为已提交
+		rf.log = append(rf.log, raftEntry{
+			Index:   1,
+			Command: metaCommand{Op: opBootstrap, Members: members},
+		})
+		rf.commitIndex = 1
+		rf.updateMembers()
+		if err = rf.rewriteLogFile(); err != nil {
+			return err
+		}
+		if err = rf.persistState(); err != nil {
+			return err
+		}
+	}
+	rf.applyCommitted()
+	go rf.run()
+	go rf.syncLoop()
+	return nil
+}
+
+func (rf *raftNode) resetElectionTimer() {
+	timeout := raftElectionTimeout + time.Duration(rand.Int63n(int64(raftElectionTimeout)))
+	rf.electionDeadline = time.Now().Add(timeout)
+}
+
+func (rf *raftNode) lastIndex() uint64 {
+	return rf.snapshot.Index + uint64(len(rf.log))
+}
+
+// termAt 返回指定位置条目的任期，该位置已被快照截断时返回 false
+func (rf *raftNode) termAt(index uint64) (uint64, bool) {
+	if index == rf.snapshot.Index {
+		return rf.snapshot.Term, true
+	}
+	if index < rf.snapshot.Index || index > rf.lastIndex() {
+		return 0, false
+	}
+	return rf.log[index-rf.snapshot.Index-1].Term, true
+}
+
+func (rf *raftNode) lastTerm() uint64 {
+	term, _ := rf.termAt(rf.lastIndex())
+	return term
+}
+
+// entriesFrom 返回从 index 开始的最多 limit 个条目的拷贝
+func (rf *raftNode) entriesFrom(index uint64, limit int) []raftEntry {
+	if index > rf.lastIndex() {
+		return nil
+	}
+	entries := rf.log[index-rf.snapshot.Index-1:]
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	result := make([]raftEntry, len(entries))
+	copy(result, entries)
+	return result
+}
+
+func (rf *raftNode) quorum() int {
+	return len(rf.members)/2 + 1
+}
+
+// membersAt 返回快照与截至 index 的日志条目所确定的成员配置，index 不能小于快照的位置
+func (rf *raftNode) membersAt(index uint64) map[string]string {
+	members := make(map[string]string, len(rf.snapshot.Members))
+	for addr, busAddr := range rf.snapshot.Members {
+		members[addr] = busAddr
+	}
+	for _, entry := range rf.log {
+		if entry.Index > index {
+			break
+		}
+		cmd := entry.Command
+		switch cmd.Op {
+		case opBootstrap:
+			for addr, busAddr := range cmd.Members {
+				members[addr] = busAddr
+			}
+		case opAddNode:
+			members[cmd.Node] = cmd.BusAddr
+		case opDelNode:
+			delete(members, cmd.Node)
+		}
+	}
+	return members
+}
+
+// updateMembers 在日志或快照变化后重新计算成员配置，成员变更在追加到日志时即生效
+func (rf *raftNode) updateMembers() {
+	rf.members = rf.membersAt(rf.lastIndex())
+	for peer := range rf.nextIndex {
+		if _, ok := rf.members[peer]; !ok {
+			delete(rf.nextIndex, peer)
+			delete(rf.matchIndex, peer)
+		}
+	}
+}
+
+// changingMembers 判断 leader 是否还不能追加新的成员变更：上一个成员变更尚未提交，
+// 或者当前任期还没有提交任何条目，此时之前任期未提交的成员变更可能仍会生效
+func (rf *raftNode) changingMembers() bool {
+	if term, _ := rf.termAt(rf.commitIndex); term != rf.currentTerm {
+		return true
+	}
+	for _, entry := range rf.log[rf.commitIndex-rf.snapshot.Index:] {
+		if entry.Command.Op == opAddNode || entry.Command.Op == opDelNode {
+			return true
+		}
+	}
+	return false
+}
+
+// stepDown 发现更高的任期时转为 follower
+func (rf *raftNode) stepDown(term uint64) {
+	if term > rf.currentTerm {
+		rf.currentTerm = term
+		rf.votedFor = ""
+		rf.persistStateOrWarn()
+	}
+	if rf.role == roleLeader {
+		logger.Info(fmt.Sprintf("raft: step down from leader at term %d", rf.currentTerm))
+	}
+	rf.role = roleFollower
+}
+
+// run 驱动选举超时与心跳
+func (rf *raftNode) run() {
+	ticker := time.NewTicker(raftTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rf.cluster.stopBus:
+			return
+		case <-ticker.C:
+		}
+		rf.mu.Lock()
+		now := time.Now()
+		if rf.role == roleLeader {
+			if now.Sub(rf.lastHeartbeat) >= raftHeartbeat {
+				rf.broadcastAppend()
+			}
+		} else if now.After(rf.electionDeadline) {
+			// 只有成员才能发起选举，尚未加入集群或已被移除的节点保持沉默
+			if _, ok := rf.members[rf.cluster.self]; ok {
+				rf.startElection()
+			} else {
+				rf.resetElectionTimer()
+			}
+		}
+		rf.mu.Unlock()
+	}
+}
+
+func (rf *raftNode) startElection() {
+	rf.role = roleCandidate
+	rf.currentTerm++
+	rf.votedFor = rf.cluster.self
+	rf.leader = ""
+	rf.votes = map[string]bool{rf.cluster.self: true}
+	rf.resetElectionTimer()
+	rf.persistStateOrWarn()
+	logger.Info(fmt.Sprintf("raft: start election at term %d", rf.currentTerm))
+	if len(rf.votes) >= rf.quorum() {
+		rf.becomeLeader()
+		return
+	}
+	req := &voteRequest{
+		Term:         rf.currentTerm,
+		Candidate:    rf.cluster.self,
+		LastLogIndex: rf.lastIndex(),
+		LastLogTerm:  rf.lastTerm(),
+	}
+	for peer, busAddr := range rf.members {
+		if peer == rf.cluster.self {
+			continue
+		}
+		go rf.requestVote(peer, busAddr, req)
+	}
+}
+
+func (rf *raftNode) requestVote(peer string, busAddr string, req *voteRequest) {
+	resp := &voteResponse{}
+	if err := rf.cluster.callBus(busAddr, busRaftVote, req, resp, raftRPCTimeout); err != nil {
+		return
+	}
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if resp.Term > rf.currentTerm {
+		rf.stepDown(resp.Term)
+		return
+	}
+	if rf.role != roleCandidate || rf.currentTerm != req.Term || !resp.Granted {
+		return
+	}
+	rf.votes[peer] = true
+	if len(rf.votes) >= rf.quorum() {
+		rf.becomeLeader()
+	}
+}
+
+func (rf *raftNode) becomeLeader() {
+	rf.role = roleLeader
+	rf.leader = rf.cluster.self
+	rf.nextIndex = make(map[string]uint64)
+	rf.matchIndex = make(map[string]uint64)
+	rf.inflight = make(map[string]bool)
+	for peer := range rf.members {
+		rf.nextIndex[peer] = rf.lastIndex() + 1
+	}
+	logger.Info(fmt.Sprintf("raft: became leader at term %d", rf.currentTerm))
+	rf.appendLocal(metaCommand{Op: opNoop})
+}
+
+// proposeLocal 由 leader 追加客户端提交的条目，一次只允许一个未提交的成员变更
+func (rf *raftNode) proposeLocal(cmd metaCommand) (uint64, uint64, error) {
+	if (cmd.Op == opAddNode || cmd.Op == opDelNode) && rf.changingMembers() {
+		return 0, 0, errMembershipChanging
+	}
+	index, term := rf.appendLocal(cmd)
+	return index, term, nil
+}
+
+// appendLocal 由 leader 追加一个新条目并立即开始复制，条目由后台协程 fsync，落盘后 leader 才将自己计入多数
+func (rf *raftNode) appendLocal(cmd metaCommand) (uint64, uint64) {
+	entry := raftEntry{Term: rf.currentTerm, Index: rf.lastIndex() + 1, Command: cmd}
+	rf.log = append(rf.log, entry)
+	rf.updateMembers()
+	if _, err := rf.writeEntries([]raftEntry{entry}); err != nil {
+		logger.Warn("raft: write log failed: " + err.Error())
+	}
+	rf.wakeSyncer()
+	rf.advanceCommit()
+	rf.broadcastAppend()
+	return entry.Index, entry.Term
+}
+
+// broadcastAppend 向所有 follower 发送日志或心跳，每个 follower 同时最多只有一个未完成的请求
+func (rf *raftNode) broadcastAppend() {
+	rf.lastHeartbeat = time.Now()
+	for peer, busAddr := range rf.members {
+		if peer == rf.cluster.self || rf.inflight[peer] {
+			continue
+		}
+		next, ok := rf.nextIndex[peer]
+		if !ok {
+			// 新加入的成员
+			next = rf.lastIndex() + 1
+			rf.nextIndex[peer] = next
+		}
+		rf.inflight[peer] = true
+		if next <= rf.snapshot.Index {
+			req := &snapshotRequest{Term: rf.currentTerm, Leader: rf.cluster.self, Snapshot: rf.snapshot}
+			go rf.sendSnapshot(peer, busAddr, req)
+			continue
+		}
+		prevTerm, _ := rf.termAt(next - 1)
+		req := &appendRequest{
+			Term:         rf.currentTerm,
+			Leader:       rf.cluster.self,
+			PrevLogIndex: next - 1,
+			PrevLogTerm:  prevTerm,
+			Entries:      rf.entriesFrom(next, raftMaxAppend),
+			LeaderCommit: rf.commitIndex,
+		}
+		go rf.sendAppend(peer, busAddr, req)
+	}
+}
+
+func (rf *raftNode) sendAppend(peer string, busAddr string, req *appendRequest) {
+	resp := &appendResponse{}
+	err := rf.cluster.callBus(busAddr, busRaftAppend, req, resp, raftRPCTimeout)
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.inflight[peer] = false
+	if err != nil {
+		return
+	}
+	if resp.Term > rf.currentTerm {
+		rf.stepDown(resp.Term)
+		return
+	}
+	if rf.role != roleLeader || rf.currentTerm != req.Term {
+		return
+	}
+	if resp.Success {
+		match := req.PrevLogIndex + uint64(len(req.Entries))
+		if match > rf.matchIndex[peer] {
+			rf.matchIndex[peer] = match
+		}
+		rf.nextIndex[peer] = match + 1
+		rf.advanceCommit()
+		if match < rf.lastIndex() {
+			rf.broadcastAppend()
+		}
+		return
+	}
+	if resp.ConflictIndex > 0 {
+		rf.nextIndex[peer] = resp.ConflictIndex
+	} else if rf.nextIndex[peer] > 1 {
+		rf.nextIndex[peer]--
+	}
+	rf.broadcastAppend()
+}
+
+func (rf *raftNode) sendSnapshot(peer string, busAddr string, req *snapshotRequest) {
+	resp := &snapshotResponse{}
+	err := rf.cluster.callBus(busAddr, busRaftSnapshot, req, resp, raftRPCTimeout)
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.inflight[peer] = false
+	if err != nil {
+		return
+	}
+	if resp.Term > rf.currentTerm {
+		rf.stepDown(resp.Term)
+		return
+	}
+	if rf.role != roleLeader || rf.currentTerm != req.Term {
+		return
+	}
+	if req.Snapshot.Index > rf.matchIndex[peer] {
+		rf.matchIndex[peer] = req.Snapshot.Index
+	}
+	rf.nextIndex[peer] = req.Snapshot.Index + 1
+}
+
+// advanceCommit 提交已复制到多数节点的、当前任期的条目
+func (rf *raftNode) advanceCommit() {
+	for index := rf.lastIndex(); index > rf.commitIndex; index-- {
+		if term, _ := rf.termAt(index); term != rf.currentTerm {
+			// 只能通过计数提交当前任期的条目，之前的条目随之一并提交
+			break
+		}
+		count := 0
+		for peer := range rf.members {
+			if peer == rf.cluster.self {
+				if rf.durable >= index {
+					count++
+				}
+			} else if rf.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count >= rf.quorum() {
+			rf.commitIndex = index
+			rf.commitChanged()
+			rf.applyCommitted()
+			return
+		}
+	}
+}
+
+// handleVote 处理 RequestVote 请求
+func (rf *raftNode) handleVote(req *voteRequest) *voteResponse {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	// 不是成员的节点无权发起选举，也不能以更高的任期迫使本节点下台
+	if _, ok := rf.members[req.Candidate]; !ok {
+		return &voteResponse{Term: rf.currentTerm}
+	}
+	if req.Term > rf.currentTerm {
+		rf.stepDown(req.Term)
+	}
+	resp := &voteResponse{Term: rf.currentTerm}
+	if req.Term < rf.currentTerm {
+		return resp
+	}
+	if rf.votedFor != "" && rf.votedFor != req.Candidate {
+		return resp
+	}
+	// 候选人的日志至少要和自己一样新
+	lastTerm := rf.lastTerm()
+	if req.LastLogTerm < lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex < rf.lastIndex()) {
+		return resp
+	}
+	votedFor := rf.votedFor
+	rf.votedFor = req.Candidate
+	if err := rf.persistState(); err != nil {
+		// 投票必须在响应之前落盘，否则重启后可能在同一任期内再次投票
+		logger.Warn("raft: persist state failed: " + err.Error())
+		rf.votedFor = votedFor
+		return resp
+	}
+	rf.resetElectionTimer()
+	resp.Granted = true
+	return resp
+}
+
+// handleAppend 处理 AppendEntries 请求，新条目在释放 mu 之后 fsync，落盘后才响应成功
+func (rf *raftNode) handleAppend(req *appendRequest) *appendResponse {
+	resp, file := rf.appendEntries(req)
+	if file != nil {
+		// 文件已被重写时其中的条目已经落盘
+		if err := file.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+			logger.Warn("raft: sync log failed: " + err.Error())
+			resp.Success = false
+			resp.ConflictIndex = req.PrevLogIndex + 1
+		}
+	}
+	return resp
+}
+
+// appendEntries 在持有 mu 时将条目追加到日志并写入日志文件，返回需要 fsync 的文件
+func (rf *raftNode) appendEntries(req *appendRequest) (*appendResponse, *os.File) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if req.Term < rf.currentTerm {
+		return &appendResponse{Term: rf.currentTerm}, nil
+	}
+	if req.Term > rf.currentTerm || rf.role != roleFollower {
+		rf.stepDown(req.Term)
+	}
+	rf.leader = req.Leader
+	rf.resetElectionTimer()
+	resp := &appendResponse{Term: rf.currentTerm}
+
+	if req.PrevLogIndex > rf.lastIndex() {
+		resp.ConflictIndex = rf.lastIndex() + 1
+		return resp, nil
+	}
+	if req.PrevLogIndex < rf.snapshot.Index {
+		// 前面的条目已包含在快照中
+		resp.ConflictIndex = rf.snapshot.Index + 1
+		return resp, nil
+	}
+	if term, _ := rf.termAt(req.PrevLogIndex); term != req.PrevLogTerm {
+		// 跳过整个冲突的任期
+		index := req.PrevLogIndex
+		for index > rf.snapshot.Index+1 {
+			if t, _ := rf.termAt(index - 1); t != term {
+				break
+			}
+			index--
+		}
+		resp.ConflictIndex = index
+		return resp, nil
+	}
+
+	truncated := false
+	var appended []raftEntry
+	for i, entry := range req.Entries {
+		if term, ok := rf.termAt(entry.Index); ok && entry.Index <= rf.lastIndex() {
+			if term == entry.Term {
+				continue
+			}
+			// 删除冲突的条目及其之后的所有条目
+			rf.log = rf.log[:entry.Index-rf.snapshot.Index-1]
+			truncated = true
+		}
+		appended = req.Entries[i:]
+		rf.log = append(rf.log, appended...)
+		break
+	}
+	var file *os.File
+	var err error
+	if truncated || rf.logWriter == nil {
+		// 截断很少发生，直接重写整个日志文件
+		err = rf.rewriteLogFile()
+	} else if len(appended) > 0 {
+		file, err = rf.writeEntries(appended)
+	}
+	if len(appended) > 0 {
+		rf.updateMembers()
+	}
+	if err != nil {
+		logger.Warn("raft: write log failed: " + err.Error())
+		resp.ConflictIndex = req.PrevLogIndex + 1
+		return resp, nil
+	}
+	lastNew := req.PrevLogIndex + uint64(len(req.Entries))
+	if req.LeaderCommit > rf.commitIndex {
+		commit := req.LeaderCommit
+		if commit > lastNew {
+			commit = lastNew
+		}
+		if commit > rf.commitIndex {
+			rf.commitIndex = commit
+			rf.commitChanged()
+		}
+	}
+	rf.applyCommitted()
+	resp.Success = true
+	return resp, file
+}
+
+// handleSnapshot 处理 InstallSnapshot 请求，用 leader 的快照替换本地状态机
+func (rf *raftNode) handleSnapshot(req *snapshotRequest) *snapshotResponse {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if req.Term < rf.currentTerm {
+		return &snapshotResponse{Term: rf.currentTerm}
+	}
+	if req.Term > rf.currentTerm || rf.role != roleFollower {
+		rf.stepDown(req.Term)
+	}
+	rf.leader = req.Leader
+	rf.resetElectionTimer()
+	snapshot := req.Snapshot
+	if snapshot == nil || snapshot.Index <= rf.lastApplied {
+		return &snapshotResponse{Term: rf.currentTerm}
+	}
+	// 保留快照之后仍然匹配的日志
+	if term, ok := rf.termAt(snapshot.Index); ok && term == snapshot.Term && snapshot.Index <= rf.lastIndex() {
+		rf.log = append([]raftEntry(nil), rf.log[snapshot.Index-rf.snapshot.Index:]...)
+	} else {
+		rf.log = nil
+	}
+	rf.snapshot = snapshot
+	rf.restoreSnapshot(snapshot)
+	rf.updateMembers()
+	rf.lastApplied = snapshot.Index
+	if rf.commitIndex < snapshot.Index {
+		rf.commitIndex = snapshot.Index
+	}
+	if err := rf.saveSnapshot(); err != nil {
+		logger.Warn("raft: save snapshot failed: " + err.Error())
+	}
+	if err := rf.rewriteLogFile(); err != nil {
+		logger.Warn("raft: write log failed: " + err.Error())
+	}
+	rf.persistStateOrWarn()
+	rf.notifyApplied()
+	logger.Info(fmt.Sprintf("raft: installed snapshot at index %d", snapshot.Index))
+	return &snapshotResponse{Term: rf.currentTerm}
+}
+
+// handlePropose 处理 follower 转发来的提案，只追加不等待提交
+func (rf *raftNode) handlePropose(req *proposeRequest) *proposeResponse {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.role != roleLeader {
+		return &proposeResponse{Err: errNoLeader.Error()}
+	}
+	index, term, err := rf.proposeLocal(req.Command)
+	if err != nil {
+		return &proposeResponse{Err: err.Error()}
+	}
+	return &proposeResponse{Index: index, Term: term}
+}
+
+// propose 提交一条元数据变更并等待其在本节点上被应用
+func (cluster *ClusterDatabase) propose(cmd metaCommand) error {
+	rf := cluster.raft
+	rf.mu.Lock()
+	var index, term uint64
+	if rf.role == roleLeader {
+		var err error
+		index, term, err = rf.proposeLocal(cmd)
+		rf.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	} else {
+		busAddr, ok := rf.members[rf.leader]
+		rf.mu.Unlock()
+		if !ok {
+			return errNoLeader
+		}
+		resp := &proposeResponse{}
+		if err := cluster.callBus(busAddr, busRaftPropose, &proposeRequest{Command: cmd}, resp, raftRPCTimeout); err != nil {
+			return errNoLeader
+		}
+		if resp.Err != "" {
+			return errors.New(resp.Err)
+		}
+		index, term = resp.Index, resp.Term
+	}
+
+	deadline := time.After(raftProposeTimeout)
+	for {
+		rf.mu.Lock()
+		if rf.lastApplied >= index {
+			applied, ok := rf.termAt(index)
+			rf.mu.Unlock()
+			if ok && applied != term {
+				return errors.New("ERR proposal was overwritten by a new raft leader")
+			}
+			return nil
+		}
+		ch := rf.applied
+		rf.mu.Unlock()
+		select {
+		case <-ch:
+		case <-deadline:
+			return errors.New("CLUSTERDOWN timeout waiting for the proposal to be committed")
+		}
+	}
+}
+
+func (rf *raftNode) notifyApplied() {
+	close(rf.applied)
+	rf.applied = make(chan struct{})
+}
+
+// applyCommitted 将已提交的条目依次应用到状态机，必要时生成快照
+func (rf *raftNode) applyCommitted() {
+	if rf.lastApplied >= rf.commitIndex {
+		return
+	}
+	for rf.lastApplied < rf.commitIndex {
+		rf.lastApplied++
+		entry := rf.log[rf.lastApplied-rf.snapshot.Index-1]
+		rf.apply(&entry.Command)
+	}
+	rf.notifyApplied()
+	if len(rf.log) > raftSnapshotInterval {
+		rf.takeSnapshot()
+	}
+}
+
+// apply 在持有 rf.mu 的情况下修改集群的拓扑与槽位，Raft 的成员配置已在条目追加时更新
+func (rf *raftNode) apply(cmd *metaCommand) {
+	cluster := rf.cluster
+	switch cmd.Op {
+	case opBootstrap:
+		for addr, busAddr := range cmd.Members {
+			cluster.addNode(addr, busAddr)
+		}
+		nodes := sortedMembers(cmd.Members)
+		for _, addr := range nodes {
+			rf.currentEpoch++
+			rf.epochs[addr] = rf.currentEpoch
+		}
+		cluster.slots.assignEvenly(nodes)
+	case opAddNode:
+		cluster.addNode(cmd.Node, cmd.BusAddr)
+	case opDelNode:
+		delete(rf.epochs, cmd.Node)
+		if cmd.Node == cluster.self {
+			// 被移除的 leader 在移除条目提交之前继续管理集群，但不把自己计入多数
+			logger.Warn("raft: this node has been removed from the cluster")
+			if rf.role == roleLeader {
+				rf.role = roleFollower
+				rf.leader = ""
+			}
+			return
+		}
+		cluster.removeNode(cmd.Node)
+	case opSetSlot:
+		r, err := parseSlotRange(cmd.Slots)
+		if err != nil {
+			logger.Warn("raft: bad slot range in log: " + cmd.Slots)
+			return
+		}
+		cluster.slots.setOwner(r, cmd.Node)
+		rf.currentEpoch++
+		rf.epochs[cmd.Node] = rf.currentEpoch
+	}
+}
+
+// restoreSnapshot 用快照替换状态机中的拓扑和槽位，调用方需要随后更新成员配置
+func (rf *raftNode) restoreSnapshot(snapshot *metaSnapshot) {
+	cluster := rf.cluster
+	for _, addr := range cluster.topology.getNodes() {
+		if _, ok := snapshot.Members[addr]; !ok && addr != cluster.self {
+			cluster.removeNode(addr)
+		}
+	}
+	for addr, busAddr := range snapshot.Members {
+		cluster.addNode(addr, busAddr)
+	}
+	rf.epochs = make(map[string]uint64, len(snapshot.Epochs))
+	for addr, epoch := range snapshot.Epochs {
+		rf.epochs[addr] = epoch
+	}
+	rf.currentEpoch = snapshot.CurrentEpoch
+	cluster.slots.reset()
+	for node, ranges := range snapshot.Slots {
+		for _, s := range ranges {
+			if r, err := parseSlotRange(s); err == nil {
+				cluster.slots.setOwner(r, node)
+			}
+		}
+	}
+}
+
+// takeSnapshot 将已应用的状态写入快照并截断日志
+func (rf *raftNode) takeSnapshot() {
+	term, _ := rf.termAt(rf.lastApplied)
+	snapshot := &metaSnapshot{
+		Index:        rf.lastApplied,
+		Term:         term,
+		Members:      rf.membersAt(rf.lastApplied),
+		Slots:        make(map[string][]string),
+		Epochs:       make(map[string]uint64, len(rf.epochs)),
+		CurrentEpoch: rf.currentEpoch,
+	}
+	for addr, epoch := range rf.epochs {
+		snapshot.Epochs[addr] = epoch
+	}
+	for node, ranges := range rf.cluster.slots.ranges() {
+		for _, r := range ranges {
+			snapshot.Slots[node] = append(snapshot.Slots[node], r.String())
+		}
+	}
+	rf.log = append([]raftEntry(nil), rf.log[rf.lastApplied-rf.snapshot.Index:]...)
+	rf.snapshot = snapshot
+	if err := rf.saveSnapshot(); err != nil {
+		logger.Warn("raft: save snapshot failed: " + err.Error())
+		return
+	}
+	// 快照写入后压缩日志文件，文件大小因此不超过快照间隔
+	if err := rf.rewriteLogFile(); err != nil {
+		logger.Warn("raft: write log failed: " + err.Error())
+	}
+}
+
+// load 从磁盘恢复快照、持久化状态和日志，均不存在时返回 false
+func (rf *raftNode) load() (bool, error) {
+	loaded := false
+	data, err := os.ReadFile(rf.snapshotFile)
+	if err == nil {
+		snapshot := &metaSnapshot{}
+		if err = json.Unmarshal(data, snapshot); err != nil {
+			return false, errors.New("bad raft snapshot: " + err.Error())
+		}
+		rf.snapshot = snapshot
+		rf.restoreSnapshot(snapshot)
+		rf.lastApplied = snapshot.Index
+		rf.commitIndex = snapshot.Index
+		loaded = true
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	data, err = os.ReadFile(rf.stateFile)
+	if err == nil {
+		state := &raftState{}
+		if err = json.Unmarshal(data, state); err != nil {
+			return false, errors.New("bad raft state: " + err.Error())
+		}
+		rf.currentTerm = state.Term
+		rf.votedFor = state.VotedFor
+		if state.CommitIndex > rf.commitIndex {
+			rf.commitIndex = state.CommitIndex
+		}
+		loaded = true
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	entries, clean, err := readLogFile(rf.logFile)
+	if err != nil {
+		return false, err
+	}
+	if entries != nil {
+		loaded = true
+	}
+	// 生成快照后、压缩日志文件前崩溃时，文件中仍有已被快照包含的条目
+	for len(entries) > 0 && entries[0].Index <= rf.snapshot.Index {
+		entries = entries[1:]
+	}
+	for i, entry := range entries {
+		if entry.Index != rf.snapshot.Index+uint64(i)+1 {
+			return false, fmt.Errorf("bad raft log: entry %d follows %d", entry.Index, rf.snapshot.Index+uint64(i))
+		}
+	}
+	rf.log = entries
+	rf.updateMembers()
+	if rf.commitIndex > rf.lastIndex() {
+		rf.commitIndex = rf.lastIndex()
+	}
+	if !loaded {
+		return false, nil
+	}
+	if !clean {
+		// 丢弃末尾写入不完整的条目，之后的条目才能正确追加
+		return true, rf.rewriteLogFile()
+	}
+	return true, rf.openLogFile()
+}
+
+// readLogFile 读取日志文件中的条目，文件不存在时返回 nil。
+// 崩溃时最后一个条目可能只写入了一部分，此时忽略它并返回 clean 为 false
+func readLogFile(filename string) (entries []raftEntry, clean bool, err error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	entries = make([]raftEntry, 0)
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		var entry raftEntry
+		if err = json.Unmarshal(line, &entry); err != nil {
+			if i == len(lines)-1 {
+				return entries, false, nil
+			}
+			return nil, false, fmt.Errorf("bad raft log at line %d: %v", i+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, true, nil
+}
+
+// openLogFile 以追加方式打开日志文件，文件中已有内存中的全部条目
+func (rf *raftNode) openLogFile() error {
+	file, err := os.OpenFile(rf.logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	rf.logWriter = file
+	rf.written = rf.lastIndex()
+	rf.durable = rf.lastIndex()
+	return nil
+}
+
+// rewriteLogFile 将内存中的日志完整写入新文件并 fsync 后替换原文件，
+// 用于截断冲突的条目、生成快照后压缩日志以及写入失败后的恢复，这些情况都很少发生
+func (rf *raftNode) rewriteLogFile() error {
+	if rf.logWriter != nil {
+		_ = rf.logWriter.Close()
+		rf.logWriter = nil
+	}
+	data, err := encodeEntries(rf.log)
+	if err != nil {
+		return err
+	}
+	if err = writeDataAtomic(rf.logFile, data); err != nil {
+		return err
+	}
+	return rf.openLogFile()
+}
+
+// writeEntries 将新条目追加到日志文件但不 fsync，返回需要 fsync 的文件。
+// 日志文件需要重写时重写后返回 nil，此时条目已经落盘
+func (rf *raftNode) writeEntries(entries []raftEntry) (*os.File, error) {
+	if rf.logWriter == nil {
+		return nil, rf.rewriteLogFile()
+	}
+	data, err := encodeEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = rf.logWriter.Write(data); err != nil {
+		// 文件末尾可能留下不完整的条目，下次写入时重写整个文件
+		_ = rf.logWriter.Close()
+		rf.logWriter = nil
+		return nil, err
+	}
+	rf.written = entries[len(entries)-1].Index
+	return rf.logWriter, nil
+}
+
+func encodeEntries(entries []raftEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for i := range entries {
+		if err := encoder.Encode(&entries[i]); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// persistState 在持有 mu 时同步写入状态文件，任期与投票必须在响应请求之前落盘
+func (rf *raftNode) persistState() error {
+	rf.stateVersion++
+	return rf.writeState(rf.currentState(), rf.stateVersion)
+}
+
+func (rf *raftNode) persistStateOrWarn() {
+	if err := rf.persistState(); err != nil {
+		logger.Warn("raft: persist state failed: " + err.Error())
+	}
+}
+
+// commitChanged 提交位置变化后交给后台协程写入状态文件，重启时提交位置落后只会推迟应用已提交的条目
+func (rf *raftNode) commitChanged() {
+	rf.stateVersion++
+	rf.wakeSyncer()
+}
+
+func (rf *raftNode) currentState() *raftState {
+	return &raftState{
+		Term:        rf.currentTerm,
+		VotedFor:    rf.votedFor,
+		CommitIndex: rf.commitIndex,
+	}
+}
+
+// writeState 写入状态文件，跳过比已写入的状态更旧的版本
+func (rf *raftNode) writeState(state *raftState, version uint64) error {
+	rf.persistMu.Lock()
+	defer rf.persistMu.Unlock()
+	if version <= rf.persistedVersion {
+		return nil
+	}
+	if err := writeFileAtomic(rf.stateFile, state); err != nil {
+		return err
+	}
+	rf.persistedVersion = version
+	return nil
+}
+
+func (rf *raftNode) wakeSyncer() {
+	select {
+	case rf.needSync <- struct{}{}:
+	default:
+	}
+}
+
+// syncLoop 在不持有 mu 的情况下 fsync 新追加的条目并写入提交位置，leader 追加条目时不会阻塞心跳与其他请求
+func (rf *raftNode) syncLoop() {
+	for {
+		stopped := false
+		select {
+		case <-rf.cluster.stopBus:
+			stopped = true
+		case <-rf.needSync:
+		}
+		rf.mu.Lock()
+		file, written, durable := rf.logWriter, rf.written, rf.durable
+		state, version := rf.currentState(), rf.stateVersion
+		rf.mu.Unlock()
+
+		var err error
+		if file != nil && written > durable {
+			err = file.Sync()
+		}
+		rf.mu.Lock()
+		// 期间文件被重写时，重写的文件已经落盘
+		if file == rf.logWriter {
+			if err != nil {
+				logger.Warn("raft: sync log failed: " + err.Error())
+			} else if written > rf.durable {
+				rf.durable = written
+				if rf.role == roleLeader {
+					rf.advanceCommit()
+				}
+			}
+		}
+		rf.mu.Unlock()
+		if err = rf.writeState(state, version); err != nil {
+			logger.Warn("raft: persist state failed: " + err.Error())
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+func (rf *raftNode) saveSnapshot() error {
+	return writeFileAtomic(rf.snapshotFile, rf.snapshot)
+}
+
+// writeFileAtomic 将 v 编码为 JSON 后原子地写入文件
+func writeFileAtomic(filename string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeDataAtomic(filename, data)
+}
+
+// writeDataAtomic 先写入临时文件并 fsync 再重命名，避免崩溃时留下不完整的文件
+func writeDataAtomic(filename string, data []byte) error {
+	tmp := filename + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// raftStatus 是供 CLUSTER INFO 展示的 Raft 状态
+type raftStatus struct {
+	role        raftRole
+	term        uint64
+	leader      string
+	commitIndex uint64
+	lastApplied uint64
+	members     int
+}
+
+func (rf *raftNode) status() raftStatus {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return raftStatus{
+		role:        rf.role,
+		term:        rf.currentTerm,
+		leader:      rf.leader,
+		commitIndex: rf.commitIndex,
+		lastApplied: rf.lastApplied,
+		members:     len(rf.members),
+	}
+}
+
+// configEpochs 返回各节点的配置纪元以及最大的配置纪元
+func (rf *raftNode) configEpochs() (map[string]uint64, uint64) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	epochs := make(map[string]uint64, len(rf.epochs))
+	for addr, epoch := range rf.epochs {
+		epochs[addr] = epoch
+	}
+	return epochs, rf.currentEpoch
+}
+
+func (rf *raftNode) isMember(addr string) bool {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	_, ok := rf.members[addr]
+	return ok
+}
+
+func sortedMembers(members map[string]string) []string {
+	result := make([]string, 0, len(members))
+	for addr := range members {
+		result = append(result, addr)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,471 @@
+package cluster
+
+import (
+	"go-redis/config"
+	"go-redis/datastruct/dict"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pool "github.com/jolestar/go-commons-pool/v2"
+)
+
+const (
+	testSelf = "127.0.0.1:6399"
+	testPeer = "127.0.0.1:6400"
+)
+
+// makeTestRaftNode 创建只在 dir 中读写持久化文件的 Raft 节点，不启动集群总线与定时任务
+func makeTestRaftNode(t *testing.T, dir string) *raftNode {
+	cluster := &ClusterDatabase{
+		self:           testSelf,
+		topology:       makeTopology(testSelf),
+		slots:          makeSlotTable(),
+		peerConnection: make(map[string]*pool.ObjectPool),
+		txConnection:   make(map[string]*pool.ObjectPool),
+		breakers:       make(map[string]*circuitBreaker),
+		poolOpts:       makePoolOptions(config.Properties),
+		transactions:   dict.MakeSyncDict(),
+	}
+	rf := &raftNode{
+		cluster:      cluster,
+		snapshot:     &metaSnapshot{},
+		members:      make(map[string]string),
+		epochs:       make(map[string]uint64),
+		applied:      make(chan struct{}),
+		stateFile:    filepath.Join(dir, "raft.state"),
+		snapshotFile: filepath.Join(dir, "raft.snapshot"),
+		logFile:      filepath.Join(dir, "raft.log"),
+	}
+	cluster.raft = rf
+	t.Cleanup(func() {
+		if rf.logWriter != nil {
+			_ = rf.logWriter.Close()
+		}
+	})
+	return rf
+}
+
+func bootstrapEntry(index uint64) raftEntry {
+	return raftEntry{Term: 1, Index: index, Command: metaCommand{
+		Op:      opBootstrap,
+		Members: map[string]string{testSelf: "127.0.0.1:16399", testPeer: "127.0.0.1:16400"},
+	}}
+}
+
+func setSlotEntry(term, index uint64, slots, node string) raftEntry {
+	return raftEntry{Term: term, Index: index, Command: metaCommand{Op: opSetSlot, Slots: slots, Node: node}}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "state")
+	for _, v := range []interface{}{map[string]int{"a": 1}, []string{"replaced"}} {
+		if err := writeFileAtomic(filename, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil || string(data) != `["replaced"]` {
+		t.Fatalf("file contains %q, %v", data, err)
+	}
+	if _, err := os.Stat(filename + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file is left: %v", err)
+	}
+	// 无法写入时保留原文件
+	if err := writeFileAtomic(filename, make(chan int)); err == nil {
+		t.Errorf("marshalling a channel succeeded")
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "state"), 1); err == nil {
+		t.Errorf("writing into a missing directory succeeded")
+	}
+	if data, _ := os.ReadFile(filename); string(data) != `["replaced"]` {
+		t.Errorf("file is modified by a failed write: %q", data)
+	}
+}
+
+func TestRaftLoad(t *testing.T) {
+	entries := []raftEntry{
+		bootstrapEntry(1),
+		setSlotEntry(1, 2, "0-99", testPeer),
+		setSlotEntry(2, 3, "100-199", testPeer),
+		setSlotEntry(2, 4, "200", testSelf),
+	}
+	snapshot := &metaSnapshot{
+		Index:   2,
+		Term:    1,
+		Members: map[string]string{testSelf: "127.0.0.1:16399", testPeer: "127.0.0.1:16400"},
+		Slots:   map[string][]string{testPeer: {"0-99"}, testSelf: {"100-16383"}},
+	}
+	encoded, err := encodeEntries(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		state       *raftState
+		entries     []raftEntry
+		snapshot    *metaSnapshot
+		stateRaw    string // 非空时代替 state 写入状态文件
+		snapshotRaw string // 非空时代替 snapshot 写入快照文件
+		logRaw      string // 非空时代替 entries 写入日志文件
+
+		wantLoaded  bool
+		wantErr     bool
+		wantLog     []uint64 // 日志中条目的 index
+		wantCommit  uint64
+		wantApplied uint64
+	}{
+		{name: "nothing persisted"},
+		{
+			name:       "state and log",
+			state:      &raftState{Term: 2, VotedFor: testPeer, CommitIndex: 3},
+			entries:    entries,
+			wantLoaded: true,
+			wantLog:    []uint64{1, 2, 3, 4},
+			wantCommit: 3,
+		},
+		{
+			name:       "commit index beyond the log",
+			state:      &raftState{Term: 2, CommitIndex: 9},
+			entries:    entries[:2],
+			wantLoaded: true,
+			wantLog:    []uint64{1, 2},
+			wantCommit: 2,
+		},
+		{
+			name:        "snapshot only",
+			snapshot:    snapshot,
+			wantLoaded:  true,
+			wantCommit:  2,
+			wantApplied: 2,
+		},
+		{
+			// 写入快照后、压缩日志文件前崩溃，日志文件中仍有已被快照包含的条目
+			name:        "entries covered by the snapshot",
+			state:       &raftState{Term: 2, CommitIndex: 1},
+			entries:     entries,
+			snapshot:    snapshot,
+			wantLoaded:  true,
+			wantLog:     []uint64{3, 4},
+			wantCommit:  2,
+			wantApplied: 2,
+		},
+		{
+			name:        "compacted log",
+			state:       &raftState{Term: 2, CommitIndex: 4},
+			entries:     entries[2:],
+			snapshot:    snapshot,
+			wantLoaded:  true,
+			wantLog:     []uint64{3, 4},
+			wantCommit:  4,
+			wantApplied: 2,
+		},
+		{
+			// 追加条目时崩溃，最后一个条目只写入了一部分
+			name:       "partially written entry",
+			state:      &raftState{Term: 2, CommitIndex: 2},
+			logRaw:     string(encoded[:len(encoded)-10]),
+			wantLoaded: true,
+			wantLog:    []uint64{1, 2, 3},
+			wantCommit: 2,
+		},
+		{name: "corrupt state", stateRaw: "{", wantErr: true},
+		{name: "corrupt snapshot", snapshotRaw: "[]", wantErr: true},
+		{name: "corrupt entry", logRaw: "{\n" + string(encoded), wantErr: true},
+		{name: "missing entries after the snapshot", entries: entries[3:], snapshot: snapshot, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writer := makeTestRaftNode(t, dir)
+			if tt.snapshot != nil {
+				writer.snapshot = tt.snapshot
+				if err := writer.saveSnapshot(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.entries != nil {
+				writer.snapshot = &metaSnapshot{}
+				writer.log = tt.entries
+				if err := writer.rewriteLogFile(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.state != nil {
+				writer.currentTerm, writer.votedFor, writer.commitIndex = tt.state.Term, tt.state.VotedFor, tt.state.CommitIndex
+				if err := writer.persistState(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for filename, raw := range map[string]string{
+				writer.stateFile:    tt.stateRaw,
+				writer.snapshotFile: tt.snapshotRaw,
+				writer.logFile:      tt.logRaw,
+			} {
+				if raw == "" {
+					continue
+				}
+				if err := os.WriteFile(filename, []byte(raw), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rf := makeTestRaftNode(t, dir)
+			loaded, err := rf.load()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("load succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaded != tt.wantLoaded {
+				t.Fatalf("load returned %v, want %v", loaded, tt.wantLoaded)
+			}
+			var gotLog []uint64
+			for _, e := range rf.log {
+				gotLog = append(gotLog, e.Index)
+			}
+			if !reflect.DeepEqual(gotLog, tt.wantLog) {
+				t.Errorf("log = %v, want %v", gotLog, tt.wantLog)
+			}
+			if rf.commitIndex != tt.wantCommit || rf.lastApplied != tt.wantApplied {
+				t.Errorf("commitIndex = %d, lastApplied = %d, want %d, %d",
+					rf.commitIndex, rf.lastApplied, tt.wantCommit, tt.wantApplied)
+			}
+			if tt.state != nil && (rf.currentTerm != tt.state.Term || rf.votedFor != tt.state.VotedFor) {
+				t.Errorf("term = %d, votedFor = %q, want %d, %q", rf.currentTerm, rf.votedFor, tt.state.Term, tt.state.VotedFor)
+			}
+			if tt.snapshot != nil {
+				if !reflect.DeepEqual(rf.members, tt.snapshot.Members) {
+					t.Errorf("members = %v, want %v", rf.members, tt.snapshot.Members)
+				}
+				if owner := rf.cluster.slots.getOwner(50); owner != testPeer {
+					t.Errorf("slot 50 is owned by %q, want %q", owner, testPeer)
+				}
+				if _, ok := rf.cluster.topology.getNode(testPeer); !ok {
+					t.Errorf("%s is not added to the topology", testPeer)
+				}
+			}
+			// 加载后追加的条目在再次加载时仍然完整
+			if loaded {
+				entry := setSlotEntry(rf.currentTerm, rf.lastIndex()+1, "300", testPeer)
+				rf.log = append(rf.log, entry)
+				if _, err := rf.writeEntries([]raftEntry{entry}); err != nil {
+					t.Fatal(err)
+				}
+				reloaded := makeTestRaftNode(t, dir)
+				if _, err := reloaded.load(); err != nil {
+					t.Fatal(err)
+				}
+				if reloaded.lastIndex() != entry.Index {
+					t.Errorf("last index after reloading = %d, want %d", reloaded.lastIndex(), entry.Index)
+				}
+			}
+		})
+	}
+}
+
+// TestRaftSnapshotRestart 应用日志并生成快照后重启，状态机与日志应与重启前一致
+func TestRaftSnapshotRestart(t *testing.T) {
+	dir := t.TempDir()
+	rf := makeTestRaftNode(t, dir)
+	rf.currentTerm = 2
+	rf.log = []raftEntry{
+		bootstrapEntry(1),
+		setSlotEntry(1, 2, "0-99", testPeer),
+		setSlotEntry(2, 3, "100-199", testPeer),
+		setSlotEntry(2, 4, "200", testSelf),
+	}
+	rf.updateMembers()
+	rf.commitIndex = 3
+	rf.applyCommitted()
+	rf.takeSnapshot()
+	if rf.snapshot.Index != 3 || rf.snapshot.Term != 2 || len(rf.log) != 1 {
+		t.Fatalf("snapshot at %d term %d with %d entries left, want 3, 2, 1",
+			rf.snapshot.Index, rf.snapshot.Term, len(rf.log))
+	}
+
+	restarted := makeTestRaftNode(t, dir)
+	if loaded, err := restarted.load(); err != nil || !loaded {
+		t.Fatalf("load returned %v, %v", loaded, err)
+	}
+	if restarted.lastIndex() != 4 || restarted.lastTerm() != 2 || restarted.lastApplied != 3 {
+		t.Errorf("lastIndex = %d, lastTerm = %d, lastApplied = %d, want 4, 2, 3",
+			restarted.lastIndex(), restarted.lastTerm(), restarted.lastApplied)
+	}
+	if !reflect.DeepEqual(restarted.members, rf.members) {
+		t.Errorf("members = %v, want %v", restarted.members, rf.members)
+	}
+	// 引导时依次分配纪元 1、2，之后每次分配槽位时获得新的最大纪元
+	wantEpochs := map[string]uint64{testSelf: 1, testPeer: 4}
+	if epochs, current := restarted.configEpochs(); !reflect.DeepEqual(epochs, wantEpochs) || current != 4 {
+		t.Errorf("epochs = %v, current epoch = %d, want %v, 4", epochs, current, wantEpochs)
+	}
+	want, got := rf.cluster.slots.ranges(), restarted.cluster.slots.ranges()
+	if len(got) != len(want) {
+		t.Fatalf("slot ranges = %v, want %v", got, want)
+	}
+	for node, ranges := range want {
+		if !reflect.DeepEqual(got[node], ranges) {
+			t.Errorf("slots of %s = %v, want %v", node, got[node], ranges)
+		}
+	}
+	// 未应用的条目在重启后继续应用
+	restarted.commitIndex = 4
+	restarted.applyCommitted()
+	if owner := restarted.cluster.slots.getOwner(200); owner != testSelf {
+		t.Errorf("slot 200 is owned by %q, want %q", owner, testSelf)
+	}
+	if epochs, current := restarted.configEpochs(); epochs[testSelf] != 5 || current != 5 {
+		t.Errorf("epoch of %s = %d, current epoch = %d, want 5, 5", testSelf, epochs[testSelf], current)
+	}
+}
+
+func TestRaftVote(t *testing.T) {
+	const stranger = "127.0.0.1:6401"
+	tests := []struct {
+		name    string
+		setup   func(rf *raftNode)
+		req     voteRequest
+		granted bool
+		term    uint64 // 处理请求后本节点的任期
+	}{
+		{name: "member", req: voteRequest{Term: 3, Candidate: testPeer, LastLogIndex: 1, LastLogTerm: 1}, granted: true, term: 3},
+		{name: "not a member", req: voteRequest{Term: 3, Candidate: stranger, LastLogIndex: 1, LastLogTerm: 1}, term: 2},
+		{name: "stale term", req: voteRequest{Term: 1, Candidate: testPeer, LastLogIndex: 1, LastLogTerm: 1}, term: 2},
+		{name: "stale log", req: voteRequest{Term: 3, Candidate: testPeer}, term: 3},
+		{
+			name:  "voted for another candidate",
+			setup: func(rf *raftNode) { rf.votedFor = testSelf },
+			req:   voteRequest{Term: 2, Candidate: testPeer, LastLogIndex: 1, LastLogTerm: 1},
+			term:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf := makeTestRaftNode(t, t.TempDir())
+			rf.currentTerm = 2
+			rf.log = []raftEntry{bootstrapEntry(1)}
+			rf.updateMembers()
+			rf.commitIndex = 1
+			rf.applyCommitted()
+			if tt.setup != nil {
+				tt.setup(rf)
+			}
+			resp := rf.handleVote(&tt.req)
+			if resp.Granted != tt.granted || rf.currentTerm != tt.term {
+				t.Errorf("granted = %v at term %d, want %v at term %d", resp.Granted, rf.currentTerm, tt.granted, tt.term)
+			}
+		})
+	}
+}
+
+// TestRaftAppendPersistence follower 追加的条目与截断冲突条目后的日志在重启后保持一致
+func TestRaftAppendPersistence(t *testing.T) {
+	dir := t.TempDir()
+	rf := makeTestRaftNode(t, dir)
+	rf.currentTerm = 1
+	rf.log = []raftEntry{bootstrapEntry(1)}
+	if err := rf.rewriteLogFile(); err != nil {
+		t.Fatal(err)
+	}
+	requests := []*appendRequest{
+		{Term: 1, Leader: testPeer, PrevLogIndex: 1, PrevLogTerm: 1, Entries: []raftEntry{
+			setSlotEntry(1, 2, "0-99", testPeer),
+			setSlotEntry(1, 3, "100", testPeer),
+		}},
+		{Term: 1, Leader: testPeer, PrevLogIndex: 3, PrevLogTerm: 1, Entries: []raftEntry{
+			setSlotEntry(1, 4, "101", testPeer),
+		}, LeaderCommit: 2},
+		// 新 leader 覆盖未提交的条目 3、4
+		{Term: 2, Leader: testSelf, PrevLogIndex: 2, PrevLogTerm: 1, Entries: []raftEntry{
+			setSlotEntry(2, 3, "200", testSelf),
+		}, LeaderCommit: 3},
+	}
+	for i, req := range requests {
+		if resp := rf.handleAppend(req); !resp.Success {
+			t.Fatalf("append %d failed: %+v", i, resp)
+		}
+	}
+	if rf.lastIndex() != 3 || rf.lastTerm() != 2 || rf.commitIndex != 3 {
+		t.Fatalf("lastIndex = %d, lastTerm = %d, commitIndex = %d, want 3, 2, 3", rf.lastIndex(), rf.lastTerm(), rf.commitIndex)
+	}
+	// 提交位置由后台协程写入，这里直接写入
+	if err := rf.persistState(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := makeTestRaftNode(t, dir)
+	if _, err := restarted.load(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restarted.log, rf.log) {
+		t.Errorf("log after restart = %+v, want %+v", restarted.log, rf.log)
+	}
+	if restarted.currentTerm != 2 || restarted.commitIndex != 3 {
+		t.Errorf("term = %d, commitIndex = %d, want 2, 3", restarted.currentTerm, restarted.commitIndex)
+	}
+}
+
+// TestRaftMembershipChange 成员变更在追加到日志时生效，被截断后撤销，leader 一次只接受一个未提交的成员变更
+func TestRaftMembershipChange(t *testing.T) {
+	const newNode = "127.0.0.1:6401"
+	addNode := metaCommand{Op: opAddNode, Node: newNode, BusAddr: "127.0.0.1:16401"}
+	rf := makeTestRaftNode(t, t.TempDir())
+	rf.currentTerm = 1
+	rf.log = []raftEntry{bootstrapEntry(1)}
+	rf.updateMembers()
+	rf.commitIndex = 1
+	rf.applyCommitted()
+
+	resp := rf.handleAppend(&appendRequest{Term: 1, Leader: testPeer, PrevLogIndex: 1, PrevLogTerm: 1,
+		Entries: []raftEntry{{Term: 1, Index: 2, Command: addNode}}})
+	if !resp.Success || len(rf.members) != 3 || rf.quorum() != 2 {
+		t.Fatalf("members = %v after appending addnode", rf.members)
+	}
+	if _, ok := rf.cluster.topology.getNode(newNode); ok {
+		t.Errorf("%s joins the topology before the entry is committed", newNode)
+	}
+	// 新 leader 覆盖未提交的成员变更
+	resp = rf.handleAppend(&appendRequest{Term: 2, Leader: testPeer, PrevLogIndex: 1, PrevLogTerm: 1,
+		Entries: []raftEntry{setSlotEntry(2, 2, "0-99", testPeer)}})
+	if _, ok := rf.members[newNode]; !resp.Success || ok {
+		t.Fatalf("members = %v after the addnode entry is overwritten", rf.members)
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.becomeLeader()
+	if _, _, err := rf.proposeLocal(addNode); err != errMembershipChanging {
+		t.Errorf("addnode before committing an entry of the current term: %v", err)
+	}
+	// 代替后台协程 fsync 新条目
+	rf.durable = rf.written
+	rf.matchIndex[testPeer] = rf.lastIndex()
+	rf.advanceCommit()
+	if _, _, err := rf.proposeLocal(addNode); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rf.members[newNode]; !ok {
+		t.Errorf("%s is not a member after appending addnode", newNode)
+	}
+	delNode := metaCommand{Op: opDelNode, Node: testPeer}
+	if _, _, err := rf.proposeLocal(delNode); err != errMembershipChanging {
+		t.Errorf("delnode before addnode is committed: %v", err)
+	}
+	// 代替后台协程 fsync 新条目
+	rf.durable = rf.written
+	rf.matchIndex[testPeer] = rf.lastIndex()
+	rf.advanceCommit()
+	if rf.commitIndex != rf.lastIndex() {
+		t.Fatalf("addnode is not committed by 2 of 3 members")
+	}
+	if _, _, err := rf.proposeLocal(delNode); err != nil {
+		t.Fatal(err)
+	}
+}
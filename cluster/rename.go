@@ -2,23 +2,93 @@ package cluster
 
 import (
 	"go-redis/interface/resp"
+	"go-redis/lib/utils"
 	"go-redis/resp/reply"
+	"strings"
 )
 
-// Rename 重命名一个键，源键和目标键必须在同一节点内
+// Rename 重命名一个键
+// 源键和目标键位于不同节点时，以 TCC 事务在源节点删除源键、在目标节点写入其序列化后的值
 func Rename(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(args[0]))
 	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'rename' command")
+		return reply.MakeErrReply("ERR wrong number of arguments for '" + cmdName + "' command")
 	}
 	src := string(args[1])
 	dest := string(args[2])
 	// 选择源键和目标键所在的节点
-	srcPeer := cluster.peerPicker.PickNode(src)
-	destPeer := cluster.peerPicker.PickNode(dest)
-	// 源键和目标键必须在同一节点内
-	if srcPeer != destPeer {
-		return reply.MakeErrReply("ERR rename must within one slot in cluster mode")
-	}
-	// 调用 relay 方法中继命令到源节点
-	return cluster.relay(srcPeer, c, args)
+	srcPeer := cluster.pickNode(src)
+	destPeer := cluster.pickNode(dest)
+	if srcPeer == destPeer {
+		// 调用 relay 方法中继命令到源节点
+		return cluster.relay(srcPeer, c, args)
+	}
+	isNX := cmdName == "renamenx"
+
+	txID := cluster.newTxID()
+	// Prepare 源节点时锁定源键并取得其序列化后的值
+	ret := cluster.requestPrepare(c, txID, srcPeer, utils.ToCmdLine("RenameFrom", src))
+	if reply.IsErrorReply(ret) {
+		return ret
+	}
+	payload, ok := ret.(*reply.BulkReply)
+	if !ok {
+		cluster.requestRollback(c, txID, []string{srcPeer})
+		return reply.MakeErrReply("ERR unexpected prepare reply " + string(ret.ToBytes()))
+	}
+	toCmd := "RenameTo"
+	if isNX {
+		toCmd = "RenameNxTo"
+	}
+	cmdLine := utils.ToCmdLine(toCmd, dest)
+	cmdLine = append(cmdLine, payload.Arg)
+	ret = cluster.requestPrepare(c, txID, destPeer, cmdLine)
+	if errReply, ok := ret.(reply.ErrorReply); ok {
+		cluster.requestRollback(c, txID, []string{srcPeer, destPeer})
+		if isNX && errReply.Error() == errKeyExists {
+			return reply.MakeIntReply(0)
+		}
+		return errReply
+	}
+	if _, errReply := cluster.requestCommit(c, txID, []string{srcPeer, destPeer}); errReply != nil {
+		return reply.MakeErrReply("error occurs: " + errReply.Error())
+	}
+	if isNX {
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeOkReply()
+}
+
+// prepareRenameFrom 检查源键存在并返回其序列化后的值
+func prepareRenameFrom(tx *Transaction) resp.Reply {
+	ret := tx.cluster.db.ExecWithLock(tx.conn, tx.epoch, utils.ToCmdLine2("DUMP", tx.cmdLine[1]))
+	if reply.IsErrorReply(ret) {
+		return ret
+	}
+	if _, ok := ret.(*reply.BulkReply); !ok {
+		return reply.MakeErrReply("no such key")
+	}
+	return ret
+}
+
+// prepareRenameNxTo 检查目标键不存在
+func prepareRenameNxTo(tx *Transaction) resp.Reply {
+	exists, errReply := tx.existsLocked(string(tx.cmdLine[1]))
+	if errReply != nil {
+		return errReply
+	}
+	if exists {
+		return reply.MakeErrReply(errKeyExists)
+	}
+	return nil
+}
+
+// existsLocked 在已持有锁的情况下判断 key 是否存在于本节点，数据库已被替换时返回错误
+func (tx *Transaction) existsLocked(key string) (bool, resp.Reply) {
+	ret := tx.cluster.db.ExecWithLock(tx.conn, tx.epoch, utils.ToCmdLine("EXISTS", key))
+	if reply.IsErrorReply(ret) {
+		return false, ret
+	}
+	intReply, ok := ret.(*reply.IntReply)
+	return ok && intReply.Code > 0, nil
 }
@@ -1,6 +1,11 @@
 package cluster
 
-import "go-redis/interface/resp"
+import (
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"strings"
+)
 
 // CmdLine 是 [][]byte 的别名，表示一个命令行
 type CmdLine = [][]byte
@@ -13,9 +18,17 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["ping"] = ping
 
 	routerMap["del"] = Del
+	routerMap["unlink"] = Del
+	routerMap["touch"] = Touch
+	routerMap["move"] = defaultFunc
+	routerMap["copy"] = Copy
 
 	routerMap["exists"] = defaultFunc
 	routerMap["type"] = defaultFunc
+	routerMap["object"] = Object
+	routerMap["memory"] = Memory
+	routerMap["slowlog"] = execLocal
+	routerMap["latency"] = execLocal
 	routerMap["rename"] = Rename
 	routerMap["renamenx"] = Rename
 
@@ -23,8 +36,30 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["setnx"] = defaultFunc
 	routerMap["get"] = defaultFunc
 	routerMap["getset"] = defaultFunc
+	routerMap["mset"] = MSet
+	routerMap["msetnx"] = MSetNX
 
+	routerMap["dump"] = defaultFunc
+	routerMap["restore"] = defaultFunc
+	routerMap["restore-asking"] = execRestoreAsking
+	routerMap["migrate"] = execMigrate
+	routerMap["cluster"] = execCluster
+	routerMap[askingExecCmd] = execAskingExec
+
+	routerMap[prepareCmd] = execPrepare
+	routerMap[commitCmd] = execCommit
+	routerMap[rollbackCmd] = execRollback
+
+	routerMap[localExecCmd] = execLocalExec
+
+	routerMap["keys"] = Keys
+	routerMap["scan"] = Scan
+	routerMap["dbsize"] = DBSize
+	routerMap["randomkey"] = RandomKey
+	routerMap["info"] = Info
+	routerMap["flushall"] = FlushAll
 	routerMap["flushdb"] = FlushDB
+	routerMap["swapdb"] = SwapDB
 	routerMap["select"] = execSelect
 
 	return routerMap
@@ -33,7 +68,96 @@ func makeRouter() map[string]CmdFunc {
 // 将命令转发到负责的节点，并将其回复返回给客户端
 func defaultFunc(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
 	key := string(args[1])
-	// 通过key使用一致性哈希寻找节点，并转发
-	peer := cluster.peerPicker.PickNode(key)
+	return cluster.relayByKey(c, key, args)
+}
+
+// Object 将 OBJECT subcommand key 转发到 key 所在的节点
+func Object(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 {
+		return cluster.db.Exec(c, args)
+	}
+	return cluster.relayByKey(c, string(args[2]), args)
+}
+
+// Memory 将 MEMORY USAGE key 转发到 key 所在的节点，其他子命令只报告本节点的情况
+func Memory(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) >= 3 && strings.EqualFold(string(args[1]), "usage") {
+		return cluster.relayByKey(c, string(args[2]), args)
+	}
+	return cluster.db.Exec(c, args)
+}
+
+// execLocal 在本节点执行命令，用于只报告本节点情况的命令
+func execLocal(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	return cluster.db.Exec(c, args)
+}
+
+// askingExecCmd 是节点间使用的内部命令，格式为 AskingExec cmd [args...]
+// 槽位迁移期间源节点将不存在于本地的 key 转交给目标节点时使用，目标节点收到后直接在本地执行
+const askingExecCmd = "askingexec"
+
+// localExecCmd 是节点间使用的内部命令，格式为 LocalExec cmd [args...]
+// 广播时使用，接收节点直接在本地数据库上执行而不再次路由，避免广播在节点间循环
+const localExecCmd = "localexec"
+
+// pickNode 根据 key 所在的哈希槽寻找负责的节点
+func (cluster *ClusterDatabase) pickNode(key string) string {
+	return cluster.slots.getOwner(getSlot(key))
+}
+
+// relayByKey 将命令转发到 key 所在的节点
+// 若 key 所在的槽正由本节点迁出，且本地已不存在该 key，则交由迁移目标节点处理
+func (cluster *ClusterDatabase) relayByKey(c resp.Connection, key string, args [][]byte) resp.Reply {
+	slot := getSlot(key)
+	peer := cluster.slots.getOwner(slot)
+	if peer == cluster.self {
+		if target, ok := cluster.slots.getMigrating(slot); ok && !cluster.existsLocally(c, key) {
+			return cluster.relay(target, c, utils.ToCmdLine2(askingExecCmd, args...))
+		}
+	}
 	return cluster.relay(peer, c, args)
 }
+
+// existsLocally 判断 key 是否存在于本节点
+func (cluster *ClusterDatabase) existsLocally(c resp.Connection, key string) bool {
+	ret := cluster.db.Exec(c, utils.ToCmdLine("EXISTS", key))
+	intReply, ok := ret.(*reply.IntReply)
+	return ok && intReply.Code > 0
+}
+
+// servesLocally 判断本节点是否可以直接处理该槽位的请求：槽位归属本节点或正在迁入本节点
+func (cluster *ClusterDatabase) servesLocally(slot uint32) bool {
+	return cluster.slots.getOwner(slot) == cluster.self || cluster.slots.isImporting(slot)
+}
+
+// execAskingExec 处理源节点转交过来的命令
+func execAskingExec(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 {
+		return reply.MakeArgNumErrReply("askingexec")
+	}
+	cmdLine := args[1:]
+	if !cluster.servesLocally(getSlot(string(cmdLine[1]))) {
+		// 源节点认为槽位正在迁往本节点，但本节点尚未进入迁入状态
+		return reply.MakeErrReply("TRYAGAIN slot is not importing on " + cluster.self)
+	}
+	return cluster.db.Exec(c, cmdLine)
+}
+
+// execLocalExec 处理其他节点广播过来的命令
+func execLocalExec(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply(localExecCmd)
+	}
+	return cluster.db.Exec(c, args[1:])
+}
+
+// execRestoreAsking 处理 MIGRATE 发来的 RESTORE-ASKING，迁入中的槽位直接在本地恢复
+func execRestoreAsking(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 4 {
+		return reply.MakeArgNumErrReply("restore-asking")
+	}
+	if cluster.servesLocally(getSlot(string(args[1]))) {
+		return cluster.db.Exec(c, args)
+	}
+	return defaultFunc(cluster, c, args)
+}
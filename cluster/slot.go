@@ -0,0 +1,181 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SlotCount 是集群中哈希槽的总数
+const SlotCount = 16384
+
+// getSlot 计算 key 所属的哈希槽，与 Redis 相同为 CRC16(key) % 16384，集群客户端可以在本地计算出相同的槽位
+// 与 Redis 一样支持 hash tag：若 key 中包含非空的 {...}，则只用第一个 { 与其后第一个 } 之间的内容计算槽位
+func getSlot(key string) uint32 {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return uint32(crc16(key)) % SlotCount
+}
+
+// slotRange 表示一段闭区间的哈希槽 [start, end]
+type slotRange struct {
+	start uint32
+	end   uint32
+}
+
+// parseSlotRange 解析 "slot" 或 "start-end" 格式的槽位
+func parseSlotRange(s string) (*slotRange, error) {
+	parts := strings.SplitN(s, "-", 2)
+	start, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil || start >= SlotCount {
+		return nil, errors.New("ERR Invalid or out of range slot")
+	}
+	end := start
+	if len(parts) == 2 {
+		end, err = strconv.ParseUint(parts[1], 10, 32)
+		if err != nil || end >= SlotCount || end < start {
+			return nil, errors.New("ERR Invalid or out of range slot")
+		}
+	}
+	return &slotRange{start: uint32(start), end: uint32(end)}, nil
+}
+
+func (r *slotRange) String() string {
+	if r.start == r.end {
+		return strconv.FormatUint(uint64(r.start), 10)
+	}
+	return fmt.Sprintf("%d-%d", r.start, r.end)
+}
+
+// slotTable 记录每个哈希槽的归属节点以及正在进行的迁移状态
+type slotTable struct {
+	mu        sync.RWMutex
+	owners    [SlotCount]string
+	migrating map[uint32]string // 本节点正在迁出的槽 -> 目标节点
+	importing map[uint32]string // 本节点正在迁入的槽 -> 源节点
+}
+
+// makeSlotTable 创建一个所有槽位均未分配的槽位表
+func makeSlotTable() *slotTable {
+	return &slotTable{
+		migrating: make(map[uint32]string),
+		importing: make(map[uint32]string),
+	}
+}
+
+// assignEvenly 将所有槽位按节点名排序后平均、连续地分配给各个节点
+func (table *slotTable) assignEvenly(nodes []string) {
+	sorted := make([]string, len(nodes))
+	copy(sorted, nodes)
+	sort.Strings(sorted)
+	if len(sorted) == 0 {
+		return
+	}
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for slot := 0; slot < SlotCount; slot++ {
+		table.owners[slot] = sorted[slot*len(sorted)/SlotCount]
+	}
+}
+
+// getOwner 返回槽位的归属节点
+func (table *slotTable) getOwner(slot uint32) string {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return table.owners[slot]
+}
+
+// getMigrating 返回槽位迁出的目标节点
+func (table *slotTable) getMigrating(slot uint32) (string, bool) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	node, ok := table.migrating[slot]
+	return node, ok
+}
+
+// isImporting 返回本节点是否正在迁入该槽位
+func (table *slotTable) isImporting(slot uint32) bool {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	_, ok := table.importing[slot]
+	return ok
+}
+
+func (table *slotTable) setMigrating(r *slotRange, node string) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for slot := r.start; slot <= r.end; slot++ {
+		table.migrating[slot] = node
+	}
+}
+
+func (table *slotTable) setImporting(r *slotRange, node string) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for slot := r.start; slot <= r.end; slot++ {
+		table.importing[slot] = node
+	}
+}
+
+// setStable 清除槽位的迁移状态
+func (table *slotTable) setStable(r *slotRange) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for slot := r.start; slot <= r.end; slot++ {
+		delete(table.migrating, slot)
+		delete(table.importing, slot)
+	}
+}
+
+// setOwner 将槽位分配给指定节点并清除迁移状态
+func (table *slotTable) setOwner(r *slotRange, node string) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for slot := r.start; slot <= r.end; slot++ {
+		table.owners[slot] = node
+		delete(table.migrating, slot)
+		delete(table.importing, slot)
+	}
+}
+
+// reset 清空所有槽位的归属及迁移状态
+func (table *slotTable) reset() {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.owners = [SlotCount]string{}
+	table.migrating = make(map[uint32]string)
+	table.importing = make(map[uint32]string)
+}
+
+// ranges 按节点汇总其负责的连续槽位区间
+func (table *slotTable) ranges() map[string][]*slotRange {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	result := make(map[string][]*slotRange)
+	for slot := uint32(0); slot < SlotCount; slot++ {
+		node := table.owners[slot]
+		if node == "" {
+			continue
+		}
+		list := result[node]
+		if len(list) > 0 && list[len(list)-1].end == slot-1 {
+			list[len(list)-1].end = slot
+		} else {
+			result[node] = append(list, &slotRange{start: slot, end: slot})
+		}
+	}
+	return result
+}
+
+// snapshotOwners 返回槽位归属的拷贝
+func (table *slotTable) snapshotOwners() [SlotCount]string {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return table.owners
+}
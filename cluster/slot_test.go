@@ -0,0 +1,38 @@
+package cluster
+
+import "testing"
+
+func TestCRC16(t *testing.T) {
+	// 标准的 CRC16 (XMODEM) 校验值
+	if got := crc16("123456789"); got != 0x31C3 {
+		t.Fatalf("crc16(123456789) = %#x, want 0x31c3", got)
+	}
+}
+
+func TestGetSlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot uint32
+	}{
+		// 与 redis-cli CLUSTER KEYSLOT 的结果一致
+		{"foo", 12182},
+		{"bar", 5061},
+		{"hello", 866},
+		{"", 0},
+		// hash tag 只使用花括号中的内容
+		{"{user1000}.following", getSlot("user1000")},
+		{"{user1000}.followers", getSlot("user1000")},
+		// 空的 hash tag 使用整个 key
+		{"foo{}{bar}", uint32(crc16("foo{}{bar}")) % SlotCount},
+		// 只使用第一个 { 与其后第一个 } 之间的内容
+		{"foo{{bar}}zap", getSlot("{bar")},
+		{"foo{bar}{zap}", getSlot("bar")},
+		// 没有闭合的花括号时使用整个 key
+		{"foo{bar", uint32(crc16("foo{bar")) % SlotCount},
+	}
+	for _, tt := range tests {
+		if got := getSlot(tt.key); got != tt.slot {
+			t.Errorf("getSlot(%q) = %d, want %d", tt.key, got, tt.slot)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"go-redis/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 跨节点的多 key 命令以 TCC（try-commit-cancel）方式执行：
+// 协调者为事务分配 id，向每个相关节点发送 Prepare，参与者锁定 key、校验并记录回滚日志；
+// 全部成功后发送 Commit，任一失败则向所有节点发送 Rollback。
+// 参与者在 Prepare 之后若迟迟收不到 Commit 会自动回滚并释放锁，避免协调者宕机导致 key 被永久锁住。
+
+const (
+	// 参与者在 Prepare 后最多持有锁的时间
+	maxLockTime = 3 * time.Second
+	// 已提交的事务在这段时间内仍可被回滚
+	waitBeforeCleanTx = 2 * maxLockTime
+)
+
+// 事务内部命令，格式为：
+//
+//	Prepare txID cmd [args...]
+//	Commit txID
+//	Rollback txID
+const (
+	prepareCmd  = "prepare"
+	commitCmd   = "commit"
+	rollbackCmd = "rollback"
+)
+
+// 参与者事务状态
+const (
+	createdStatus = iota
+	preparedStatus
+	committedStatus
+	rolledBackStatus
+)
+
+// errKeyExists 由 MSETNX、RENAMENX 的 Prepare 校验返回，协调者据此回滚并返回 0
+const errKeyExists = "ERR key already exists"
+
+// Transaction 是参与者一侧的事务
+type Transaction struct {
+	id        string
+	cmdLine   [][]byte
+	cluster   *ClusterDatabase
+	conn      *connection.FakeConn // 以事务开始时的数据库执行命令
+	dbIndex   int
+	epoch     uint64 // 加锁时数据库内容的 epoch，期间执行 FLUSHALL、SWAPDB 等命令后事务不能再提交或回滚
+	writeKeys []string
+	readKeys  []string
+	undoLog   []CmdLine
+	status    int8
+	timer     *time.Timer // 超时未提交时自动回滚
+	mu        sync.Mutex
+}
+
+// PrepareFunc 在参与者锁定 key 之后、提交之前校验命令，返回错误回复时事务将被回滚
+// 返回非 nil 的正常回复时，该回复作为 Prepare 的结果返回给协调者
+type PrepareFunc func(tx *Transaction) resp.Reply
+
+var prepareFuncMap = map[string]PrepareFunc{
+	"msetnx":     prepareMSetNx,
+	"renamefrom": prepareRenameFrom,
+	"renamenxto": prepareRenameNxTo,
+}
+
+// NewTransaction 创建参与者事务
+func NewTransaction(cluster *ClusterDatabase, c resp.Connection, id string, cmdLine [][]byte) *Transaction {
+	conn := &connection.FakeConn{}
+	conn.SelectDB(c.GetDBIndex())
+	return &Transaction{
+		id:      id,
+		cmdLine: cmdLine,
+		cluster: cluster,
+		conn:    conn,
+		dbIndex: c.GetDBIndex(),
+		status:  createdStatus,
+	}
+}
+
+func (tx *Transaction) lockKeys() {
+	tx.epoch = tx.cluster.db.RWLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+}
+
+func (tx *Transaction) unLockKeys() {
+	tx.cluster.db.RWUnLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+}
+
+// prepare 锁定 key、校验命令并记录回滚日志
+func (tx *Transaction) prepare() resp.Reply {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.writeKeys, tx.readKeys = database.GetRelatedKeys(tx.cmdLine)
+	tx.lockKeys()
+	var result resp.Reply = reply.MakeOkReply()
+	if prepareFunc, ok := prepareFuncMap[strings.ToLower(string(tx.cmdLine[0]))]; ok {
+		if ret := prepareFunc(tx); ret != nil {
+			if reply.IsErrorReply(ret) {
+				tx.unLockKeys()
+				tx.status = rolledBackStatus
+				return ret
+			}
+			result = ret
+		}
+	}
+	undoLog, err := tx.cluster.db.GetUndoLogs(tx.dbIndex, tx.epoch, tx.cmdLine)
+	if err != nil {
+		tx.unLockKeys()
+		tx.status = rolledBackStatus
+		return reply.MakeErrReply(err.Error())
+	}
+	tx.undoLog = undoLog
+	tx.status = preparedStatus
+	tx.timer = time.AfterFunc(maxLockTime, func() {
+		tx.mu.Lock()
+		defer tx.mu.Unlock()
+		if tx.status == preparedStatus {
+			logger.Warn("abort transaction " + tx.id + ": commit timeout")
+			tx.unLockKeys()
+			tx.status = rolledBackStatus
+			tx.cluster.transactions.Remove(tx.id)
+		}
+	})
+	return result
+}
+
+// commit 执行命令并释放锁
+func (tx *Transaction) commit() resp.Reply {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.status != preparedStatus {
+		return reply.MakeErrReply("ERR transaction " + tx.id + " is not prepared")
+	}
+	tx.timer.Stop()
+	result := tx.cluster.db.ExecWithLock(tx.conn, tx.epoch, tx.cmdLine)
+	tx.unLockKeys()
+	if reply.IsErrorReply(result) {
+		// 执行失败时命令未产生修改，协调者会回滚其他节点
+		tx.status = rolledBackStatus
+		tx.cluster.transactions.Remove(tx.id)
+		return result
+	}
+	tx.status = committedStatus
+	// 保留一段时间以便其他节点提交失败时仍能回滚
+	time.AfterFunc(waitBeforeCleanTx, func() {
+		tx.cluster.transactions.Remove(tx.id)
+	})
+	return result
+}
+
+// rollback 撤销事务：未提交时只需释放锁，已提交时执行回滚日志
+// 提交后数据库被 FLUSHALL、SWAPDB 等命令替换时不再执行回滚日志，避免恢复已被清空的 key 或写入其他数据库
+func (tx *Transaction) rollback() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	switch tx.status {
+	case preparedStatus:
+		tx.timer.Stop()
+		tx.unLockKeys()
+	case committedStatus:
+		if err := tx.cluster.db.ExecUndoLogs(tx.dbIndex, tx.epoch, tx.writeKeys, tx.readKeys, tx.undoLog); err != nil {
+			logger.Warn("skip undo logs of transaction " + tx.id + ": " + err.Error())
+		}
+	}
+	tx.status = rolledBackStatus
+}
+
+// execPrepare 处理 Prepare txID cmd [args...]
+func execPrepare(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 {
+		return reply.MakeArgNumErrReply(prepareCmd)
+	}
+	txID := string(args[1])
+	tx := NewTransaction(cluster, c, txID, args[2:])
+	if cluster.transactions.PutIfAbsent(txID, tx) == 0 {
+		return reply.MakeErrReply("ERR duplicate transaction " + txID)
+	}
+	ret := tx.prepare()
+	if reply.IsErrorReply(ret) {
+		cluster.transactions.Remove(txID)
+	}
+	return ret
+}
+
+// execCommit 处理 Commit txID
+func execCommit(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply(commitCmd)
+	}
+	raw, ok := cluster.transactions.Get(string(args[1]))
+	if !ok {
+		return reply.MakeErrReply("ERR transaction " + string(args[1]) + " not found")
+	}
+	return raw.(*Transaction).commit()
+}
+
+// execRollback 处理 Rollback txID，事务不存在时视为已回滚
+func execRollback(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply(rollbackCmd)
+	}
+	txID := string(args[1])
+	raw, ok := cluster.transactions.Get(txID)
+	if !ok {
+		return reply.MakeIntReply(0)
+	}
+	raw.(*Transaction).rollback()
+	cluster.transactions.Remove(txID)
+	return reply.MakeIntReply(1)
+}
+
+// newTxID 生成集群内唯一的事务 id
+func (cluster *ClusterDatabase) newTxID() string {
+	return cluster.self + "#" + strconv.FormatUint(atomic.AddUint64(&cluster.txSeq, 1), 10)
+}
+
+// relayTx 在节点上执行 Prepare、Commit、Rollback 并等待结果
+// 使用事务专用的连接池，连接在收到响应后才归还，同一时刻只承载一个请求：
+// 若与其他请求共用管道连接，对端处理某个事务的 Prepare 等待 key 锁时，
+// 排在其后、会释放该锁的另一事务的 Commit 或 Rollback 无法被处理，两个事务互相等待直到超时
+func (cluster *ClusterDatabase) relayTx(peer string, c resp.Connection, args [][]byte) resp.Reply {
+	if peer == cluster.self {
+		return cluster.exec(c, args)
+	}
+	breaker := cluster.getBreaker(peer)
+	if breaker != nil && !breaker.allow() {
+		relayErrors.WithLabelValues(peer).Inc()
+		return unreachableErr(peer)
+	}
+	cluster.peerMu.RLock()
+	txPool, ok := cluster.txConnection[peer]
+	cluster.peerMu.RUnlock()
+	if !ok {
+		return reply.MakeErrReply("ERR connection pool not found")
+	}
+	peerClient, err := cluster.borrowClient(txPool)
+	if err != nil {
+		cluster.recordRelay(peer, err)
+		return reply.MakeErrReply(err.Error())
+	}
+	ret, err := peerClient.SendAsync(c.GetDBIndex(), args).Result(cluster.poolOpts.relayTimeout)
+	cluster.recordRelay(peer, err)
+	if err != nil {
+		// 请求可能仍在等待响应，销毁连接而不是归还，避免之后的请求排在它后面
+		_ = txPool.InvalidateObject(context.Background(), peerClient)
+		return reply.MakeErrReply(err.Error())
+	}
+	_ = txPool.ReturnObject(context.Background(), peerClient)
+	return ret
+}
+
+// requestPrepare 请求节点准备事务
+func (cluster *ClusterDatabase) requestPrepare(c resp.Connection, txID string, peer string, cmdLine CmdLine) resp.Reply {
+	args := utils.ToCmdLine(prepareCmd, txID)
+	args = append(args, cmdLine...)
+	return cluster.relayTx(peer, c, args)
+}
+
+// requestCommit 请求所有节点提交事务，任一节点失败时回滚所有节点
+func (cluster *ClusterDatabase) requestCommit(c resp.Connection, txID string, peers []string) (map[string]resp.Reply, reply.ErrorReply) {
+	result := make(map[string]resp.Reply, len(peers))
+	for _, peer := range peers {
+		ret := cluster.relayTx(peer, c, utils.ToCmdLine(commitCmd, txID))
+		if errReply, ok := ret.(reply.ErrorReply); ok {
+			logger.Warn(fmt.Sprintf("commit transaction %s on %s failed: %s", txID, peer, errReply.Error()))
+			cluster.requestRollback(c, txID, peers)
+			return nil, errReply
+		}
+		result[peer] = ret
+	}
+	return result, nil
+}
+
+// requestRollback 请求所有节点回滚事务
+func (cluster *ClusterDatabase) requestRollback(c resp.Connection, txID string, peers []string) {
+	for _, peer := range peers {
+		ret := cluster.relayTx(peer, c, utils.ToCmdLine(rollbackCmd, txID))
+		if reply.IsErrorReply(ret) {
+			logger.Warn(fmt.Sprintf("rollback transaction %s on %s failed: %s", txID, peer, string(ret.ToBytes())))
+		}
+	}
+}
+
+// tccExec 以事务方式在多个节点上分别执行给定的命令，返回各节点提交的结果
+// 所有节点 Prepare 成功后才会提交，Prepare 失败时返回该节点的错误
+func (cluster *ClusterDatabase) tccExec(c resp.Connection, cmdLines map[string]CmdLine) (map[string]resp.Reply, reply.ErrorReply) {
+	txID := cluster.newTxID()
+	// 所有协调者按相同的节点顺序 Prepare，避免并发事务在不同节点上互相等待对方持有的锁
+	peers := make([]string, 0, len(cmdLines))
+	for peer := range cmdLines {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	for i, peer := range peers {
+		ret := cluster.requestPrepare(c, txID, peer, cmdLines[peer])
+		if errReply, ok := ret.(reply.ErrorReply); ok {
+			cluster.requestRollback(c, txID, peers[:i+1])
+			return nil, errReply
+		}
+	}
+	return cluster.requestCommit(c, txID, peers)
+}
+
+// groupByNode 将 key 按所在节点分组
+func (cluster *ClusterDatabase) groupByNode(keys []string) map[string][]string {
+	result := make(map[string][]string)
+	for _, key := range keys {
+		peer := cluster.pickNode(key)
+		result[peer] = append(result[peer], key)
+	}
+	return result
+}
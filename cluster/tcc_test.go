@@ -0,0 +1,210 @@
+package cluster
+
+import (
+	"go-redis/database"
+	"go-redis/datastruct/dict"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// makeTestCluster 创建只包含本地数据库的节点，用于直接执行 Prepare、Commit、Rollback
+func makeTestCluster(t *testing.T) *ClusterDatabase {
+	cluster := &ClusterDatabase{
+		self:         "127.0.0.1:6399",
+		db:           database.NewStandaloneDatabase(),
+		transactions: dict.MakeSyncDict(),
+	}
+	t.Cleanup(cluster.db.Close)
+	return cluster
+}
+
+// execTimeout 执行命令，超时说明 key 锁没有被释放
+func execTimeout(t *testing.T, cluster *ClusterDatabase, args ...string) string {
+	t.Helper()
+	done := make(chan string, 1)
+	go func() {
+		done <- string(cluster.db.Exec(&connection.FakeConn{}, utils.ToCmdLine(args...)).ToBytes())
+	}()
+	select {
+	case ret := <-done:
+		return ret
+	case <-time.After(time.Second):
+		t.Fatalf("%v blocked, the keys are still locked", args)
+		return ""
+	}
+}
+
+// getValue 返回 key 的字符串值，key 不存在时返回 "<nil>"
+func getValue(t *testing.T, cluster *ClusterDatabase, key string) string {
+	t.Helper()
+	ret := cluster.db.Exec(&connection.FakeConn{}, utils.ToCmdLine("GET", key))
+	if bulk, ok := ret.(*reply.BulkReply); ok && bulk.Arg != nil {
+		return string(bulk.Arg)
+	}
+	return "<nil>"
+}
+
+func TestTransactionRollback(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  [][]string
+		cmd    []string
+		commit bool
+		// 提交后、回滚后各 key 的值
+		committed  map[string]string
+		rolledBack map[string]string
+	}{
+		{
+			name:       "overwrite committed",
+			setup:      [][]string{{"SET", "a", "1"}},
+			cmd:        []string{"SET", "a", "2"},
+			commit:     true,
+			committed:  map[string]string{"a": "2"},
+			rolledBack: map[string]string{"a": "1"},
+		},
+		{
+			name:       "create committed",
+			cmd:        []string{"MSET", "a", "1", "b", "2"},
+			commit:     true,
+			committed:  map[string]string{"a": "1", "b": "2"},
+			rolledBack: map[string]string{"a": "<nil>", "b": "<nil>"},
+		},
+		{
+			name:       "delete committed",
+			setup:      [][]string{{"SET", "a", "1"}, {"SET", "b", "2"}},
+			cmd:        []string{"DEL", "a", "b", "c"},
+			commit:     true,
+			committed:  map[string]string{"a": "<nil>", "b": "<nil>"},
+			rolledBack: map[string]string{"a": "1", "b": "2", "c": "<nil>"},
+		},
+		{
+			name:       "prepared only",
+			setup:      [][]string{{"SET", "a", "1"}},
+			cmd:        []string{"SET", "a", "2"},
+			rolledBack: map[string]string{"a": "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := makeTestCluster(t)
+			conn := &connection.FakeConn{}
+			for _, args := range tt.setup {
+				cluster.db.Exec(conn, utils.ToCmdLine(args...))
+			}
+			prepare := append([]string{"Prepare", "tx1"}, tt.cmd...)
+			if ret := execPrepare(cluster, conn, utils.ToCmdLine(prepare...)); reply.IsErrorReply(ret) {
+				t.Fatalf("prepare: %s", ret.ToBytes())
+			}
+			if tt.commit {
+				if ret := execCommit(cluster, conn, utils.ToCmdLine("Commit", "tx1")); reply.IsErrorReply(ret) {
+					t.Fatalf("commit: %s", ret.ToBytes())
+				}
+				for key, want := range tt.committed {
+					if got := getValue(t, cluster, key); got != want {
+						t.Errorf("after commit %s = %s, want %s", key, got, want)
+					}
+				}
+			}
+			ret := execRollback(cluster, conn, utils.ToCmdLine("Rollback", "tx1"))
+			if code := ret.(*reply.IntReply).Code; code != 1 {
+				t.Fatalf("rollback returned %d, want 1", code)
+			}
+			for key, want := range tt.rolledBack {
+				if got := getValue(t, cluster, key); got != want {
+					t.Errorf("after rollback %s = %s, want %s", key, got, want)
+				}
+			}
+			// 回滚后事务被删除，key 锁被释放
+			if _, ok := cluster.transactions.Get("tx1"); ok {
+				t.Errorf("transaction is not removed after rollback")
+			}
+			for key := range tt.rolledBack {
+				execTimeout(t, cluster, "SET", key, "x")
+			}
+			if ret := execRollback(cluster, conn, utils.ToCmdLine("Rollback", "tx1")); ret.(*reply.IntReply).Code != 0 {
+				t.Errorf("second rollback returned %d, want 0", ret.(*reply.IntReply).Code)
+			}
+		})
+	}
+}
+
+func TestTransactionPrepareFails(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup [][]string
+		cmd   []string
+		want  string
+	}{
+		{"msetnx key exists", [][]string{{"SET", "b", "1"}}, []string{"MSETNX", "a", "1", "b", "2"}, errKeyExists},
+		{"rename from missing key", nil, []string{"RenameFrom", "a"}, "no such key"},
+		{"renamenx to existing key", [][]string{{"SET", "a", "1"}}, []string{"RenameNxTo", "a", "v"}, errKeyExists},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := makeTestCluster(t)
+			conn := &connection.FakeConn{}
+			for _, args := range tt.setup {
+				cluster.db.Exec(conn, utils.ToCmdLine(args...))
+			}
+			txID := "tx" + strconv.Itoa(i)
+			prepare := append([]string{"Prepare", txID}, tt.cmd...)
+			ret := execPrepare(cluster, conn, utils.ToCmdLine(prepare...))
+			errReply, ok := ret.(reply.ErrorReply)
+			if !ok || errReply.Error() != tt.want {
+				t.Fatalf("prepare returned %q, want %q", ret.ToBytes(), tt.want)
+			}
+			if _, ok := cluster.transactions.Get(txID); ok {
+				t.Errorf("failed transaction is not removed")
+			}
+			execTimeout(t, cluster, "SET", tt.cmd[1], "x")
+		})
+	}
+}
+
+// TestTransactionDatasetChanged 事务持有 key 锁期间不阻塞 FLUSHALL、SWAPDB，之后的提交失败，已提交事务的回滚日志不再执行
+func TestTransactionDatasetChanged(t *testing.T) {
+	tests := []struct {
+		name   string
+		cmd    []string
+		commit bool // 在替换数据库之前提交
+	}{
+		{"flushall before commit", []string{"FLUSHALL"}, false},
+		{"swapdb before commit", []string{"SWAPDB", "0", "1"}, false},
+		{"flushdb before rollback", []string{"FLUSHDB"}, true},
+		{"swapdb before rollback", []string{"SWAPDB", "0", "1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := makeTestCluster(t)
+			conn := &connection.FakeConn{}
+			if ret := execPrepare(cluster, conn, utils.ToCmdLine("Prepare", "tx1", "SET", "a", "1")); reply.IsErrorReply(ret) {
+				t.Fatalf("prepare: %s", ret.ToBytes())
+			}
+			if tt.commit {
+				if ret := execCommit(cluster, conn, utils.ToCmdLine("Commit", "tx1")); reply.IsErrorReply(ret) {
+					t.Fatalf("commit: %s", ret.ToBytes())
+				}
+			}
+			if ret := execTimeout(t, cluster, tt.cmd...); ret != "+OK\r\n" {
+				t.Fatalf("%v returned %q", tt.cmd, ret)
+			}
+			if !tt.commit {
+				ret := execCommit(cluster, conn, utils.ToCmdLine("Commit", "tx1"))
+				if !reply.IsErrorReply(ret) {
+					t.Fatalf("commit succeeded after %v", tt.cmd)
+				}
+			} else {
+				execRollback(cluster, conn, utils.ToCmdLine("Rollback", "tx1"))
+			}
+			// 未提交的命令不执行，已提交的命令不会在替换后的数据库中被回滚
+			if got := getValue(t, cluster, "a"); got != "<nil>" {
+				t.Errorf("a = %s, want <nil>", got)
+			}
+			execTimeout(t, cluster, "SET", "a", "x")
+		})
+	}
+}
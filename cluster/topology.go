@@ -0,0 +1,126 @@
+package cluster
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 默认集群总线端口为服务端口加上该偏移量
+	busPortOffset = 10000
+)
+
+// 节点状态标志
+const (
+	flagPFail = "pfail" // 本节点认为其可能已下线
+	flagFail  = "fail"  // 多数节点确认其已下线
+)
+
+// clusterNode 记录集群中一个节点的状态
+type clusterNode struct {
+	addr        string
+	busAddr     string
+	flag        string               // 空、pfail 或 fail
+	pingSent    time.Time            // 最近一次尚未得到回应的 ping 的发送时间，零值表示没有待回应的 ping
+	pongRecv    time.Time            // 最近一次收到该节点消息的时间
+	failReports map[string]time.Time // 报告该节点疑似下线的节点 -> 报告时间
+}
+
+// topology 维护集群成员及其在线状态
+// 成员的加入与移除由 Raft 日志决定，gossip 只负责探测节点是否在线
+type topology struct {
+	mu    sync.RWMutex
+	self  string
+	nodes map[string]*clusterNode
+}
+
+func makeTopology(self string) *topology {
+	return &topology{
+		self:  self,
+		nodes: make(map[string]*clusterNode),
+	}
+}
+
+// defaultBusAddr 根据节点的服务地址推导默认的集群总线地址
+func defaultBusAddr(addr string) string {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port+busPortOffset))
+}
+
+// parseNodeAddr 解析 "host:port" 或 "host:port@busport" 格式的节点地址
+func parseNodeAddr(s string) (addr string, busAddr string) {
+	parts := strings.SplitN(s, "@", 2)
+	addr = parts[0]
+	if len(parts) == 2 {
+		host, _, _ := net.SplitHostPort(addr)
+		return addr, net.JoinHostPort(host, parts[1])
+	}
+	return addr, defaultBusAddr(addr)
+}
+
+// getNodes 返回所有已知节点（包含自身），按地址排序
+func (t *topology) getNodes() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	result := make([]string, 0, len(t.nodes))
+	for addr := range t.nodes {
+		result = append(result, addr)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// getNode 返回指定节点信息的拷贝
+func (t *topology) getNode(addr string) (clusterNode, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	node, ok := t.nodes[addr]
+	if !ok {
+		return clusterNode{}, false
+	}
+	return *node, true
+}
+
+// addNode 将节点加入成员列表，已存在时返回 false
+func (t *topology) addNode(addr string, busAddr string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.nodes[addr]; ok || addr == "" {
+		return false
+	}
+	if busAddr == "" {
+		busAddr = defaultBusAddr(addr)
+	}
+	t.nodes[addr] = &clusterNode{
+		addr:        addr,
+		busAddr:     busAddr,
+		pongRecv:    time.Now(),
+		failReports: make(map[string]time.Time),
+	}
+	return true
+}
+
+// removeNode 将节点移出成员列表
+func (t *topology) removeNode(addr string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.nodes[addr]; !ok {
+		return false
+	}
+	delete(t.nodes, addr)
+	for _, node := range t.nodes {
+		delete(node.failReports, addr)
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"bufio"
+	"errors"
 	"go-redis/lib/logger"
 	"io"
 	"os"
@@ -13,31 +14,111 @@ import (
 // ServerProperties defines global config properties
 type ServerProperties struct {
 	Bind           string `cfg:"bind"`
-	Port           int    `cfg:"port"`
+	Port           int    `cfg:"port"` // 0 disables the plain TCP listener, tls-port or unixsocket must then be set
 	AppendOnly     bool   `cfg:"appendOnly"`
 	AppendFilename string `cfg:"appendFilename"`
+	AppendFsync    string `cfg:"appendfsync"` // always, everysec or no, defaults to everysec
 	MaxClients     int    `cfg:"maxclients"`
 	RequirePass    string `cfg:"requirepass"`
 	Databases      int    `cfg:"databases"`
+	MetricsPort    int    `cfg:"metrics-port"` // port of the Prometheus /metrics endpoint, 0 disables it
 
-	Peers []string `cfg:"peers"`
-	Self  string   `cfg:"self"`
+	// <class> <hard limit> <soft limit> <soft seconds> for classes normal, replica and pubsub,
+	// the directive may be repeated once per class
+	ClientOutputBufferLimit string `cfg:"client-output-buffer-limit"`
+
+	// TLS listener, 0 disables it
+	TLSPort        int    `cfg:"tls-port"`
+	TLSCertFile    string `cfg:"tls-cert-file"`
+	TLSKeyFile     string `cfg:"tls-key-file"`
+	TLSCACertFile  string `cfg:"tls-ca-cert-file"` // CA used to verify clients and other nodes
+	TLSAuthClients string `cfg:"tls-auth-clients"` // yes, no or optional, defaults to yes when tls-ca-cert-file is set
+	TLSCluster     bool   `cfg:"tls-cluster"`      // connect to other nodes with TLS, self and peers are then TLS addresses
+
+	// path of the Unix domain socket listener, empty disables it
+	UnixSocket     string `cfg:"unixsocket"`
+	UnixSocketPerm string `cfg:"unixsocketperm"` // octal permission bits of the socket file, e.g. 700
+
+	// the largest bulk string and the largest total size of one command accepted from clients
+	ProtoMaxBulkLen        int64 `cfg:"proto-max-bulk-len"`
+	ClientQueryBufferLimit int64 `cfg:"client-query-buffer-limit"`
+
+	// close clients idle for more than Timeout seconds, 0 disables it
+	Timeout      int `cfg:"timeout"`
+	TCPKeepalive int `cfg:"tcp-keepalive"` // seconds between TCP keepalive probes of clients, 0 disables keepalive
+
+	// seconds to wait for in-flight commands on shutdown before the remaining clients are closed.
+	// Unlike redis there is no replication, so no replicas are waited for
+	ShutdownTimeout int `cfg:"shutdown-timeout"`
+
+	// memory limit, accepts units such as 100mb or 1gb, 0 means no limit
+	MaxMemory int64 `cfg:"maxmemory"`
+	// keys cannot have an expire, so the volatile-* policies evict nothing and behave like noeviction
+	MaxMemoryPolicy  string `cfg:"maxmemory-policy"`
+	MaxMemorySamples int    `cfg:"maxmemory-samples"`
+	LfuLogFactor     int    `cfg:"lfu-log-factor"`
+	LfuDecayTime     int    `cfg:"lfu-decay-time"` // minutes
+
+	// commands slower than SlowlogLogSlowerThan microseconds are logged, a negative value disables the slowlog
+	SlowlogLogSlowerThan    int `cfg:"slowlog-log-slower-than"`
+	SlowlogMaxLen           int `cfg:"slowlog-max-len"`
+	LatencyMonitorThreshold int `cfg:"latency-monitor-threshold"` // milliseconds, 0 disables latency monitoring
+
+	ClusterEnabled     bool     `cfg:"cluster-enabled"`
+	Peers              []string `cfg:"peers"`
+	Self               string   `cfg:"self"`
+	ClusterBusPort     int      `cfg:"cluster-bus-port"`
+	ClusterNodeTimeout int      `cfg:"cluster-node-timeout"` // milliseconds
+
+	// connections to other cluster nodes
+	ClusterPoolMaxTotal      int `cfg:"cluster-pool-max-total"`
+	ClusterPoolMaxIdle       int `cfg:"cluster-pool-max-idle"`
+	ClusterPoolBorrowTimeout int `cfg:"cluster-pool-borrow-timeout"` // milliseconds
+	ClusterPoolCheckInterval int `cfg:"cluster-pool-check-interval"` // milliseconds, how often idle connections are pinged
+	ClusterRelayTimeout      int `cfg:"cluster-relay-timeout"`       // milliseconds
+	ClusterBreakerThreshold  int `cfg:"cluster-breaker-threshold"`   // consecutive failures before a peer is considered unreachable
+	ClusterBreakerCooldown   int `cfg:"cluster-breaker-cooldown"`    // milliseconds before an unreachable peer is probed again
 }
 
+const (
+	defaultSlowlogLogSlowerThan   = 10000
+	defaultSlowlogMaxLen          = 128
+	defaultProtoMaxBulkLen        = 512 << 20
+	defaultClientQueryBufferLimit = 1 << 30
+	defaultShutdownTimeout        = 10
+	defaultTCPKeepalive           = 300
+)
+
 // Properties holds global config properties
 var Properties *ServerProperties
 
-func init() {
-	// default config
-	Properties = &ServerProperties{
-		Bind:       "127.0.0.1",
-		Port:       6379,
-		AppendOnly: false,
+// repeatableKeys are directives which may appear on several lines, their values are joined by spaces
+var repeatableKeys = map[string]bool{
+	"client-output-buffer-limit": true,
+}
+
+// defaultProperties returns the defaults used when there is no config file, directives missing from the file keep them
+func defaultProperties() *ServerProperties {
+	return &ServerProperties{
+		Bind:      "0.0.0.0",
+		Port:      6379,
+		Databases: 16,
+
+		SlowlogLogSlowerThan:   defaultSlowlogLogSlowerThan,
+		SlowlogMaxLen:          defaultSlowlogMaxLen,
+		ProtoMaxBulkLen:        defaultProtoMaxBulkLen,
+		ClientQueryBufferLimit: defaultClientQueryBufferLimit,
+		ShutdownTimeout:        defaultShutdownTimeout,
+		TCPKeepalive:           defaultTCPKeepalive,
 	}
 }
 
+func init() {
+	Properties = defaultProperties()
+}
+
 func parse(src io.Reader) *ServerProperties {
-	config := &ServerProperties{}
+	config := defaultProperties()
 
 	// read config file
 	rawMap := make(map[string]string)
@@ -49,9 +130,12 @@ func parse(src io.Reader) *ServerProperties {
 		}
 		pivot := strings.IndexAny(line, " ")
 		if pivot > 0 && pivot < len(line)-1 { // separator found
-			key := line[0:pivot]
+			key := strings.ToLower(line[0:pivot])
 			value := strings.Trim(line[pivot+1:], " ")
-			rawMap[strings.ToLower(key)] = value
+			if prev, ok := rawMap[key]; ok && repeatableKeys[key] {
+				value = prev + " " + value
+			}
+			rawMap[key] = value
 		}
 	}
 	if err := scanner.Err(); err != nil {
@@ -80,6 +164,13 @@ func parse(src io.Reader) *ServerProperties {
 				if err == nil {
 					fieldVal.SetInt(intValue)
 				}
+			case reflect.Int64:
+				size, err := ParseMemorySize(value)
+				if err == nil {
+					fieldVal.SetInt(size)
+				} else {
+					logger.Warn("invalid value for " + key + ": " + value)
+				}
 			case reflect.Bool:
 				boolValue := "yes" == value
 				fieldVal.SetBool(boolValue)
@@ -94,6 +185,36 @@ func parse(src io.Reader) *ServerProperties {
 	return config
 }
 
+// ParseMemorySize parses a memory size like redis.conf, e.g. 1024, 1k, 1kb, 100mb, 2gb.
+// k/m/g are powers of 1000 while kb/mb/gb are powers of 1024
+func ParseMemorySize(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	units := []struct {
+		suffix string
+		unit   int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	multiplier := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(value, u.suffix) {
+			value = strings.TrimSuffix(value, u.suffix)
+			multiplier = u.unit
+			break
+		}
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if size < 0 {
+		return 0, errors.New("negative memory size")
+	}
+	return size * multiplier, nil
+}
+
 // SetupConfig read config file and store properties into Properties
 func SetupConfig(configFilename string) {
 	file, err := os.Open(configFilename)
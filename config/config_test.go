@@ -0,0 +1,51 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseKeepsDefaults(t *testing.T) {
+	props := parse(strings.NewReader("port 7000\n# comment\nproto-max-bulk-len 1mb\n" +
+		"client-output-buffer-limit normal 0 0 0\nclient-output-buffer-limit pubsub 32mb 8mb 60\n"))
+	defaults := defaultProperties()
+
+	if props.Port != 7000 {
+		t.Errorf("port = %d, want 7000", props.Port)
+	}
+	if props.ProtoMaxBulkLen != 1<<20 {
+		t.Errorf("proto-max-bulk-len = %d, want %d", props.ProtoMaxBulkLen, 1<<20)
+	}
+	if want := "normal 0 0 0 pubsub 32mb 8mb 60"; props.ClientOutputBufferLimit != want {
+		t.Errorf("client-output-buffer-limit = %q, want %q", props.ClientOutputBufferLimit, want)
+	}
+	// 配置文件中没有出现的配置项保持默认值
+	if props.Bind != defaults.Bind || props.Databases != defaults.Databases ||
+		props.ClientQueryBufferLimit != defaults.ClientQueryBufferLimit ||
+		props.ShutdownTimeout != defaults.ShutdownTimeout || props.TCPKeepalive != defaults.TCPKeepalive {
+		t.Errorf("defaults not kept: %+v", props)
+	}
+}
+
+func TestParseMemorySize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"1024", 1024, true},
+		{"1k", 1000, true},
+		{"1kb", 1024, true},
+		{"100mb", 100 << 20, true},
+		{"2GB", 2 << 30, true},
+		{"1g", 1000 * 1000 * 1000, true},
+		{"abc", 0, false},
+		{"-1mb", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseMemorySize(tt.value)
+		if (err == nil) != tt.ok || (tt.ok && got != tt.want) {
+			t.Errorf("ParseMemorySize(%q) = %d, %v, want %d, ok=%v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
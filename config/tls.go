@@ -0,0 +1,87 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"strings"
+)
+
+// TLSServerConfig builds the config of the TLS listener from tls-cert-file, tls-key-file,
+// tls-ca-cert-file and tls-auth-clients
+func (p *ServerProperties) TLSServerConfig() (*tls.Config, error) {
+	if p.TLSCertFile == "" || p.TLSKeyFile == "" {
+		return nil, errors.New("tls-cert-file and tls-key-file are required")
+	}
+	cert, err := tls.LoadX509KeyPair(p.TLSCertFile, p.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	authClients := strings.ToLower(p.TLSAuthClients)
+	if authClients == "" {
+		authClients = "no"
+		if p.TLSCACertFile != "" {
+			authClients = "yes"
+		}
+	}
+	switch authClients {
+	case "no":
+		cfg.ClientAuth = tls.NoClientCert
+		return cfg, nil
+	case "yes":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, errors.New("tls-auth-clients must be yes, no or optional")
+	}
+	if p.TLSCACertFile == "" {
+		return nil, errors.New("tls-auth-clients " + authClients + " requires tls-ca-cert-file")
+	}
+	cfg.ClientCAs, err = loadCertPool(p.TLSCACertFile)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// TLSClientConfig builds the config used to connect to other nodes. Peers are verified with
+// tls-ca-cert-file, or the system roots if it is not set, and tls-cert-file is presented
+// as the client certificate so that nodes requiring client certificates accept each other.
+func (p *ServerProperties) TLSClientConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if p.TLSCertFile != "" && p.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.TLSCertFile, p.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if p.TLSCACertFile != "" {
+		pool, err := loadCertPool(p.TLSCACertFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in " + filename)
+	}
+	return pool, nil
+}
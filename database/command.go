@@ -6,13 +6,84 @@ var cmdTable = make(map[string]*command)
 
 type command struct {
 	executor ExecFunc
+	prepare  PreFunc // 返回命令涉及的 key，用于加锁及生成回滚日志，为 nil 时不需要加锁
 	arity    int
+	flags    int
 }
 
-func RegisterCommand(name string, executor ExecFunc, arity int) {
+// 命令标志
+const (
+	flagWrite    = 1 << iota // 修改数据
+	flagReadOnly             // 只读取数据
+	flagDenyOOM              // 可能增加内存占用，超过 maxmemory 且无法淘汰时拒绝执行
+)
+
+// PreFunc 分析命令参数（不含命令名），返回需要加写锁和读锁的 key
+type PreFunc func(args [][]byte) ([]string, []string)
+
+func RegisterCommand(name string, executor ExecFunc, prepare PreFunc, arity int, flags int) {
 	name = strings.ToLower(name)
 	cmdTable[name] = &command{
 		executor: executor,
+		prepare:  prepare,
 		arity:    arity,
+		flags:    flags,
+	}
+}
+
+// multiDBWriteCommands 是由 StandaloneDatabase 直接处理、不在 cmdTable 中的写命令
+var multiDBWriteCommands = map[string]bool{
+	"flushall": true,
+	"flushdb":  true,
+	"swapdb":   true,
+	"move":     true,
+	"copy":     true,
+	"migrate":  true,
+}
+
+// IsWriteCommand 判断命令是否会修改数据，CLIENT PAUSE WRITE 期间这些命令需要等待
+func IsWriteCommand(cmdName string) bool {
+	cmdName = strings.ToLower(cmdName)
+	if multiDBWriteCommands[cmdName] {
+		return true
+	}
+	cmd, ok := cmdTable[cmdName]
+	return ok && cmd.flags&flagWrite != 0
+}
+
+// GetRelatedKeys 返回命令行涉及的写 key 和读 key，未知命令或不涉及 key 的命令返回 nil
+func GetRelatedKeys(cmdLine [][]byte) ([]string, []string) {
+	cmd, ok := cmdTable[strings.ToLower(string(cmdLine[0]))]
+	if !ok || cmd.prepare == nil || !validateArity(cmd.arity, cmdLine) {
+		return nil, nil
+	}
+	return cmd.prepare(cmdLine[1:])
+}
+
+// readFirstKey 第一个参数为只读的 key
+func readFirstKey(args [][]byte) ([]string, []string) {
+	return nil, []string{string(args[0])}
+}
+
+// writeFirstKey 第一个参数为需要修改的 key
+func writeFirstKey(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, nil
+}
+
+// readAllKeys 所有参数均为只读的 key
+func readAllKeys(args [][]byte) ([]string, []string) {
+	return nil, toKeys(args)
+}
+
+// writeAllKeys 所有参数均为需要修改的 key
+func writeAllKeys(args [][]byte) ([]string, []string) {
+	return toKeys(args), nil
+}
+
+func toKeys(args [][]byte) []string {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
 	}
+	return keys
 }
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"strings"
+)
+
+// lockCrossDB 对位于两个数据库的 key 加写锁，按数据库编号顺序加锁以避免死锁
+// 返回释放锁的函数，调用方需持有 StandaloneDatabase 的读锁
+func lockCrossDB(src *DB, srcKey string, dest *DB, destKey string) func() {
+	if src == dest {
+		src.RWLocks([]string{destKey}, []string{srcKey})
+		return func() {
+			src.RWUnLocks([]string{destKey}, []string{srcKey})
+		}
+	}
+	first, firstKey, second, secondKey := src, srcKey, dest, destKey
+	if first.index > second.index {
+		first, firstKey, second, secondKey = dest, destKey, src, srcKey
+	}
+	first.RWLocks([]string{firstKey}, nil)
+	second.RWLocks([]string{secondKey}, nil)
+	return func() {
+		second.RWUnLocks([]string{secondKey}, nil)
+		first.RWUnLocks([]string{firstKey}, nil)
+	}
+}
+
+// execMove 将 key 移动到另一个数据库，目标数据库已存在该 key 时不移动
+// MOVE key db
+func execMove(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("move")
+	}
+	if c.GetDBIndex() >= len(mdb.dbSet) {
+		return reply.MakeErrReply("ERR DB index is out of range")
+	}
+	destIndex, errReply := parseDBIndex(mdb, args[1])
+	if errReply != nil {
+		return errReply
+	}
+	if destIndex == c.GetDBIndex() {
+		return reply.MakeErrReply("ERR source and destination objects are the same")
+	}
+	key := string(args[0])
+	src, dest := mdb.dbSet[c.GetDBIndex()], mdb.dbSet[destIndex]
+	unlock := lockCrossDB(src, key, dest, key)
+	defer unlock()
+
+	entity, exists := src.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	if dest.PutIfAbsent(key, entity) == 0 {
+		return reply.MakeIntReply(0)
+	}
+	src.Remove(key)
+	src.addAof(utils.ToCmdLine2("MOVE", args...))
+	return reply.MakeIntReply(1)
+}
+
+// execCopy 将 source 的值复制到 destination
+// COPY source destination [DB destination-db] [REPLACE]
+func execCopy(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("copy")
+	}
+	if c.GetDBIndex() >= len(mdb.dbSet) {
+		return reply.MakeErrReply("ERR DB index is out of range")
+	}
+	srcKey, destKey := string(args[0]), string(args[1])
+	destIndex := c.GetDBIndex()
+	replace := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "DB":
+			if i+1 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			index, errReply := parseDBIndex(mdb, args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			destIndex = index
+			i++
+		case "REPLACE":
+			replace = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if destIndex == c.GetDBIndex() && srcKey == destKey {
+		return reply.MakeErrReply("ERR source and destination objects are the same")
+	}
+	src, dest := mdb.dbSet[c.GetDBIndex()], mdb.dbSet[destIndex]
+	unlock := lockCrossDB(src, srcKey, dest, destKey)
+	defer unlock()
+
+	entity, exists := src.GetEntity(srcKey)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	if _, exists := dest.GetEntity(destKey); exists && !replace {
+		return reply.MakeIntReply(0)
+	}
+	copied, err := copyEntity(entity)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	dest.PutEntity(destKey, copied)
+	src.addAof(utils.ToCmdLine2("COPY", args...))
+	return reply.MakeIntReply(1)
+}
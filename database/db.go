@@ -4,14 +4,30 @@ import (
 	"go-redis/datastruct/dict"
 	"go-redis/interface/database"
 	"go-redis/interface/resp"
+	"go-redis/lib/lock"
+	"go-redis/lib/metrics"
+	"go-redis/lib/utils"
 	"go-redis/resp/reply"
 	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	dataDictSize = 1024
+	lockerSize   = 1024
 )
 
 type DB struct {
 	index  int
 	data   dict.Dict
+	locker *lock.Locks // 保证多 key 命令及跨节点事务涉及的 key 不被并发修改
 	addAof func(line CmdLine)
+	// freeMemoryIfNeeded 在内存超过 maxmemory 时淘汰 key，返回 false 表示仍超过上限
+	freeMemoryIfNeeded func() bool
+	usedMemory         int64        // 本数据库中所有 key 估计占用的内存，原子访问
+	stats              *serverStats // 与同一 StandaloneDatabase 中的其他数据库共享
+	slots              *slotIndex   // 集群模式下按哈希槽索引 key，单机模式下为 nil
 }
 
 type ExecFunc func(db *DB, args [][]byte) resp.Reply
@@ -19,13 +35,28 @@ type CmdLine = [][]byte
 
 func MakeDB() *DB {
 	db := &DB{
-		data:   dict.MakeSyncDict(),
+		data:   dict.MakeConcurrent(dataDictSize),
+		locker: lock.Make(lockerSize),
 		addAof: func(line CmdLine) {},
+		freeMemoryIfNeeded: func() bool {
+			return true
+		},
+		stats: makeServerStats(),
 	}
 	return db
 }
 
+// Exec 执行命令，并记录命令的调用次数与耗时
 func (db *DB) Exec(c resp.Connection, cmdLine CmdLine) resp.Reply {
+	start := time.Now()
+	result := db.exec(c, cmdLine)
+	label := commandLabel(strings.ToLower(string(cmdLine[0])))
+	commandCalls.WithLabelValues(label).Inc()
+	commandDuration.WithLabelValues(label).Observe(metrics.Since(start))
+	return result
+}
+
+func (db *DB) exec(c resp.Connection, cmdLine CmdLine) resp.Reply {
 	cmdName := strings.ToLower(string(cmdLine[0]))
 	cmd, ok := cmdTable[cmdName]
 	if !ok {
@@ -34,9 +65,60 @@ func (db *DB) Exec(c resp.Connection, cmdLine CmdLine) resp.Reply {
 	if !validateArity(cmd.arity, cmdLine) {
 		return reply.MakeArgNumErrReply(cmdName)
 	}
-	fun := cmd.executor
 	//SET k v -> k v
-	return fun(db, cmdLine[1:])
+	args := cmdLine[1:]
+	// 在获取 key 的锁之前淘汰，淘汰时需要锁定被淘汰的 key
+	if cmd.flags&flagDenyOOM != 0 && !db.freeMemoryIfNeeded() {
+		return reply.MakeErrReply(errOOM)
+	}
+	if cmd.prepare != nil {
+		writeKeys, readKeys := cmd.prepare(args)
+		db.RWLocks(writeKeys, readKeys)
+		defer db.RWUnLocks(writeKeys, readKeys)
+	}
+	return cmd.executor(db, args)
+}
+
+// execWithLock 执行命令但不加锁，调用方需要已经持有命令涉及的 key 的锁
+func (db *DB) execWithLock(cmdLine CmdLine) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok {
+		return reply.MakeErrReply("ERR unknown command " + cmdName)
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	return cmd.executor(db, cmdLine[1:])
+}
+
+// GetUndoLogs 返回将命令修改的 key 恢复到当前状态所需的命令
+func (db *DB) GetUndoLogs(cmdLine CmdLine) []CmdLine {
+	writeKeys, _ := GetRelatedKeys(cmdLine)
+	undoLogs := make([]CmdLine, 0, len(writeKeys))
+	for _, key := range writeKeys {
+		entity, exists := db.peekEntity(key)
+		if !exists {
+			undoLogs = append(undoLogs, utils.ToCmdLine("DEL", key))
+			continue
+		}
+		payload, err := serializeEntity(entity)
+		if err != nil {
+			continue
+		}
+		undoLogs = append(undoLogs, utils.ToCmdLine2("RESTORE", []byte(key), []byte("0"), payload, []byte("REPLACE")))
+	}
+	return undoLogs
+}
+
+// RWLocks 获取 writeKeys 的写锁和 readKeys 的读锁
+func (db *DB) RWLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWLocks(writeKeys, readKeys)
+}
+
+// RWUnLocks 释放 writeKeys 的写锁和 readKeys 的读锁
+func (db *DB) RWUnLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWUnLocks(writeKeys, readKeys)
 }
 
 func validateArity(arity int, cmdArgs [][]byte) bool {
@@ -50,7 +132,18 @@ func validateArity(arity int, cmdArgs [][]byte) bool {
 	}
 }
 
+// GetEntity 返回 key 对应的数据，并更新其访问时间和访问频率以及 keyspace 命中统计
 func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
+	entity, ok := db.peekEntity(key)
+	if ok {
+		touchEntity(entity)
+	}
+	db.stats.recordLookup(ok)
+	return entity, ok
+}
+
+// peekEntity 返回 key 对应的数据，不影响淘汰策略使用的访问信息
+func (db *DB) peekEntity(key string) (*database.DataEntity, bool) {
 	raw, ok := db.data.Get(key)
 	if !ok {
 		return nil, false
@@ -59,30 +152,98 @@ func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
 	return entity, true
 }
 
+// 以下写入方法需要调用方持有 key 的写锁，以保证内存统计准确
+
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
-	return db.data.Put(key, entity)
+	initEntity(key, entity)
+	old, _ := db.peekEntity(key)
+	result := db.data.Put(key, entity)
+	db.addUsedMemory(entity.Size - entitySize(old))
+	if result > 0 && db.slots != nil {
+		db.slots.add(key)
+	}
+	return result
 }
 
 func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
-	return db.data.PutIfExists(key, entity)
+	old, exists := db.peekEntity(key)
+	if !exists {
+		return 0
+	}
+	initEntity(key, entity)
+	result := db.data.PutIfExists(key, entity)
+	if result > 0 {
+		db.addUsedMemory(entity.Size - old.Size)
+	}
+	return result
 }
 
 func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
-	return db.data.PutIfAbsent(key, entity)
+	if _, exists := db.peekEntity(key); exists {
+		return 0
+	}
+	initEntity(key, entity)
+	result := db.data.PutIfAbsent(key, entity)
+	if result > 0 {
+		db.addUsedMemory(entity.Size)
+		if db.slots != nil {
+			db.slots.add(key)
+		}
+	}
+	return result
 }
 
 func (db *DB) Remove(key string) int {
-	return db.data.Remove(key)
+	old, exists := db.peekEntity(key)
+	if !exists {
+		return 0
+	}
+	result := db.data.Remove(key)
+	if result > 0 {
+		db.addUsedMemory(-old.Size)
+		if db.slots != nil {
+			db.slots.remove(key)
+		}
+	}
+	return result
 }
 
 func (db *DB) Removes(keys ...string) (deleted int) {
 	deleted = 0
 	for _, key := range keys {
-		deleted += db.data.Remove(key)
+		deleted += db.Remove(key)
 	}
 	return deleted
 }
 
 func (db *DB) Flush() {
 	db.data.Clear()
+	atomic.StoreInt64(&db.usedMemory, 0)
+	if db.slots != nil {
+		db.slots.clear()
+	}
+}
+
+// FlushAsync 用空字典替换当前数据，旧数据在后台协程中释放
+// 调用方需持有 StandaloneDatabase 的写锁，保证没有其他命令正在访问 db.data
+func (db *DB) FlushAsync() {
+	old := db.data
+	db.data = dict.MakeConcurrent(dataDictSize)
+	atomic.StoreInt64(&db.usedMemory, 0)
+	if db.slots != nil {
+		db.slots.clear()
+	}
+	go old.Clear()
+}
+
+// lazyFree 在后台协程中释放已从数据库中移除的数据
+// 字符串由 GC 回收，集合类型的数据需要逐个删除元素，放在后台执行以免阻塞命令
+func lazyFree(entities []*database.DataEntity) {
+	go func() {
+		for _, entity := range entities {
+			if container, ok := entity.Data.(interface{ Clear() }); ok {
+				container.Clear()
+			}
+		}
+	}()
 }
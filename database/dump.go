@@ -0,0 +1,131 @@
+package database
+
+import (
+	"encoding/binary"
+	"errors"
+	"go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"hash/crc64"
+	"strconv"
+	"strings"
+)
+
+// DUMP 序列化格式：| 类型(1字节) | 数据 | 版本(2字节, 小端) | CRC64(8字节, 小端) |
+// CRC64 覆盖前面的所有字节，用于在 RESTORE 时校验数据完整性
+const (
+	dumpVersion uint16 = 1
+
+	dumpTypeString byte = 0
+)
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+var errBadPayload = errors.New("ERR DUMP payload version or checksum are wrong")
+
+// serializeEntity 将数据实体序列化为 DUMP 格式
+func serializeEntity(entity *database.DataEntity) ([]byte, error) {
+	var buf []byte
+	switch val := entity.Data.(type) {
+	case []byte:
+		buf = make([]byte, 0, len(val)+11)
+		buf = append(buf, dumpTypeString)
+		buf = append(buf, val...)
+	default:
+		return nil, errors.New("ERR unsupported data type")
+	}
+	buf = binary.LittleEndian.AppendUint16(buf, dumpVersion)
+	buf = binary.LittleEndian.AppendUint64(buf, crc64.Checksum(buf, crc64Table))
+	return buf, nil
+}
+
+// copyEntity 深拷贝数据实体
+func copyEntity(entity *database.DataEntity) (*database.DataEntity, error) {
+	payload, err := serializeEntity(entity)
+	if err != nil {
+		return nil, err
+	}
+	return deserializeEntity(payload)
+}
+
+// deserializeEntity 将 DUMP 格式的数据还原为数据实体
+func deserializeEntity(payload []byte) (*database.DataEntity, error) {
+	if len(payload) < 11 {
+		return nil, errBadPayload
+	}
+	body := payload[:len(payload)-8]
+	checksum := binary.LittleEndian.Uint64(payload[len(payload)-8:])
+	if crc64.Checksum(body, crc64Table) != checksum {
+		return nil, errBadPayload
+	}
+	version := binary.LittleEndian.Uint16(body[len(body)-2:])
+	if version != dumpVersion {
+		return nil, errBadPayload
+	}
+	data := body[1 : len(body)-2]
+	switch body[0] {
+	case dumpTypeString:
+		value := make([]byte, len(data))
+		copy(value, data)
+		return &database.DataEntity{Data: value}, nil
+	}
+	return nil, errBadPayload
+}
+
+// DUMP
+func execDUMP(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	payload, err := serializeEntity(entity)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeBulkReply(payload)
+}
+
+// RESTORE key ttl serialized-value [REPLACE]
+func execRESTORE(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return reply.MakeErrReply("ERR Invalid TTL value, must be >= 0")
+	}
+	if ttl > 0 {
+		// 当前版本尚不支持过期时间
+		return reply.MakeErrReply("ERR key expiration is not supported")
+	}
+	replace := false
+	for _, arg := range args[3:] {
+		if strings.ToUpper(string(arg)) == "REPLACE" {
+			replace = true
+		} else {
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	entity, err := deserializeEntity(args[2])
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	if replace {
+		db.PutEntity(key, entity)
+	} else if db.PutIfAbsent(key, entity) == 0 {
+		return reply.MakeErrReply("BUSYKEY Target key name already exists.")
+	}
+	db.addAof(utils.ToCmdLine2("RESTORE", args...))
+	return reply.MakeOkReply()
+}
+
+func init() {
+	RegisterCommand("DUMP", execDUMP, readFirstKey, 2, flagReadOnly)
+	RegisterCommand("RESTORE", execRESTORE, writeFirstKey, -4, flagWrite|flagDenyOOM)
+	// RESTORE-ASKING 由 MIGRATE 发往目标节点，集群模式下即使槽尚未归属目标节点也在本地执行
+	RegisterCommand("RESTORE-ASKING", execRESTORE, writeFirstKey, -4, flagWrite|flagDenyOOM)
+}
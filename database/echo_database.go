@@ -16,12 +16,9 @@ func (e *EchoDatabase) Exec(client resp.Connection, args [][]byte) resp.Reply {
 	return reply.MakeMultiBulkReply(args)
 }
 
+// Close EchoDatabase 不持有任何资源，无需清理
 func (e *EchoDatabase) Close() {
-	//TODO implement me
-	panic("implement me")
 }
 
 func (e *EchoDatabase) AfterClientClose(c resp.Connection) {
-	//TODO implement me
-	panic("implement me")
 }
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"go-redis/config"
+	"go-redis/interface/database"
+	"go-redis/lib/latency"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 内存淘汰策略，与 Redis 的 maxmemory-policy 相同
+const (
+	policyNoEviction     = "noeviction"
+	policyAllKeysLRU     = "allkeys-lru"
+	policyAllKeysLFU     = "allkeys-lfu"
+	policyAllKeysRandom  = "allkeys-random"
+	policyVolatileLRU    = "volatile-lru"
+	policyVolatileLFU    = "volatile-lfu"
+	policyVolatileRandom = "volatile-random"
+	policyVolatileTTL    = "volatile-ttl"
+)
+
+const (
+	errOOM = "OOM command not allowed when used memory > 'maxmemory'."
+
+	defaultMaxMemorySamples = 5
+	// evictionPoolSize 与 Redis 相同，候选池保留多轮采样中最适合淘汰的 key
+	evictionPoolSize = 16
+	// maxEvictionRetries 候选池中的 key 均已失效时重新采样的次数
+	maxEvictionRetries = 3
+)
+
+// evictionCandidate 是候选池中的一个 key，score 越大越应该被淘汰
+type evictionCandidate struct {
+	dbIndex int
+	key     string
+	entity  *database.DataEntity
+	score   uint64
+}
+
+// evictor 在内存超过 maxmemory 时按策略淘汰 key
+// 与 Redis 一样使用近似算法：每次从各数据库随机采样 maxmemory-samples 个 key，放入按 score 排序的候选池，淘汰 score 最大的 key
+type evictor struct {
+	maxMemory int64
+	policy    string
+	samples   int
+
+	mu   sync.Mutex
+	pool []*evictionCandidate // 按 score 升序排列
+}
+
+func makeEvictor(props *config.ServerProperties) *evictor {
+	e := &evictor{
+		maxMemory: props.MaxMemory,
+		policy:    strings.ToLower(props.MaxMemoryPolicy),
+		samples:   props.MaxMemorySamples,
+	}
+	switch e.policy {
+	case policyNoEviction, policyAllKeysLRU, policyAllKeysLFU, policyAllKeysRandom,
+		policyVolatileLRU, policyVolatileLFU, policyVolatileRandom, policyVolatileTTL:
+	case "":
+		e.policy = policyNoEviction
+	default:
+		logger.Warn("unknown maxmemory-policy " + props.MaxMemoryPolicy + ", use noeviction")
+		e.policy = policyNoEviction
+	}
+	if e.samples <= 0 {
+		e.samples = defaultMaxMemorySamples
+	}
+	return e
+}
+
+// UsedMemory 返回所有数据库估计占用的内存
+func (mdb *StandaloneDatabase) UsedMemory() int64 {
+	var used int64
+	for _, db := range mdb.dbSet {
+		used += db.UsedMemory()
+	}
+	return used
+}
+
+// freeMemoryIfNeeded 在内存超过 maxmemory 时淘汰 key，直到低于上限或没有可淘汰的 key
+// 调用方需持有 mdb.mu 的读锁，且不能持有任何 key 的锁
+func (mdb *StandaloneDatabase) freeMemoryIfNeeded() bool {
+	e := mdb.evictor
+	if e.maxMemory <= 0 || mdb.UsedMemory() <= e.maxMemory {
+		return true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	start := time.Now()
+	defer func() {
+		latency.AddSampleIfNeeded(latency.EventEvictionCycle, time.Since(start))
+	}()
+	for mdb.UsedMemory() > e.maxMemory {
+		if !mdb.evictOne() {
+			return false
+		}
+	}
+	return true
+}
+
+// evictOne 按策略淘汰一个 key，没有可淘汰的 key 时返回 false
+func (mdb *StandaloneDatabase) evictOne() bool {
+	e := mdb.evictor
+	switch e.policy {
+	case policyAllKeysRandom:
+		return mdb.evictRandom()
+	case policyAllKeysLRU, policyAllKeysLFU:
+		for i := 0; i < maxEvictionRetries; i++ {
+			mdb.populateEvictionPool()
+			if mdb.evictFromPool() {
+				return true
+			}
+		}
+		return false
+	case policyVolatileLRU, policyVolatileLFU, policyVolatileRandom, policyVolatileTTL:
+		// volatile-* 策略只淘汰设置了过期时间的 key，目前不支持过期时间，因此与 noeviction 一致，denyoom 命令返回 OOM 错误
+		return false
+	}
+	return false
+}
+
+// evictRandom 从随机的非空数据库中淘汰一个随机 key
+func (mdb *StandaloneDatabase) evictRandom() bool {
+	start := rand.Intn(len(mdb.dbSet))
+	for i := 0; i < len(mdb.dbSet); i++ {
+		db := mdb.dbSet[(start+i)%len(mdb.dbSet)]
+		for _, key := range db.data.RandomKeys(1) {
+			if entity, ok := db.peekEntity(key); ok && db.evictKey(key, entity) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// evictionScore 计算 key 的淘汰优先级
+func (e *evictor) evictionScore(entity *database.DataEntity) uint64 {
+	if e.policy == policyAllKeysLFU {
+		return math.MaxUint8 - uint64(lfuDecrAndReturn(entity))
+	}
+	return idleTime(entity)
+}
+
+// populateEvictionPool 从每个数据库采样 key 放入候选池，候选池已满时只保留 score 最大的 key
+func (mdb *StandaloneDatabase) populateEvictionPool() {
+	e := mdb.evictor
+	for _, db := range mdb.dbSet {
+		if db.data.Len() == 0 {
+			continue
+		}
+		for _, key := range db.data.RandomDistinctKeys(e.samples) {
+			entity, ok := db.peekEntity(key)
+			if !ok || e.inPool(db.index, key) {
+				continue
+			}
+			e.pool = append(e.pool, &evictionCandidate{
+				dbIndex: db.index,
+				key:     key,
+				entity:  entity,
+				score:   e.evictionScore(entity),
+			})
+		}
+	}
+	sort.Slice(e.pool, func(i, j int) bool {
+		return e.pool[i].score < e.pool[j].score
+	})
+	if len(e.pool) > evictionPoolSize {
+		e.pool = e.pool[len(e.pool)-evictionPoolSize:]
+	}
+}
+
+func (e *evictor) inPool(dbIndex int, key string) bool {
+	for _, candidate := range e.pool {
+		if candidate.dbIndex == dbIndex && candidate.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// evictFromPool 从 score 最大的候选开始淘汰，跳过已被删除或修改的 key
+func (mdb *StandaloneDatabase) evictFromPool() bool {
+	e := mdb.evictor
+	for len(e.pool) > 0 {
+		candidate := e.pool[len(e.pool)-1]
+		e.pool = e.pool[:len(e.pool)-1]
+		if candidate.dbIndex >= len(mdb.dbSet) {
+			continue
+		}
+		db := mdb.dbSet[candidate.dbIndex]
+		// SWAPDB 之后数据库编号对应的数据已变化，通过比较 entity 识别
+		if db.evictKey(candidate.key, candidate.entity) {
+			return true
+		}
+	}
+	return false
+}
+
+// evictKey 在 key 仍指向 entity 时将其删除，key 正被其他命令或事务锁定时跳过
+func (db *DB) evictKey(key string, entity *database.DataEntity) bool {
+	if !db.locker.TryLock(key) {
+		return false
+	}
+	defer db.locker.UnLock(key)
+	if current, ok := db.peekEntity(key); !ok || current != entity {
+		return false
+	}
+	db.Remove(key)
+	db.addAof(utils.ToCmdLine("DEL", key))
+	db.stats.incrEvicted()
+	return true
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"go-redis/config"
+	"go-redis/interface/database"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// setProperties 在测试期间修改配置，结束后恢复
+func setProperties(t *testing.T, modify func(props *config.ServerProperties)) {
+	old := *config.Properties
+	t.Cleanup(func() {
+		*config.Properties = old
+	})
+	modify(config.Properties)
+}
+
+func TestLfuDecrAndReturn(t *testing.T) {
+	tests := []struct {
+		counter   uint32
+		elapsed   uint32 // 距上次递减经过的分钟数
+		decayTime int
+		want      uint8
+	}{
+		{10, 0, 1, 10},
+		{10, 3, 1, 7},
+		{10, 10, 1, 0},
+		{10, 100, 1, 0},
+		{10, 5, 2, 8},
+		{math.MaxUint8, 1, 1, math.MaxUint8 - 1},
+		// lfu-decay-time 小于等于 0 时使用默认值
+		{10, 3, 0, 7},
+	}
+	for _, tt := range tests {
+		setProperties(t, func(props *config.ServerProperties) {
+			props.LfuDecayTime = tt.decayTime
+		})
+		ldt := (lfuTimeInMinutes() - tt.elapsed) & lfuTimeMask
+		entity := &database.DataEntity{LFU: ldt<<8 | tt.counter}
+		if got := lfuDecrAndReturn(entity); got != tt.want {
+			t.Errorf("counter %d after %d minutes with decay time %d = %d, want %d",
+				tt.counter, tt.elapsed, tt.decayTime, got, tt.want)
+		}
+	}
+}
+
+func TestLfuTimeElapsed(t *testing.T) {
+	now := lfuTimeInMinutes()
+	if got := lfuTimeElapsed(now); got != 0 {
+		t.Errorf("lfuTimeElapsed(now) = %d", got)
+	}
+	// 24 位的分钟时钟回绕后仍能得到经过的时间
+	if got := lfuTimeElapsed((now + 10) & lfuTimeMask); got != lfuTimeMask-10 {
+		t.Errorf("lfuTimeElapsed after wrapping = %d, want %d", got, lfuTimeMask-10)
+	}
+}
+
+func TestLfuLogIncr(t *testing.T) {
+	// 不超过初始值的计数总是递增，达到上限后不再递增
+	for _, counter := range []uint8{0, 1, lfuInitVal - 1, lfuInitVal} {
+		if got := lfuLogIncr(counter); got != counter+1 {
+			t.Errorf("lfuLogIncr(%d) = %d", counter, got)
+		}
+	}
+	if got := lfuLogIncr(math.MaxUint8); got != math.MaxUint8 {
+		t.Errorf("lfuLogIncr(255) = %d", got)
+	}
+	// 计数为 200 时递增的概率约为 1/1951
+	increments := 0
+	for i := 0; i < 1000; i++ {
+		if lfuLogIncr(200) > 200 {
+			increments++
+		}
+	}
+	if increments > 20 {
+		t.Errorf("counter 200 incremented %d times in 1000 accesses", increments)
+	}
+}
+
+func TestIdleTime(t *testing.T) {
+	tests := []struct {
+		lru  uint32
+		want uint64
+	}{
+		{lruClock(), 0},
+		{lruClock() - 100, 100},
+		// 时钟回拨时视为刚被访问
+		{lruClock() + 100, 0},
+	}
+	for _, tt := range tests {
+		entity := &database.DataEntity{LRU: tt.lru}
+		if got := idleTime(entity); got != tt.want {
+			t.Errorf("idleTime(%d) = %d, want %d", tt.lru, got, tt.want)
+		}
+	}
+}
+
+// TestEviction 内存超过 maxmemory 时按策略淘汰 key
+// 采样数不小于 key 的数量时每轮都能看到全部 key，LRU、LFU 只会淘汰冷数据
+func TestEviction(t *testing.T) {
+	const keysPerKind = 100
+	tests := []struct {
+		policy      string
+		wantFreed   bool
+		onlyColdKey bool
+	}{
+		{policyAllKeysLRU, true, true},
+		{policyAllKeysLFU, true, true},
+		{policyAllKeysRandom, true, false},
+		{policyNoEviction, false, false},
+		// 没有设置了过期时间的 key，volatile-* 策略不淘汰任何 key
+		{policyVolatileLRU, false, false},
+		{policyVolatileLFU, false, false},
+		{policyVolatileRandom, false, false},
+		{policyVolatileTTL, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			setProperties(t, func(props *config.ServerProperties) {
+				props.MaxMemoryPolicy = tt.policy
+				props.MaxMemorySamples = 2 * keysPerKind
+				props.AppendOnly = false
+			})
+			mdb := NewStandaloneDatabase()
+			t.Cleanup(mdb.Close)
+			db := mdb.dbSet[0]
+			for i := 0; i < keysPerKind; i++ {
+				cold := &database.DataEntity{Data: []byte("value")}
+				db.PutEntity("cold:"+strconv.Itoa(i), cold)
+				atomic.StoreUint32(&cold.LRU, lruClock()-1000)
+				atomic.StoreUint32(&cold.LFU, lfuTimeInMinutes()<<8)
+				hot := &database.DataEntity{Data: []byte("value")}
+				db.PutEntity("hot:"+strconv.Itoa(i), hot)
+				atomic.StoreUint32(&hot.LFU, lfuTimeInMinutes()<<8|200)
+			}
+			used := mdb.UsedMemory()
+			mdb.evictor.maxMemory = used * 3 / 4
+
+			if got := mdb.freeMemoryIfNeeded(); got != tt.wantFreed {
+				t.Fatalf("freeMemoryIfNeeded = %v, want %v", got, tt.wantFreed)
+			}
+			remaining := db.data.Len()
+			evicted := 2*keysPerKind - remaining
+			if int64(evicted) != mdb.stats.evicted() {
+				t.Errorf("evicted %d keys, stats report %d", evicted, mdb.stats.evicted())
+			}
+			if !tt.wantFreed {
+				if evicted != 0 {
+					t.Errorf("%s evicted %d keys", tt.policy, evicted)
+				}
+				// 无法释放内存时 denyoom 命令返回 OOM 错误
+				ret := mdb.Exec(&connection.FakeConn{}, utils.ToCmdLine("SET", "k", "v"))
+				if errReply, ok := ret.(reply.ErrorReply); !ok || errReply.Error() != errOOM {
+					t.Errorf("SET returned %q, want the OOM error", ret.ToBytes())
+				}
+				return
+			}
+			if mdb.UsedMemory() > mdb.evictor.maxMemory {
+				t.Errorf("used memory %d > maxmemory %d", mdb.UsedMemory(), mdb.evictor.maxMemory)
+			}
+			if evicted == 0 {
+				t.Fatalf("nothing is evicted")
+			}
+			if tt.onlyColdKey {
+				hot := 0
+				for _, key := range db.data.Keys() {
+					if strings.HasPrefix(key, "hot:") {
+						hot++
+					}
+				}
+				if hot != keysPerKind {
+					t.Errorf("%d hot keys are evicted", keysPerKind-hot)
+				}
+			}
+		})
+	}
+}
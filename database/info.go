@@ -0,0 +1,186 @@
+package database
+
+import (
+	"fmt"
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisVersion 为 INFO 与 HELLO 中报告的版本号，客户端据此判断支持的命令
+const RedisVersion = "6.2.0"
+
+// infoSection 为 INFO 的一个部分，writer 将该部分的字段写入 builder
+type infoSection struct {
+	name   string
+	writer func(mdb *StandaloneDatabase, builder *infoBuilder)
+}
+
+// infoSections 按 Redis 的顺序排列，不带参数的 INFO 输出全部部分
+var infoSections = []infoSection{
+	{name: "server", writer: writeServerInfo},
+	{name: "clients", writer: writeClientsInfo},
+	{name: "memory", writer: writeMemoryInfo},
+	{name: "persistence", writer: writePersistenceInfo},
+	{name: "stats", writer: writeStatsInfo},
+	{name: "replication", writer: writeReplicationInfo},
+	{name: "cluster", writer: writeClusterInfo},
+	{name: "keyspace", writer: writeKeyspaceInfo},
+}
+
+// infoBuilder 生成 name:value 格式的 INFO 文本
+type infoBuilder struct {
+	strings.Builder
+}
+
+func (b *infoBuilder) field(name string, value interface{}) {
+	b.WriteString(fmt.Sprintf("%s:%v\r\n", name, value))
+}
+
+// execInfo 执行 INFO [section [section ...]]
+// 不带参数或参数为 default、all、everything 时返回所有部分，未知的部分被忽略
+func execInfo(mdb *StandaloneDatabase, args [][]byte) resp.Reply {
+	selected := make(map[string]bool)
+	all := len(args) == 0
+	for _, arg := range args {
+		section := strings.ToLower(string(arg))
+		switch section {
+		case "default", "all", "everything":
+			all = true
+		default:
+			selected[section] = true
+		}
+	}
+	builder := &infoBuilder{}
+	for _, section := range infoSections {
+		if !all && !selected[section.name] {
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\r\n")
+		}
+		builder.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		section.writer(mdb, builder)
+	}
+	return reply.MakeVerbatimReply("txt", []byte(builder.String()))
+}
+
+func writeServerInfo(mdb *StandaloneDatabase, b *infoBuilder) {
+	uptime := time.Since(mdb.stats.startTime)
+	b.field("redis_version", RedisVersion)
+	// 集群模式下由 cluster 包替换为 cluster
+	b.field("redis_mode", "standalone")
+	b.field("os", runtime.GOOS)
+	b.field("arch_bits", strconv.Itoa(strconv.IntSize))
+	b.field("go_version", runtime.Version())
+	b.field("process_id", os.Getpid())
+	b.field("run_id", mdb.stats.runID)
+	b.field("tcp_port", config.Properties.Port)
+	b.field("uptime_in_seconds", int64(uptime/time.Second))
+	b.field("uptime_in_days", int64(uptime/(24*time.Hour)))
+}
+
+func writeClientsInfo(mdb *StandaloneDatabase, b *infoBuilder) {
+	connected := 0
+	if mdb.clients != nil {
+		connected = mdb.clients.ConnectedClients()
+	}
+	b.field("connected_clients", connected)
+	b.field("maxclients", config.Properties.MaxClients)
+	b.field("blocked_clients", 0)
+}
+
+func writeMemoryInfo(mdb *StandaloneDatabase, b *infoBuilder) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	peak := mdb.stats.peak(stats.HeapAlloc)
+	// used_memory 为 Go 堆占用，used_memory_dataset 为所有 key 的估计占用，maxmemory 以后者为准
+	b.field("used_memory", stats.HeapAlloc)
+	b.field("used_memory_human", bytesToHuman(stats.HeapAlloc))
+	b.field("used_memory_rss", stats.Sys)
+	b.field("used_memory_rss_human", bytesToHuman(stats.Sys))
+	b.field("used_memory_peak", peak)
+	b.field("used_memory_peak_human", bytesToHuman(peak))
+	b.field("used_memory_dataset", mdb.UsedMemory())
+	b.field("maxmemory", mdb.evictor.maxMemory)
+	b.field("maxmemory_human", bytesToHuman(uint64(mdb.evictor.maxMemory)))
+	b.field("maxmemory_policy", mdb.evictor.policy)
+	b.field("mem_allocator", "go-"+runtime.Version())
+	b.field("gc_count", stats.NumGC)
+}
+
+func writePersistenceInfo(mdb *StandaloneDatabase, b *infoBuilder) {
+	b.field("loading", 0)
+	if mdb.aofHandler == nil {
+		b.field("aof_enabled", 0)
+		b.field("aof_rewrite_in_progress", 0)
+		b.field("aof_last_write_status", "ok")
+		return
+	}
+	status := "ok"
+	if !mdb.aofHandler.LastWriteOK() {
+		status = "err"
+	}
+	b.field("aof_enabled", 1)
+	b.field("aof_rewrite_in_progress", 0)
+	b.field("aof_last_write_status", status)
+	b.field("aof_current_size", mdb.aofHandler.CurrentSize())
+	b.field("aof_buffer_length", mdb.aofHandler.BufferLength())
+}
+
+func writeStatsInfo(mdb *StandaloneDatabase, b *infoBuilder) {
+	var connections int64
+	if mdb.clients != nil {
+		connections = mdb.clients.TotalConnectionsReceived()
+	}
+	s := mdb.stats
+	b.field("total_connections_received", connections)
+	b.field("total_commands_processed", s.totalCommands())
+	b.field("instantaneous_ops_per_sec", s.opsPerSec())
+	b.field("expired_keys", 0)
+	b.field("evicted_keys", s.evicted())
+	hits, misses := s.lookups()
+	b.field("keyspace_hits", hits)
+	b.field("keyspace_misses", misses)
+}
+
+func writeReplicationInfo(mdb *StandaloneDatabase, b *infoBuilder) {
+	b.field("role", "master")
+	b.field("connected_slaves", 0)
+}
+
+func writeClusterInfo(mdb *StandaloneDatabase, b *infoBuilder) {
+	// 集群模式下由 cluster 包替换为 1
+	b.field("cluster_enabled", 0)
+}
+
+func writeKeyspaceInfo(mdb *StandaloneDatabase, b *infoBuilder) {
+	for i, db := range mdb.dbSet {
+		keys := db.data.Len()
+		if keys == 0 {
+			continue
+		}
+		// 目前不支持过期时间，expires 与 avg_ttl 始终为 0
+		b.WriteString(fmt.Sprintf("db%d:keys=%d,expires=0,avg_ttl=0\r\n", i, keys))
+	}
+}
+
+// bytesToHuman 与 Redis 相同，将字节数格式化为 1.50M 的形式
+func bytesToHuman(n uint64) string {
+	const units = "KMGTPE"
+	value := float64(n)
+	if value < 1024 {
+		return strconv.FormatUint(n, 10) + "B"
+	}
+	i := -1
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64) + string(units[i])
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"go-redis/interface/database"
 	"go-redis/interface/resp"
 	"go-redis/lib/utils"
 	"go-redis/lib/wildcard"
@@ -21,6 +22,33 @@ func execDEL(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeIntReply(int64(deleted))
 }
 
+// UNLINK 与 DEL 相同，但 value 在后台协程中释放
+func execUNLINK(db *DB, args [][]byte) resp.Reply {
+	removed := make([]*database.DataEntity, 0, len(args))
+	for _, arg := range args {
+		entity, exists := db.GetEntity(string(arg))
+		if exists && db.Remove(string(arg)) > 0 {
+			removed = append(removed, entity)
+		}
+	}
+	if len(removed) > 0 {
+		lazyFree(removed)
+		db.addAof(utils.ToCmdLine2("UNLINK", args...))
+	}
+	return reply.MakeIntReply(int64(len(removed)))
+}
+
+// TOUCH
+func execTOUCH(db *DB, args [][]byte) resp.Reply {
+	result := int64(0)
+	for _, arg := range args {
+		if _, exists := db.GetEntity(string(arg)); exists {
+			result++
+		}
+	}
+	return reply.MakeIntReply(result)
+}
+
 // EXISTS
 func execEXISTS(db *DB, args [][]byte) resp.Reply {
 	result := int64(0)
@@ -47,11 +75,18 @@ func execKEYS(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeMultiBulkReply(result)
 }
 
-// FLUSHDB
-func execFLUSHDB(db *DB, args [][]byte) resp.Reply {
-	db.Flush()
-	db.addAof(utils.ToCmdLine2("FLUSHDB", args...))
-	return reply.MakeOkReply()
+// DBSIZE
+func execDBSIZE(db *DB, args [][]byte) resp.Reply {
+	return reply.MakeIntReply(int64(db.data.Len()))
+}
+
+// RANDOMKEY
+func execRANDOMKEY(db *DB, args [][]byte) resp.Reply {
+	keys := db.data.RandomKeys(1)
+	if len(keys) == 0 {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeBulkReply([]byte(keys[0]))
 }
 
 // TYPE
@@ -61,11 +96,20 @@ func execTYPE(db *DB, args [][]byte) resp.Reply {
 	if !exists {
 		return reply.MakeStatusReply("none")
 	}
+	typeName := typeOf(entity)
+	if typeName == "" {
+		return &reply.UnknownErrReply{}
+	}
+	return reply.MakeStatusReply(typeName)
+}
+
+// typeOf 返回数据的类型名，未知类型返回空字符串
+func typeOf(entity *database.DataEntity) string {
 	switch entity.Data.(type) {
 	case []byte:
-		return reply.MakeStatusReply("string")
+		return "string"
 	}
-	return &reply.UnknownErrReply{}
+	return ""
 }
 
 // RENAME
@@ -95,7 +139,7 @@ func execRENAMENX(db *DB, args [][]byte) resp.Reply {
 
 	entity, exists := db.GetEntity(src)
 	if !exists {
-		reply.MakeErrReply("no such key")
+		return reply.MakeErrReply("no such key")
 	}
 	db.PutEntity(dest, entity)
 	db.Remove(src)
@@ -103,12 +147,59 @@ func execRENAMENX(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeIntReply(1)
 }
 
+func prepareRename(args [][]byte) ([]string, []string) {
+	return []string{string(args[0]), string(args[1])}, nil
+}
+
+// 以下命令供集群在源 key 与目标 key 位于不同节点时以 TCC 方式完成 RENAME/RENAMENX：
+// 源节点执行 RenameFrom 删除源 key，目标节点执行 RenameTo/RenameNxTo 写入序列化后的值
+
+// RenameFrom key
+func execRenameFrom(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	if db.Remove(key) == 0 {
+		return reply.MakeErrReply("no such key")
+	}
+	db.addAof(utils.ToCmdLine2("DEL", args...))
+	return reply.MakeOkReply()
+}
+
+// RenameTo key serialized-value
+func execRenameTo(db *DB, args [][]byte) resp.Reply {
+	entity, err := deserializeEntity(args[1])
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	db.PutEntity(string(args[0]), entity)
+	db.addAof(utils.ToCmdLine2("RESTORE", args[0], []byte("0"), args[1], []byte("REPLACE")))
+	return reply.MakeOkReply()
+}
+
+// RenameNxTo key serialized-value
+func execRenameNxTo(db *DB, args [][]byte) resp.Reply {
+	entity, err := deserializeEntity(args[1])
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	if db.PutIfAbsent(string(args[0]), entity) == 0 {
+		return reply.MakeIntReply(0)
+	}
+	db.addAof(utils.ToCmdLine2("RESTORE", args[0], []byte("0"), args[1]))
+	return reply.MakeIntReply(1)
+}
+
 func init() {
-	RegisterCommand("DEL", execDEL, -2)
-	RegisterCommand("EXISTS", execEXISTS, -2)
-	RegisterCommand("KEYS", execKEYS, 2)
-	RegisterCommand("FLUSHDB", execFLUSHDB, -1)
-	RegisterCommand("TYPE", execTYPE, 2)
-	RegisterCommand("RENAME", execRENAME, 3)
-	RegisterCommand("RENAMENX", execRENAMENX, 3)
+	RegisterCommand("DEL", execDEL, writeAllKeys, -2, flagWrite)
+	RegisterCommand("UNLINK", execUNLINK, writeAllKeys, -2, flagWrite)
+	RegisterCommand("EXISTS", execEXISTS, readAllKeys, -2, flagReadOnly)
+	RegisterCommand("TOUCH", execTOUCH, readAllKeys, -2, flagReadOnly)
+	RegisterCommand("KEYS", execKEYS, nil, 2, flagReadOnly)
+	RegisterCommand("DBSIZE", execDBSIZE, nil, 1, flagReadOnly)
+	RegisterCommand("RANDOMKEY", execRANDOMKEY, nil, 1, flagReadOnly)
+	RegisterCommand("TYPE", execTYPE, readFirstKey, 2, flagReadOnly)
+	RegisterCommand("RENAME", execRENAME, prepareRename, 3, flagWrite)
+	RegisterCommand("RENAMENX", execRENAMENX, prepareRename, 3, flagWrite)
+	RegisterCommand("RenameFrom", execRenameFrom, writeFirstKey, 2, flagWrite)
+	RegisterCommand("RenameTo", execRenameTo, writeFirstKey, 3, flagWrite|flagDenyOOM)
+	RegisterCommand("RenameNxTo", execRenameNxTo, writeFirstKey, 3, flagWrite|flagDenyOOM)
 }
@@ -0,0 +1,276 @@
+package database
+
+import (
+	"fmt"
+	"go-redis/config"
+	"go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"math"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 内存统计只估算 key 与 value 占用的字节数，不包含 Go 运行时与连接等开销
+
+// entryOverhead 每个 key 的固定开销估计值：字典中的 map 槽位、DataEntity 结构体及 key 的字符串头
+const entryOverhead = 64
+
+// sizeOfValue 估算 value 占用的内存
+func sizeOfValue(data interface{}) int64 {
+	switch val := data.(type) {
+	case []byte:
+		return int64(cap(val)) + 24 // 加上切片头
+	}
+	return 0
+}
+
+// entitySize 返回已存储的数据占用的内存，entity 为 nil 时返回 0
+func entitySize(entity *database.DataEntity) int64 {
+	if entity == nil {
+		return 0
+	}
+	return entity.Size
+}
+
+func (db *DB) addUsedMemory(delta int64) {
+	atomic.AddInt64(&db.usedMemory, delta)
+}
+
+// UsedMemory 返回本数据库估计占用的内存
+func (db *DB) UsedMemory() int64 {
+	return atomic.LoadInt64(&db.usedMemory)
+}
+
+// initEntity 在数据写入时计算其大小并初始化访问信息
+func initEntity(key string, entity *database.DataEntity) {
+	entity.Size = int64(len(key)) + entryOverhead + sizeOfValue(entity.Data)
+	atomic.StoreUint32(&entity.LRU, lruClock())
+	atomic.StoreUint32(&entity.LFU, lfuTimeInMinutes()<<8|lfuInitVal)
+}
+
+// touchEntity 在数据被访问时更新 LRU 时钟与 LFU 计数
+func touchEntity(entity *database.DataEntity) {
+	atomic.StoreUint32(&entity.LRU, lruClock())
+	counter := lfuDecrAndReturn(entity)
+	counter = lfuLogIncr(counter)
+	atomic.StoreUint32(&entity.LFU, lfuTimeInMinutes()<<8|uint32(counter))
+}
+
+// lruClock 返回以秒为单位的 LRU 时钟
+func lruClock() uint32 {
+	return uint32(time.Now().Unix())
+}
+
+// idleTime 返回数据自上次访问以来经过的秒数
+func idleTime(entity *database.DataEntity) uint64 {
+	now, last := lruClock(), atomic.LoadUint32(&entity.LRU)
+	if now < last {
+		return 0
+	}
+	return uint64(now - last)
+}
+
+// 与 Redis 相同的对数计数器：计数越大，递增的概率越小；每经过 lfu-decay-time 分钟未被访问计数减一
+const (
+	lfuInitVal       = 5
+	lfuTimeMask      = 1<<24 - 1
+	defaultLogFactor = 10
+	defaultDecayTime = 1
+)
+
+// lfuTimeInMinutes 返回 24 位的分钟时钟
+func lfuTimeInMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & lfuTimeMask
+}
+
+// lfuTimeElapsed 返回距 ldt 经过的分钟数，处理时钟回绕
+func lfuTimeElapsed(ldt uint32) uint32 {
+	now := lfuTimeInMinutes()
+	if now >= ldt {
+		return now - ldt
+	}
+	return lfuTimeMask - ldt + now
+}
+
+// lfuDecrAndReturn 返回按照经过的时间衰减后的访问计数，不修改数据
+func lfuDecrAndReturn(entity *database.DataEntity) uint8 {
+	lfu := atomic.LoadUint32(&entity.LFU)
+	ldt, counter := lfu>>8, lfu&255
+	decayTime := config.Properties.LfuDecayTime
+	if decayTime <= 0 {
+		decayTime = defaultDecayTime
+	}
+	periods := lfuTimeElapsed(ldt) / uint32(decayTime)
+	if periods >= counter {
+		return 0
+	}
+	return uint8(counter - periods)
+}
+
+// lfuLogIncr 以对数概率递增访问计数
+func lfuLogIncr(counter uint8) uint8 {
+	if counter == math.MaxUint8 {
+		return counter
+	}
+	logFactor := config.Properties.LfuLogFactor
+	if logFactor <= 0 {
+		logFactor = defaultLogFactor
+	}
+	baseVal := float64(counter) - lfuInitVal
+	if baseVal < 0 {
+		baseVal = 0
+	}
+	if rand.Float64() < 1.0/(baseVal*float64(logFactor)+1) {
+		counter++
+	}
+	return counter
+}
+
+var memoryHelp = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return memory problems reports.",
+	"STATS",
+	"    Return information about the memory usage of the server.",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return memory in bytes used by <key> and its value. Nested values are",
+	"    sampled up to <count> times (default: 5, 0 means sample all).",
+	"HELP",
+	"    Print this help.",
+}
+
+// execMemory 处理 MEMORY 命令
+// MEMORY USAGE key [SAMPLES count] | MEMORY STATS | MEMORY DOCTOR | MEMORY HELP
+func execMemory(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("memory")
+	}
+	subCmd := strings.ToUpper(string(args[0]))
+	switch {
+	case subCmd == "USAGE" && len(args) >= 2:
+		return execMemoryUsage(mdb, c, args[1:])
+	case subCmd == "STATS" && len(args) == 1:
+		return execMemoryStats(mdb)
+	case subCmd == "DOCTOR" && len(args) == 1:
+		return reply.MakeBulkReply([]byte(memoryDoctor(mdb)))
+	case subCmd == "HELP" && len(args) == 1:
+		return reply.MakeHelpReply(memoryHelp)
+	}
+	return reply.MakeErrReply("ERR unknown subcommand or wrong number of arguments for '" + string(args[0]) + "'. Try MEMORY HELP.")
+}
+
+// execMemoryUsage 返回 key 估计占用的内存，目前只有字符串类型，SAMPLES 参数只做校验
+func execMemoryUsage(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) == 3 && strings.ToUpper(string(args[1])) == "SAMPLES" {
+		if samples, err := strconv.Atoi(string(args[2])); err != nil || samples < 0 {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+	} else if len(args) != 1 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if c.GetDBIndex() >= len(mdb.dbSet) {
+		return reply.MakeErrReply("ERR DB index is out of range")
+	}
+	db := mdb.dbSet[c.GetDBIndex()]
+	key := string(args[0])
+	db.RWLocks(nil, []string{key})
+	defer db.RWUnLocks(nil, []string{key})
+	entity, exists := db.peekEntity(key)
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeIntReply(entity.Size)
+}
+
+// execMemoryStats 返回内存统计信息，RESP2 下为名称、值交替的数组，RESP3 下为 map
+func execMemoryStats(mdb *StandaloneDatabase) resp.Reply {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	dataset := mdb.UsedMemory()
+	keys := 0
+	result := reply.MakeMapReply()
+	add := func(name string, value resp.Reply) {
+		result.AddString(name, value)
+	}
+	add("total.allocated", reply.MakeIntReply(int64(stats.HeapAlloc)))
+	add("heap.sys", reply.MakeIntReply(int64(stats.HeapSys)))
+	add("gc.count", reply.MakeIntReply(int64(stats.NumGC)))
+	for _, db := range mdb.dbSet {
+		dbKeys := db.data.Len()
+		if dbKeys == 0 {
+			continue
+		}
+		keys += dbKeys
+		add("db."+strconv.Itoa(db.index), reply.MakeMapReply().
+			AddString("keys", reply.MakeIntReply(int64(dbKeys))).
+			AddString("dataset.bytes", reply.MakeIntReply(db.UsedMemory())))
+	}
+	add("keys.count", reply.MakeIntReply(int64(keys)))
+	bytesPerKey := int64(0)
+	if keys > 0 {
+		bytesPerKey = dataset / int64(keys)
+	}
+	add("keys.bytes-per-key", reply.MakeIntReply(bytesPerKey))
+	add("dataset.bytes", reply.MakeIntReply(dataset))
+	percentage := 0.0
+	if stats.HeapAlloc > 0 {
+		percentage = float64(dataset) * 100 / float64(stats.HeapAlloc)
+	}
+	add("dataset.percentage", reply.MakeBulkReply([]byte(strconv.FormatFloat(percentage, 'f', 2, 64))))
+	add("maxmemory", reply.MakeIntReply(mdb.evictor.maxMemory))
+	add("maxmemory.policy", reply.MakeBulkReply([]byte(mdb.evictor.policy)))
+	return result
+}
+
+// 触发 MEMORY DOCTOR 报告的阈值
+const (
+	doctorMinHeap        = 10 << 20 // 堆小于 10MB 时不报告堆与数据集的比例问题
+	doctorHeapRatio      = 2.0      // 堆占用超过数据集估计值的倍数
+	doctorMaxMemoryRatio = 0.9      // 内存使用达到 maxmemory 的比例
+	doctorBigKeySize     = 10 << 20 // 采样中发现的大 key
+	doctorSamples        = 64       // 每个数据库采样的 key 数量
+)
+
+// memoryDoctor 检查常见的内存问题并生成报告
+func memoryDoctor(mdb *StandaloneDatabase) string {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	dataset := mdb.UsedMemory()
+	if dataset == 0 {
+		return "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. Please, leave for your mission on Earth and fill it with some data. The new Sam and I will be back to our programming as soon as I finished rebooting."
+	}
+	issues := make([]string, 0)
+	if stats.HeapAlloc > doctorMinHeap && float64(stats.HeapAlloc) > float64(dataset)*doctorHeapRatio {
+		issues = append(issues, fmt.Sprintf(" * High heap overhead: the Go heap uses %d bytes while the dataset is estimated at %d bytes. "+
+			"This may be caused by garbage not yet collected, client buffers or many small keys with a high fixed overhead.", stats.HeapAlloc, dataset))
+	}
+	if maxMemory := mdb.evictor.maxMemory; maxMemory > 0 {
+		if float64(dataset) >= float64(maxMemory)*doctorMaxMemoryRatio {
+			msg := fmt.Sprintf(" * Near maxmemory: the dataset uses %d of %d bytes.", dataset, maxMemory)
+			if mdb.evictor.policy == policyNoEviction {
+				msg += " The policy is noeviction, write commands will fail with OOM once the limit is reached."
+			}
+			issues = append(issues, msg)
+		}
+		if strings.HasPrefix(mdb.evictor.policy, "volatile-") {
+			issues = append(issues, " * maxmemory-policy is "+mdb.evictor.policy+" but keys with an expire are not supported, no key can be evicted.")
+		}
+	}
+	for _, db := range mdb.dbSet {
+		for _, key := range db.data.RandomDistinctKeys(doctorSamples) {
+			if entity, ok := db.peekEntity(key); ok && entity.Size >= doctorBigKeySize {
+				issues = append(issues, fmt.Sprintf(" * Big key: %q in db %d uses about %d bytes.", key, db.index, entity.Size))
+			}
+		}
+	}
+	if len(issues) == 0 {
+		return "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+	}
+	return "Sam, I detected a few issues in this instance memory implementation:\n\n" +
+		strings.Join(issues, "\n\n") + "\n\nI'm here to keep you safe, Sam. I want to help you.\n"
+}
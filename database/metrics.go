@@ -0,0 +1,55 @@
+package database
+
+import (
+	"go-redis/lib/metrics"
+	"strconv"
+)
+
+var (
+	commandCalls = metrics.NewCounterVec("godis_commands_total",
+		"Number of commands executed, per command.", "cmd")
+	commandDuration = metrics.NewHistogramVec("godis_command_duration_seconds",
+		"Latency of commands including waiting for key locks, per command.", metrics.DefaultBuckets, "cmd")
+)
+
+// commandLabel 返回记录指标使用的命令名，未知命令统一记为 unknown，避免任意输入产生大量指标
+func commandLabel(cmdName string) string {
+	if _, ok := cmdTable[cmdName]; ok {
+		return cmdName
+	}
+	return "unknown"
+}
+
+// registerMetrics 注册在采集时才计算的数据库指标
+func (mdb *StandaloneDatabase) registerMetrics() {
+	metrics.NewGaugeVecFunc("godis_db_keys", "Number of keys in each non-empty database.", "db", func() map[string]float64 {
+		mdb.mu.RLock()
+		defer mdb.mu.RUnlock()
+		result := make(map[string]float64)
+		for _, db := range mdb.dbSet {
+			if keys := db.data.Len(); keys > 0 {
+				result[strconv.Itoa(db.index)] = float64(keys)
+			}
+		}
+		return result
+	})
+	metrics.NewGaugeFunc("godis_used_memory_dataset_bytes", "Estimated memory used by all keys.", func() float64 {
+		mdb.mu.RLock()
+		defer mdb.mu.RUnlock()
+		return float64(mdb.UsedMemory())
+	})
+	metrics.NewGaugeFunc("godis_maxmemory_bytes", "Value of maxmemory, 0 means no limit.", func() float64 {
+		return float64(mdb.evictor.maxMemory)
+	})
+	metrics.NewCounterFunc("godis_evicted_keys_total", "Number of keys evicted because of maxmemory.", func() float64 {
+		return float64(mdb.stats.evicted())
+	})
+	metrics.NewCounterFunc("godis_keyspace_hits_total", "Number of successful key lookups.", func() float64 {
+		hits, _ := mdb.stats.lookups()
+		return float64(hits)
+	})
+	metrics.NewCounterFunc("godis_keyspace_misses_total", "Number of failed key lookups.", func() float64 {
+		_, misses := mdb.stats.lookups()
+		return float64(misses)
+	})
+}
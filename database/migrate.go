@@ -0,0 +1,177 @@
+package database
+
+import (
+	"go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/client"
+	"go-redis/resp/reply"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migratingKey 是已序列化、等待发送到目标实例的 key
+type migratingKey struct {
+	key     string
+	entity  *database.DataEntity // 序列化时的数据实体，用于在删除前判断 key 是否在迁移期间被修改
+	payload []byte
+}
+
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password | AUTH2 username password] [KEYS key [key ...]]
+// 将 key 通过 DUMP/RESTORE 迁移到目标实例，除非指定 COPY，迁移成功后删除本地的 key
+// 只在序列化时持有 key 的锁，连接目标实例及发送数据期间不阻塞其他命令；
+// 发送完成后重新加锁，只删除迁移期间没有被修改的 key
+// timeout 为毫秒，用于建立连接以及等待每条 RESTORE 的响应
+func execMigrate(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 6 {
+		return reply.MakeArgNumErrReply("migrate")
+	}
+	args = args[1:]
+	addr := net.JoinHostPort(string(args[0]), string(args[1]))
+	destDB, err := strconv.Atoi(string(args[3]))
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	timeoutMs, err := strconv.ParseInt(string(args[4]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if timeoutMs <= 0 {
+		// 与 Redis 相同，timeout 为 0 时使用 1 秒
+		timeoutMs = 1000
+	}
+	dbIndex := c.GetDBIndex()
+	if dbIndex >= len(mdb.dbSet) {
+		return reply.MakeErrReply("ERR DB index is out of range")
+	}
+
+	copyMode, replace := false, false
+	var username, password string
+	keys := make([]string, 0)
+	if len(args[2]) > 0 {
+		keys = append(keys, string(args[2]))
+	}
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "COPY":
+			copyMode = true
+		case "REPLACE":
+			replace = true
+		case "AUTH":
+			if i+1 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			password = string(args[i+1])
+			i++
+		case "AUTH2":
+			if i+2 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			username, password = string(args[i+1]), string(args[i+2])
+			i += 2
+		case "KEYS":
+			if len(args[2]) > 0 {
+				return reply.MakeErrReply("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			for _, key := range args[i+1:] {
+				keys = append(keys, string(key))
+			}
+			i = len(args)
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	migrating, errReply := serializeMigratingKeys(mdb, dbIndex, keys)
+	if errReply != nil {
+		return errReply
+	}
+	if len(migrating) == 0 {
+		return reply.MakeStatusReply("NOKEY")
+	}
+
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	target, err := client.MakeClientWithTimeout(addr, timeout)
+	if err != nil {
+		return reply.MakeErrReply("IOERR error or timeout connecting to the client")
+	}
+	target.SetAuth(username, password)
+	target.Start()
+	defer target.Close()
+
+	ret := target.Send(utils.ToCmdLine("SELECT", strconv.Itoa(destDB)))
+	if reply.IsErrorReply(ret) {
+		return reply.MakeErrReply("ERR Target instance replied with error: " + ret.(reply.ErrorReply).Error())
+	}
+	migrated := make([]*migratingKey, 0, len(migrating))
+	for _, m := range migrating {
+		cmdLine := utils.ToCmdLine("RESTORE-ASKING", m.key, "0")
+		cmdLine = append(cmdLine, m.payload)
+		if replace {
+			cmdLine = append(cmdLine, []byte("REPLACE"))
+		}
+		ret = target.Send(cmdLine)
+		if reply.IsErrorReply(ret) {
+			// 已经迁移成功的 key 仍需要从本地删除
+			removeMigrated(mdb, dbIndex, copyMode, migrated)
+			return reply.MakeErrReply("ERR Target instance replied with error: " + ret.(reply.ErrorReply).Error())
+		}
+		migrated = append(migrated, m)
+	}
+	removeMigrated(mdb, dbIndex, copyMode, migrated)
+	return reply.MakeOkReply()
+}
+
+// serializeMigratingKeys 持有 key 的读锁序列化待迁移的 key，不存在的 key 被忽略
+func serializeMigratingKeys(mdb *StandaloneDatabase, dbIndex int, keys []string) ([]*migratingKey, reply.ErrorReply) {
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	db := mdb.dbSet[dbIndex]
+	db.RWLocks(nil, keys)
+	defer db.RWUnLocks(nil, keys)
+
+	migrating := make([]*migratingKey, 0, len(keys))
+	for _, key := range keys {
+		entity, exists := db.GetEntity(key)
+		if !exists {
+			continue
+		}
+		payload, err := serializeEntity(entity)
+		if err != nil {
+			return nil, reply.MakeErrReply(err.Error())
+		}
+		migrating = append(migrating, &migratingKey{key: key, entity: entity, payload: payload})
+	}
+	return migrating, nil
+}
+
+// removeMigrated 删除已经迁移到目标实例的 key
+// 写命令总是以新的数据实体替换旧值，数据实体与序列化时不同说明 key 在迁移期间被修改或删除，此时保留本地的 key
+func removeMigrated(mdb *StandaloneDatabase, dbIndex int, copyMode bool, migrated []*migratingKey) {
+	if copyMode || len(migrated) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(migrated))
+	for _, m := range migrated {
+		keys = append(keys, m.key)
+	}
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	db := mdb.dbSet[dbIndex]
+	db.RWLocks(keys, nil)
+	defer db.RWUnLocks(keys, nil)
+
+	removed := make([][]byte, 0, len(migrated))
+	for _, m := range migrated {
+		if entity, exists := db.peekEntity(m.key); !exists || entity != m.entity {
+			continue
+		}
+		db.Remove(m.key)
+		removed = append(removed, []byte(m.key))
+	}
+	if len(removed) > 0 {
+		db.addAof(utils.ToCmdLine2("DEL", removed...))
+	}
+}
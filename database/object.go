@@ -0,0 +1,99 @@
+package database
+
+import (
+	"go-redis/config"
+	"go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// OBJECT 用于查看 key 的内部信息，不会更新 key 的访问时间和访问频率
+
+// embstrSizeLimit 与 Redis 相同，不超过该长度的字符串使用 embstr 编码
+const embstrSizeLimit = 44
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// lfuPolicySelected 判断当前的淘汰策略是否使用 LFU，只有此时访问频率才有意义
+func lfuPolicySelected() bool {
+	return strings.HasSuffix(strings.ToLower(config.Properties.MaxMemoryPolicy), "-lfu")
+}
+
+// encodingOf 返回数据的编码方式
+func encodingOf(entity *database.DataEntity) string {
+	switch val := entity.Data.(type) {
+	case []byte:
+		if len(val) <= 20 {
+			if _, err := strconv.ParseInt(string(val), 10, 64); err == nil {
+				return "int"
+			}
+		}
+		if len(val) <= embstrSizeLimit {
+			return "embstr"
+		}
+		return "raw"
+	}
+	return "unknown"
+}
+
+// OBJECT subcommand [key]
+func execObject(db *DB, args [][]byte) resp.Reply {
+	subCmd := strings.ToUpper(string(args[0]))
+	if subCmd == "HELP" {
+		return reply.MakeHelpReply(objectHelp)
+	}
+	if len(args) != 2 {
+		return reply.MakeErrReply("ERR unknown subcommand or wrong number of arguments for '" + string(args[0]) + "'. Try OBJECT HELP.")
+	}
+	entity, exists := db.peekEntity(string(args[1]))
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	switch subCmd {
+	case "ENCODING":
+		return reply.MakeBulkReply([]byte(encodingOf(entity)))
+	case "REFCOUNT":
+		return reply.MakeIntReply(1)
+	case "IDLETIME":
+		if lfuPolicySelected() {
+			return reply.MakeErrReply("ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return reply.MakeIntReply(int64(idleTime(entity)))
+	case "FREQ":
+		if !lfuPolicySelected() {
+			return reply.MakeErrReply("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return reply.MakeIntReply(int64(lfuDecrAndReturn(entity)))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try OBJECT HELP.")
+}
+
+// prepareObject OBJECT 的第二个参数为只读的 key
+func prepareObject(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return nil, []string{string(args[1])}
+}
+
+func init() {
+	RegisterCommand("OBJECT", execObject, prepareObject, -2, flagReadOnly)
+}
@@ -11,5 +11,5 @@ func Ping(db *DB, args [][]byte) resp.Reply {
 }
 
 func init() {
-	RegisterCommand("PING", Ping, 1)
+	RegisterCommand("PING", Ping, nil, 1, 0)
 }
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"go-redis/datastruct/dict"
+	"go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/wildcard"
+	"go-redis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// SCAN 系列命令共用的游标迭代：
+// 游标由底层 dict 的 Scan 生成，对 ConcurrentDict 而言是按反向二进制顺序递增的分片下标，
+// 遍历期间的并发写入不会导致已存在的 key 被遗漏。SSCAN、HSCAN、ZSCAN 等命令只需提供各自的 dict 与输出方式
+
+const defaultScanCount = 10
+
+// scanOptions 为 SCAN 系列命令的可选参数
+type scanOptions struct {
+	count    int
+	pattern  *wildcard.Pattern // 为 nil 时不过滤
+	typeName string            // 为空时不过滤，仅 SCAN 支持
+}
+
+// parseScanCursor 解析游标参数
+func parseScanCursor(arg []byte) (uint64, reply.ErrorReply) {
+	cursor, err := strconv.ParseUint(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR invalid cursor")
+	}
+	return cursor, nil
+}
+
+// parseScanOptions 解析 [MATCH pattern] [COUNT count] [TYPE type]，allowType 为 false 时不接受 TYPE
+func parseScanOptions(args [][]byte, allowType bool) (*scanOptions, reply.ErrorReply) {
+	opts := &scanOptions{
+		count: defaultScanCount,
+	}
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, reply.MakeSyntaxErrReply()
+		}
+		value := string(args[i+1])
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			opts.pattern = wildcard.CompilePattern(value)
+		case "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count <= 0 {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			opts.count = count
+		case "TYPE":
+			if !allowType {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			opts.typeName = strings.ToLower(value)
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	return opts, nil
+}
+
+// matchKey 判断 key 是否匹配 MATCH 参数
+func (opts *scanOptions) matchKey(key string) bool {
+	return opts.pattern == nil || opts.pattern.IsMatch(key)
+}
+
+// scanDict 从 cursor 开始遍历 d，对每个匹配 MATCH 的元素调用 emit 生成输出，返回下一次的游标
+func scanDict(d dict.Dict, cursor uint64, opts *scanOptions, emit func(key string, val interface{}) [][]byte) (uint64, [][]byte) {
+	result := make([][]byte, 0, opts.count)
+	next := d.Scan(cursor, opts.count, func(key string, val interface{}) bool {
+		if opts.matchKey(key) {
+			result = append(result, emit(key, val)...)
+		}
+		return true
+	})
+	return next, result
+}
+
+// makeScanReply 生成 [cursor, [elements...]] 格式的回复
+func makeScanReply(cursor uint64, elements [][]byte) resp.Reply {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.FormatUint(cursor, 10))),
+		reply.MakeMultiBulkReply(elements),
+	})
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func execSCAN(db *DB, args [][]byte) resp.Reply {
+	cursor, errReply := parseScanCursor(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	opts, errReply := parseScanOptions(args[1:], true)
+	if errReply != nil {
+		return errReply
+	}
+	next, keys := scanDict(db.data, cursor, opts, func(key string, val interface{}) [][]byte {
+		if opts.typeName != "" {
+			entity, ok := val.(*database.DataEntity)
+			if !ok || typeOf(entity) != opts.typeName {
+				return nil
+			}
+		}
+		return [][]byte{[]byte(key)}
+	})
+	return makeScanReply(next, keys)
+}
+
+func init() {
+	RegisterCommand("SCAN", execSCAN, nil, -2, flagReadOnly)
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"go-redis/interface/database"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// scanAll 从游标 0 开始反复执行 SCAN 直到游标回到 0，返回去重排序后的 key
+func scanAll(t *testing.T, db *DB, args ...string) []string {
+	t.Helper()
+	seen := make(map[string]struct{})
+	cursor := "0"
+	for i := 0; ; i++ {
+		if i > 10000 {
+			t.Fatalf("SCAN %v did not complete", args)
+		}
+		ret := execSCAN(db, utils.ToCmdLine(append([]string{cursor}, args...)...))
+		multi, ok := ret.(*reply.MultiRawReply)
+		if !ok {
+			t.Fatalf("SCAN %v returned %q", args, ret.ToBytes())
+		}
+		cursor = string(multi.Replies[0].(*reply.BulkReply).Arg)
+		for _, key := range multi.Replies[1].(*reply.MultiBulkReply).Args {
+			seen[string(key)] = struct{}{}
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestScan(t *testing.T) {
+	db := MakeDB()
+	var all []string
+	for i := 0; i < 200; i++ {
+		key := "user:" + strconv.Itoa(i)
+		if i%2 == 1 {
+			key = "item:" + strconv.Itoa(i)
+		}
+		db.PutEntity(key, &database.DataEntity{Data: []byte("v")})
+		all = append(all, key)
+	}
+	sort.Strings(all)
+	var users []string
+	for _, key := range all {
+		if key[0] == 'u' {
+			users = append(users, key)
+		}
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"all", nil, all},
+		{"count 1", []string{"COUNT", "1"}, all},
+		{"count larger than dict", []string{"COUNT", "1000"}, all},
+		{"match", []string{"MATCH", "user:*"}, users},
+		{"match and count", []string{"match", "user:*", "count", "3"}, users},
+		{"type", []string{"TYPE", "string"}, all},
+		{"other type", []string{"TYPE", "list"}, []string{}},
+		{"no match", []string{"MATCH", "nothing*"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scanAll(t, db, tt.args...)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d keys, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	db := MakeDB()
+	syntaxErr := string(reply.MakeSyntaxErrReply().ToBytes())
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"abc"}, "-ERR invalid cursor\r\n"},
+		{[]string{"-1"}, "-ERR invalid cursor\r\n"},
+		{[]string{"0", "COUNT"}, syntaxErr},
+		{[]string{"0", "COUNT", "0"}, syntaxErr},
+		{[]string{"0", "COUNT", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"0", "LIMIT", "1"}, syntaxErr},
+	}
+	for _, tt := range tests {
+		got := string(execSCAN(db, utils.ToCmdLine(tt.args...)).ToBytes())
+		if got != tt.want {
+			t.Errorf("SCAN %v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
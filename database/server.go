@@ -0,0 +1,114 @@
+package database
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// parseFlushMode 解析 FLUSHALL、FLUSHDB 的 [ASYNC|SYNC] 参数
+func parseFlushMode(args [][]byte) (async bool, ok bool) {
+	if len(args) == 0 {
+		return false, true
+	}
+	if len(args) > 1 {
+		return false, false
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "ASYNC":
+		return true, true
+	case "SYNC":
+		return false, true
+	}
+	return false, false
+}
+
+// execFlushAll 清空所有数据库
+// FLUSHALL [ASYNC|SYNC]
+func execFlushAll(mdb *StandaloneDatabase, args [][]byte) resp.Reply {
+	async, ok := parseFlushMode(args)
+	if !ok {
+		return reply.MakeSyntaxErrReply()
+	}
+	mdb.lockDataset()
+	defer mdb.unlockDataset()
+	atomic.AddUint64(&mdb.epoch, 1)
+	for _, db := range mdb.dbSet {
+		if async {
+			db.FlushAsync()
+		} else {
+			db.Flush()
+		}
+	}
+	if mdb.aofHandler != nil {
+		mdb.aofHandler.AddAof(0, utils.ToCmdLine2("FLUSHALL", args...))
+	}
+	return reply.MakeOkReply()
+}
+
+// execFlushDB 清空连接所选的数据库
+// FLUSHDB [ASYNC|SYNC]
+func execFlushDB(mdb *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	async, ok := parseFlushMode(args)
+	if !ok {
+		return reply.MakeSyntaxErrReply()
+	}
+	mdb.lockDataset()
+	defer mdb.unlockDataset()
+	dbIndex := c.GetDBIndex()
+	if dbIndex >= len(mdb.dbSet) {
+		return reply.MakeErrReply("ERR DB index is out of range")
+	}
+	atomic.AddUint64(&mdb.epoch, 1)
+	db := mdb.dbSet[dbIndex]
+	if async {
+		db.FlushAsync()
+	} else {
+		db.Flush()
+	}
+	db.addAof(utils.ToCmdLine2("FLUSHDB", args...))
+	return reply.MakeOkReply()
+}
+
+// parseDBIndex 解析数据库编号参数
+func parseDBIndex(mdb *StandaloneDatabase, arg []byte) (int, reply.ErrorReply) {
+	index, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR invalid DB index")
+	}
+	if index < 0 || index >= len(mdb.dbSet) {
+		return 0, reply.MakeErrReply("ERR DB index is out of range")
+	}
+	return index, nil
+}
+
+// execSwapDB 交换两个数据库的内容，已选择这两个数据库的连接会立即看到交换后的数据
+// SWAPDB index1 index2
+func execSwapDB(mdb *StandaloneDatabase, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("swapdb")
+	}
+	index1, errReply := parseDBIndex(mdb, args[0])
+	if errReply != nil {
+		return errReply
+	}
+	index2, errReply := parseDBIndex(mdb, args[1])
+	if errReply != nil {
+		return errReply
+	}
+	// 持有写锁时没有其他命令在访问数据库，可以直接交换；key 锁留在原编号上，跨节点事务可能仍持有其中的锁
+	mdb.lockDataset()
+	defer mdb.unlockDataset()
+	atomic.AddUint64(&mdb.epoch, 1)
+	db1, db2 := mdb.dbSet[index1], mdb.dbSet[index2]
+	db1.index, db2.index = index2, index1
+	db1.locker, db2.locker = db2.locker, db1.locker
+	mdb.dbSet[index1], mdb.dbSet[index2] = db2, db1
+	if mdb.aofHandler != nil {
+		mdb.aofHandler.AddAof(0, utils.ToCmdLine2("SWAPDB", args...))
+	}
+	return reply.MakeOkReply()
+}
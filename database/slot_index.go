@@ -0,0 +1,77 @@
+package database
+
+import "sync"
+
+// slotIndex 按哈希槽记录数据库中的 key，集群模式下 CLUSTER GETKEYSINSLOT、COUNTKEYSINSLOT
+// 只需访问相关槽位中的 key，迁移槽位时不必每一批都遍历整个数据库
+type slotIndex struct {
+	slotOf func(key string) uint32
+	mu     sync.Mutex
+	slots  map[uint32]map[string]struct{}
+}
+
+func makeSlotIndex(slotOf func(key string) uint32) *slotIndex {
+	return &slotIndex{
+		slotOf: slotOf,
+		slots:  make(map[uint32]map[string]struct{}),
+	}
+}
+
+func (idx *slotIndex) add(key string) {
+	slot := idx.slotOf(key)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	keys, ok := idx.slots[slot]
+	if !ok {
+		keys = make(map[string]struct{})
+		idx.slots[slot] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func (idx *slotIndex) remove(key string) {
+	slot := idx.slotOf(key)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	keys, ok := idx.slots[slot]
+	if !ok {
+		return
+	}
+	delete(keys, key)
+	if len(keys) == 0 {
+		delete(idx.slots, slot)
+	}
+}
+
+func (idx *slotIndex) clear() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.slots = make(map[uint32]map[string]struct{})
+}
+
+// keys 返回槽位区间 [start, end] 中的 key，limit 小于等于 0 时不限制数量
+func (idx *slotIndex) keys(start, end uint32, limit int) []string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	result := make([]string, 0)
+	for slot := start; slot <= end; slot++ {
+		for key := range idx.slots[slot] {
+			if limit > 0 && len(result) >= limit {
+				return result
+			}
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// count 返回槽位区间 [start, end] 中的 key 数量
+func (idx *slotIndex) count(start, end uint32) int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	n := 0
+	for slot := start; slot <= end; slot++ {
+		n += len(idx.slots[slot])
+	}
+	return n
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"go-redis/interface/database"
+	"sort"
+	"testing"
+)
+
+// firstByteSlot 以 key 的首字节作为槽位，便于构造测试数据
+func firstByteSlot(key string) uint32 {
+	return uint32(key[0])
+}
+
+func TestSlotIndex(t *testing.T) {
+	db := MakeDB()
+	db.slots = makeSlotIndex(firstByteSlot)
+	for _, key := range []string{"a1", "a2", "b1", "c1", "c2", "c3"} {
+		db.PutEntity(key, &database.DataEntity{Data: []byte("v")})
+	}
+	// 覆盖已有的 key 不应重复计数
+	db.PutEntity("a1", &database.DataEntity{Data: []byte("v2")})
+	db.PutIfAbsent("b1", &database.DataEntity{Data: []byte("v2")})
+	db.Remove("c2")
+
+	tests := []struct {
+		start, end uint32
+		want       []string
+	}{
+		{'a', 'a', []string{"a1", "a2"}},
+		{'b', 'c', []string{"b1", "c1", "c3"}},
+		{'d', 'z', []string{}},
+	}
+	for _, tt := range tests {
+		got := db.slots.keys(tt.start, tt.end, 0)
+		sort.Strings(got)
+		if len(got) != len(tt.want) {
+			t.Fatalf("keys(%c, %c) = %v, want %v", tt.start, tt.end, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("keys(%c, %c) = %v, want %v", tt.start, tt.end, got, tt.want)
+			}
+		}
+		if n := db.slots.count(tt.start, tt.end); n != len(tt.want) {
+			t.Errorf("count(%c, %c) = %d, want %d", tt.start, tt.end, n, len(tt.want))
+		}
+	}
+	if got := db.slots.keys('a', 'c', 2); len(got) != 2 {
+		t.Errorf("keys with limit 2 returned %d keys", len(got))
+	}
+
+	db.Flush()
+	if n := db.slots.count(0, 255); n != 0 {
+		t.Errorf("count after flush = %d, want 0", n)
+	}
+}
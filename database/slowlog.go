@@ -0,0 +1,232 @@
+package database
+
+import (
+	"fmt"
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/lib/latency"
+	"go-redis/lib/redact"
+	"go-redis/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 与 Redis 相同，慢查询日志中每条命令最多保存 32 个参数，每个参数最多 128 字节
+const (
+	slowlogMaxArgc   = 32
+	slowlogMaxArgLen = 128
+)
+
+// slowlogEntry 为慢查询日志中的一条记录
+type slowlogEntry struct {
+	id         int64
+	time       int64 // unix 秒
+	duration   time.Duration
+	args       [][]byte
+	clientAddr string
+	clientName string
+}
+
+// slowlog 保存最近的慢查询，超过 slowlog-max-len 时丢弃最旧的记录
+type slowlog struct {
+	mu      sync.Mutex
+	entries []*slowlogEntry // 最新的记录在最后
+	nextID  int64
+}
+
+func makeSlowlog() *slowlog {
+	return &slowlog{}
+}
+
+// record 在命令耗时超过 slowlog-log-slower-than 时记录该命令，与 MONITOR 相同隐藏命令中的认证信息
+func (sl *slowlog) record(c resp.Connection, cmdLine [][]byte, duration time.Duration) {
+	threshold := config.Properties.SlowlogLogSlowerThan
+	maxLen := config.Properties.SlowlogMaxLen
+	if threshold < 0 || duration < time.Duration(threshold)*time.Microsecond {
+		return
+	}
+	entry := &slowlogEntry{
+		time:       time.Now().Unix(),
+		duration:   duration,
+		args:       truncateSlowlogArgs(redact.Args(cmdLine)),
+		clientAddr: clientAddr(c),
+	}
+	if named, ok := c.(interface{ Name() string }); ok {
+		entry.clientName = named.Name()
+	}
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	entry.id = sl.nextID
+	sl.nextID++
+	sl.entries = append(sl.entries, entry)
+	if overflow := len(sl.entries) - maxLen; overflow > 0 {
+		sl.entries = append(sl.entries[:0:0], sl.entries[overflow:]...)
+	}
+}
+
+// truncateSlowlogArgs 复制命令参数，超出长度限制的部分以提示代替
+func truncateSlowlogArgs(cmdLine [][]byte) [][]byte {
+	argc := len(cmdLine)
+	if argc > slowlogMaxArgc {
+		argc = slowlogMaxArgc
+	}
+	args := make([][]byte, 0, argc)
+	for i := 0; i < argc; i++ {
+		if i == slowlogMaxArgc-1 && len(cmdLine) > slowlogMaxArgc {
+			args = append(args, []byte(fmt.Sprintf("... (%d more arguments)", len(cmdLine)-slowlogMaxArgc+1)))
+			break
+		}
+		arg := cmdLine[i]
+		if len(arg) > slowlogMaxArgLen {
+			arg = []byte(fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen))
+		} else {
+			arg = append([]byte(nil), arg...)
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// clientAddr 返回连接的 ip:port，没有网络连接时返回空字符串
+func clientAddr(c resp.Connection) string {
+	if c == nil {
+		return ""
+	}
+	if addr := c.RemoteAddr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+// get 返回最新的 count 条记录，最新的在前
+func (sl *slowlog) get(count int) []*slowlogEntry {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if count < 0 || count > len(sl.entries) {
+		count = len(sl.entries)
+	}
+	result := make([]*slowlogEntry, 0, count)
+	for i := len(sl.entries) - 1; i >= len(sl.entries)-count; i-- {
+		result = append(result, sl.entries[i])
+	}
+	return result
+}
+
+func (sl *slowlog) len() int {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return len(sl.entries)
+}
+
+func (sl *slowlog) reset() {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.entries = nil
+}
+
+// execSlowlog 执行 SLOWLOG GET [count] | LEN | RESET | HELP
+func execSlowlog(mdb *StandaloneDatabase, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("slowlog")
+	}
+	subCmd := strings.ToUpper(string(args[0]))
+	switch {
+	case subCmd == "GET" && len(args) <= 2:
+		count := 10
+		if len(args) == 2 {
+			n, err := strconv.Atoi(string(args[1]))
+			if err != nil || n < -1 {
+				return reply.MakeErrReply("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		entries := mdb.slowlog.get(count)
+		result := make([]resp.Reply, 0, len(entries))
+		for _, entry := range entries {
+			result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeIntReply(entry.id),
+				reply.MakeIntReply(entry.time),
+				reply.MakeIntReply(entry.duration.Microseconds()),
+				reply.MakeMultiBulkReply(entry.args),
+				reply.MakeBulkReply([]byte(entry.clientAddr)),
+				reply.MakeBulkReply([]byte(entry.clientName)),
+			}))
+		}
+		return reply.MakeMultiRawReply(result)
+	case subCmd == "LEN" && len(args) == 1:
+		return reply.MakeIntReply(int64(mdb.slowlog.len()))
+	case subCmd == "RESET" && len(args) == 1:
+		mdb.slowlog.reset()
+		return reply.MakeOkReply()
+	case subCmd == "HELP" && len(args) == 1:
+		return reply.MakeHelpReply([]string{
+			"SLOWLOG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"GET [<count>]",
+			"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+			"    Entries are made of:",
+			"    id, timestamp, time in microseconds, arguments array, client IP and port,",
+			"    client name",
+			"LEN",
+			"    Return the length of the slowlog.",
+			"RESET",
+			"    Reset the slowlog.",
+			"HELP",
+			"    Prints this help.",
+		})
+	}
+	return reply.MakeErrReply("ERR unknown subcommand or wrong number of arguments for '" + string(args[0]) + "'. Try SLOWLOG HELP.")
+}
+
+// execLatency 执行 LATENCY LATEST | HISTORY event | RESET [event ...] | HELP
+func execLatency(args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("latency")
+	}
+	subCmd := strings.ToUpper(string(args[0]))
+	switch {
+	case subCmd == "LATEST" && len(args) == 1:
+		latest := latency.GetLatest()
+		result := make([]resp.Reply, 0, len(latest))
+		for _, l := range latest {
+			result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeBulkReply([]byte(l.Event)),
+				reply.MakeIntReply(l.Sample.Time),
+				reply.MakeIntReply(l.Sample.Duration.Milliseconds()),
+				reply.MakeIntReply(l.Max.Milliseconds()),
+			}))
+		}
+		return reply.MakeMultiRawReply(result)
+	case subCmd == "HISTORY" && len(args) == 2:
+		samples := latency.GetHistory(string(args[1]))
+		result := make([]resp.Reply, 0, len(samples))
+		for _, sample := range samples {
+			result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeIntReply(sample.Time),
+				reply.MakeIntReply(sample.Duration.Milliseconds()),
+			}))
+		}
+		return reply.MakeMultiRawReply(result)
+	case subCmd == "RESET":
+		events := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			events = append(events, string(arg))
+		}
+		return reply.MakeIntReply(int64(latency.Reset(events...)))
+	case subCmd == "HELP" && len(args) == 1:
+		return reply.MakeHelpReply([]string{
+			"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"HISTORY <event>",
+			"    Return time-latency samples for the <event> class.",
+			"LATEST",
+			"    Return the latest latency samples for all events.",
+			"RESET [<event> ...]",
+			"    Reset latency data of one or more <event> classes.",
+			"    (default: reset all data for all event classes)",
+			"HELP",
+			"    Prints this help.",
+		})
+	}
+	return reply.MakeErrReply("ERR unknown subcommand or wrong number of arguments for '" + string(args[0]) + "'. Try LATENCY HELP.")
+}
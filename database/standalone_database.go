@@ -1,33 +1,65 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"go-redis/aof"
 	"go-redis/config"
+	"go-redis/interface/database"
 	"go-redis/interface/resp"
+	"go-redis/lib/latency"
+	"go-redis/lib/lock"
 	"go-redis/lib/logger"
+	"go-redis/lib/utils"
 	"go-redis/resp/reply"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // StandaloneDatabase 是一个包含多个数据库集合的单机 Redis 数据库
 type StandaloneDatabase struct {
-	dbSet      []*DB
-	aofHandler *aof.AofHandler // 处理 AOF 持久化
+	// mu 保护 dbSet 中的数据库：SWAPDB、FLUSHALL、FLUSHDB 替换数据库内容时持有写锁，其他命令执行期间持有读锁
+	mu    sync.RWMutex
+	dbSet []*DB
+	// datasetMu 在持有 mu 的写锁后获取写锁，跨节点事务已持有 key 锁时以读锁代替 mu 访问数据库。
+	// 普通命令先获取 mu 再获取 key 锁，跨节点事务持有 key 锁后再阻塞地获取 mu 会与它们死锁，datasetMu 的读锁只被这些事务持有
+	datasetMu sync.RWMutex
+	// epoch 在 SWAPDB、FLUSHALL、FLUSHDB 替换数据库内容时递增，原子访问。
+	// 跨节点事务在 Prepare 到 Commit 之间只持有 key 锁而不持有 mu，以 epoch 判断期间数据库是否被替换
+	epoch uint64
+	// lockers 是各编号数据库的 key 锁，SWAPDB 交换数据库时 key 锁留在原编号上，
+	// 因此跨节点事务不持有 mu 也能在同一个 key 锁上加锁和解锁
+	lockers    []*lock.Locks
+	aofHandler *aof.AofHandler         // 处理 AOF 持久化
+	evictor    *evictor                // 内存超过 maxmemory 时淘汰 key
+	stats      *serverStats            // INFO 使用的统计数据
+	slowlog    *slowlog                // 慢查询日志
+	clients    database.ClientRegistry // 网络层的客户端连接信息，由 RespHandler 设置
 }
 
 // NewStandaloneDatabase 创建一个 Redis 数据库
 func NewStandaloneDatabase() *StandaloneDatabase {
-	mdb := &StandaloneDatabase{}
+	mdb := &StandaloneDatabase{
+		evictor: makeEvictor(config.Properties),
+		stats:   makeServerStats(),
+		slowlog: makeSlowlog(),
+	}
+	latency.SetThreshold(time.Duration(config.Properties.LatencyMonitorThreshold) * time.Millisecond)
 	if config.Properties.Databases == 0 {
 		config.Properties.Databases = 16
 	}
 	mdb.dbSet = make([]*DB, config.Properties.Databases)
+	mdb.lockers = make([]*lock.Locks, config.Properties.Databases)
 	for i := range mdb.dbSet {
 		singleDB := MakeDB()
 		singleDB.index = i
+		mdb.lockers[i] = singleDB.locker
+		singleDB.freeMemoryIfNeeded = mdb.freeMemoryIfNeeded
+		singleDB.stats = mdb.stats
 		mdb.dbSet[i] = singleDB
 	}
 	// 判断是否启用 AOF
@@ -46,12 +78,24 @@ func NewStandaloneDatabase() *StandaloneDatabase {
 			}
 		}
 	}
+	mdb.stats.start()
+	mdb.registerMetrics()
 	return mdb
 }
 
 // Exec 执行命令
 // 参数 `cmdLine` 包含命令及其参数，例如："set key value"
-func (mdb *StandaloneDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result resp.Reply) {
+// 耗时超过阈值的命令记入慢查询日志与延迟监控
+func (mdb *StandaloneDatabase) Exec(c resp.Connection, cmdLine [][]byte) resp.Reply {
+	start := time.Now()
+	result := mdb.exec(c, cmdLine)
+	duration := time.Since(start)
+	mdb.slowlog.record(c, cmdLine, duration)
+	latency.AddSampleIfNeeded(latency.EventCommand, duration)
+	return result
+}
+
+func (mdb *StandaloneDatabase) exec(c resp.Connection, cmdLine [][]byte) (result resp.Reply) {
 	defer func() {
 		if err := recover(); err != nil {
 			logger.Warn(fmt.Sprintf("error occurs: %v\n%s", err, string(debug.Stack())))
@@ -60,6 +104,7 @@ func (mdb *StandaloneDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result
 	}()
 
 	cmdName := strings.ToLower(string(cmdLine[0]))
+	mdb.stats.incrCommands()
 	if cmdName == "select" {
 		if len(cmdLine) != 2 {
 			// 处理 select 命令参数错误
@@ -67,6 +112,37 @@ func (mdb *StandaloneDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result
 		}
 		return execSelect(c, mdb, cmdLine[1:])
 	}
+	// 替换数据库内容的命令需要独占所有数据库，MIGRATE 只在访问数据时加锁，传输数据期间不持有锁
+	switch cmdName {
+	case "flushall":
+		return execFlushAll(mdb, cmdLine[1:])
+	case "flushdb":
+		return execFlushDB(mdb, c, cmdLine[1:])
+	case "swapdb":
+		return execSwapDB(mdb, cmdLine[1:])
+	case "migrate":
+		return execMigrate(mdb, c, cmdLine)
+	}
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	// 作用于多个数据库的命令
+	switch cmdName {
+	case "info":
+		return execInfo(mdb, cmdLine[1:])
+	case "memory":
+		return execMemory(mdb, c, cmdLine[1:])
+	case "slowlog":
+		return execSlowlog(mdb, cmdLine[1:])
+	case "latency":
+		return execLatency(cmdLine[1:])
+	case "move":
+		return execMove(mdb, c, cmdLine[1:])
+	case "copy":
+		if !mdb.freeMemoryIfNeeded() {
+			return reply.MakeErrReply(errOOM)
+		}
+		return execCopy(mdb, c, cmdLine[1:])
+	}
 	// 普通命令
 	dbIndex := c.GetDBIndex()
 	if dbIndex >= len(mdb.dbSet) {
@@ -77,9 +153,164 @@ func (mdb *StandaloneDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result
 	return selectedDB.Exec(c, cmdLine)
 }
 
-// Close 优雅关闭数据库
+// errDatasetChanged 表示跨节点事务持有 key 锁期间数据库被替换
+var errDatasetChanged = errors.New("ERR transaction aborted: the dataset was flushed or swapped")
+
+// lockDataset 独占所有数据库：先获取 mu 的写锁阻塞新的命令，再获取 datasetMu 的写锁等待执行中的跨节点事务命令结束
+func (mdb *StandaloneDatabase) lockDataset() {
+	mdb.mu.Lock()
+	mdb.datasetMu.Lock()
+}
+
+func (mdb *StandaloneDatabase) unlockDataset() {
+	mdb.datasetMu.Unlock()
+	mdb.mu.Unlock()
+}
+
+// rLockEpoch 在调用方已持有 key 锁时获取 datasetMu 的读锁，数据库在 epoch 之后被替换时释放读锁并返回 false。
+// 持有 datasetMu 读锁期间不会等待其他锁，等待独占数据库的 FLUSHALL 等命令只会让调用方等待而不会使事务失败
+func (mdb *StandaloneDatabase) rLockEpoch(epoch uint64) bool {
+	mdb.datasetMu.RLock()
+	if atomic.LoadUint64(&mdb.epoch) != epoch {
+		mdb.datasetMu.RUnlock()
+		return false
+	}
+	return true
+}
+
+// ExecWithLock 在连接所选的数据库上执行命令但不获取 key 锁，调用方需要已通过 RWLocks 持有相关 key 的锁，
+// 数据库在 RWLocks 返回的 epoch 之后被替换时不执行命令并返回错误
+func (mdb *StandaloneDatabase) ExecWithLock(c resp.Connection, epoch uint64, cmdLine [][]byte) (result resp.Reply) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Warn(fmt.Sprintf("error occurs: %v\n%s", err, string(debug.Stack())))
+			result = &reply.UnknownErrReply{}
+		}
+	}()
+	dbIndex := c.GetDBIndex()
+	if dbIndex >= len(mdb.dbSet) {
+		return reply.MakeErrReply("ERR DB index is out of range")
+	}
+	if !mdb.rLockEpoch(epoch) {
+		return reply.MakeErrReply(errDatasetChanged.Error())
+	}
+	defer mdb.datasetMu.RUnlock()
+	return mdb.dbSet[dbIndex].execWithLock(cmdLine)
+}
+
+// GetUndoLogs 返回回滚命令所需的命令，调用方需要已通过 RWLocks 持有相关 key 的锁，数据库在 epoch 之后被替换时返回错误
+func (mdb *StandaloneDatabase) GetUndoLogs(dbIndex int, epoch uint64, cmdLine [][]byte) ([]CmdLine, error) {
+	if !mdb.rLockEpoch(epoch) {
+		return nil, errDatasetChanged
+	}
+	defer mdb.datasetMu.RUnlock()
+	return mdb.dbSet[dbIndex].GetUndoLogs(cmdLine), nil
+}
+
+// ExecUndoLogs 锁定 key 并执行回滚日志，调用方不能持有相关 key 的锁，数据库在 epoch 之后被替换时不执行并返回错误
+func (mdb *StandaloneDatabase) ExecUndoLogs(dbIndex int, epoch uint64, writeKeys []string, readKeys []string, undoLogs []CmdLine) error {
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	if atomic.LoadUint64(&mdb.epoch) != epoch {
+		return errDatasetChanged
+	}
+	db := mdb.dbSet[dbIndex]
+	db.RWLocks(writeKeys, readKeys)
+	defer db.RWUnLocks(writeKeys, readKeys)
+	for _, cmdLine := range undoLogs {
+		db.execWithLock(cmdLine)
+	}
+	return nil
+}
+
+// RWLocks 在指定编号的数据库上获取 key 的读写锁，返回加锁前数据库内容的 epoch。
+// 持有 key 锁期间不阻塞 SWAPDB、FLUSHALL 等命令，ExecWithLock 与 GetUndoLogs 据 epoch 判断数据库是否已被替换
+func (mdb *StandaloneDatabase) RWLocks(dbIndex int, writeKeys []string, readKeys []string) uint64 {
+	epoch := atomic.LoadUint64(&mdb.epoch)
+	mdb.lockers[dbIndex].RWLocks(writeKeys, readKeys)
+	return epoch
+}
+
+// RWUnLocks 在指定编号的数据库上释放 key 的读写锁
+func (mdb *StandaloneDatabase) RWUnLocks(dbIndex int, writeKeys []string, readKeys []string) {
+	mdb.lockers[dbIndex].RWUnLocks(writeKeys, readKeys)
+}
+
+// EnableSlotIndex 按 slotOf 计算的哈希槽索引所有数据库中的 key，集群节点在创建时调用，已有的 key 会被加入索引
+func (mdb *StandaloneDatabase) EnableSlotIndex(slotOf func(key string) uint32) {
+	mdb.lockDataset()
+	defer mdb.unlockDataset()
+	for _, db := range mdb.dbSet {
+		idx := makeSlotIndex(slotOf)
+		db.data.ForEach(func(key string, val interface{}) bool {
+			idx.add(key)
+			return true
+		})
+		db.slots = idx
+	}
+}
+
+// KeysInSlots 返回指定数据库中哈希槽位于 [start, end] 的 key，limit 小于等于 0 时不限制数量
+func (mdb *StandaloneDatabase) KeysInSlots(dbIndex int, start, end uint32, limit int) []string {
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	db := mdb.dbSet[dbIndex]
+	if db.slots == nil {
+		return nil
+	}
+	return db.slots.keys(start, end, limit)
+}
+
+// CountKeysInSlots 返回指定数据库中哈希槽位于 [start, end] 的 key 的数量
+func (mdb *StandaloneDatabase) CountKeysInSlots(dbIndex int, start, end uint32) int {
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	db := mdb.dbSet[dbIndex]
+	if db.slots == nil {
+		return 0
+	}
+	return db.slots.count(start, end)
+}
+
+// Close 优雅关闭数据库，写出 AOF 缓冲中的命令并 fsync
 func (mdb *StandaloneDatabase) Close() {
-	// 在这里执行数据库关闭操作（如果有的话）
+	mdb.stats.close()
+	if mdb.aofHandler != nil {
+		mdb.aofHandler.Close()
+	}
+}
+
+// Save 将所有数据库的快照以 RESTORE 命令写入新的 AOF 文件并替换原文件，执行期间阻塞其他命令
+func (mdb *StandaloneDatabase) Save() error {
+	if mdb.aofHandler == nil {
+		return errors.New("appendonly is disabled, there is no file to save the snapshot to")
+	}
+	mdb.lockDataset()
+	defer mdb.unlockDataset()
+	return mdb.aofHandler.Rewrite(func(emit func(dbIndex int, cmdLine CmdLine) error) error {
+		var err error
+		for _, db := range mdb.dbSet {
+			db.data.ForEach(func(key string, val interface{}) bool {
+				var payload []byte
+				payload, err = serializeEntity(val.(*database.DataEntity))
+				if err != nil {
+					return false
+				}
+				cmdLine := utils.ToCmdLine("RESTORE", key, "0")
+				err = emit(db.index, append(cmdLine, payload))
+				return err == nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetClientRegistry 设置提供客户端连接信息的网络层
+func (mdb *StandaloneDatabase) SetClientRegistry(registry database.ClientRegistry) {
+	mdb.clients = registry
 }
 
 // AfterClientClose 在客户端关闭连接后执行一些清理工作
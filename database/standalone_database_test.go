@@ -0,0 +1,58 @@
+package database
+
+import (
+	"go-redis/config"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"testing"
+	"time"
+)
+
+// TestExecWithLockWhileFlushIsPending 跨节点事务提交时 FLUSHALL 正在等待独占数据库，提交应等待而不是失败，
+// FLUSHALL 在提交之后执行
+func TestExecWithLockWhileFlushIsPending(t *testing.T) {
+	setProperties(t, func(props *config.ServerProperties) {
+		props.AppendOnly = false
+	})
+	mdb := NewStandaloneDatabase()
+	t.Cleanup(mdb.Close)
+	conn := &connection.FakeConn{}
+	keys := []string{"a"}
+	epoch := mdb.RWLocks(0, keys, nil)
+
+	// 模拟执行中的命令持有 mu 的读锁，FLUSHALL 等待写锁
+	mdb.mu.RLock()
+	flushed := make(chan struct{})
+	go func() {
+		mdb.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+		close(flushed)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	committed := make(chan string, 1)
+	go func() {
+		committed <- string(mdb.ExecWithLock(conn, epoch, utils.ToCmdLine("SET", "a", "1")).ToBytes())
+	}()
+	select {
+	case ret := <-committed:
+		if ret != "+OK\r\n" {
+			t.Errorf("commit returned %q while FLUSHALL is pending", ret)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("commit blocked")
+	}
+	mdb.RWUnLocks(0, keys, nil)
+	mdb.mu.RUnlock()
+
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+		t.Fatal("FLUSHALL blocked")
+	}
+	// FLUSHALL 之后 epoch 改变，之前获取的 epoch 不能再使用
+	mdb.RWLocks(0, keys, nil)
+	defer mdb.RWUnLocks(0, keys, nil)
+	if ret := mdb.ExecWithLock(conn, epoch, utils.ToCmdLine("SET", "a", "2")).ToBytes(); string(ret) == "+OK\r\n" {
+		t.Errorf("commit succeeded after FLUSHALL")
+	}
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// opsSampleInterval 与 Redis 相同，每 100ms 采样一次命令数，instantaneous_ops_per_sec 为最近 opsSampleCount 次采样的平均值
+	opsSampleInterval = 100 * time.Millisecond
+	opsSampleCount    = 16
+)
+
+// serverStats 记录 INFO 中 stats 与 memory 部分使用的统计数据
+type serverStats struct {
+	runID             string // 每次启动随机生成的 40 位十六进制标识
+	startTime         time.Time
+	commandsProcessed int64
+	keyspaceHits      int64
+	keyspaceMisses    int64
+	evictedKeys       int64
+
+	mu          sync.Mutex
+	opsSamples  [opsSampleCount]int64 // 每个采样周期内每秒执行的命令数
+	sampleIndex int
+	lastCount   int64
+	lastTime    time.Time
+	peakMemory  uint64 // 采样到的 Go 堆占用峰值
+
+	stop chan struct{}
+}
+
+func makeServerStats() *serverStats {
+	runID := make([]byte, 20)
+	_, _ = rand.Read(runID)
+	return &serverStats{
+		runID:     hex.EncodeToString(runID),
+		startTime: time.Now(),
+		lastTime:  time.Now(),
+		stop:      make(chan struct{}),
+	}
+}
+
+// start 启动后台采样协程
+func (s *serverStats) start() {
+	go func() {
+		ticker := time.NewTicker(opsSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sample()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// close 停止后台采样协程
+func (s *serverStats) close() {
+	close(s.stop)
+}
+
+func (s *serverStats) sample() {
+	now := time.Now()
+	count := atomic.LoadInt64(&s.commandsProcessed)
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elapsed := now.Sub(s.lastTime); elapsed > 0 {
+		s.opsSamples[s.sampleIndex] = (count - s.lastCount) * int64(time.Second) / int64(elapsed)
+		s.sampleIndex = (s.sampleIndex + 1) % opsSampleCount
+	}
+	s.lastCount, s.lastTime = count, now
+	if mem.HeapAlloc > s.peakMemory {
+		s.peakMemory = mem.HeapAlloc
+	}
+}
+
+// opsPerSec 返回最近采样的平均每秒命令数
+func (s *serverStats) opsPerSec() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sum int64
+	for _, ops := range s.opsSamples {
+		sum += ops
+	}
+	return sum / opsSampleCount
+}
+
+// peak 返回 Go 堆占用峰值，current 为当前值
+func (s *serverStats) peak(current uint64) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current > s.peakMemory {
+		s.peakMemory = current
+	}
+	return s.peakMemory
+}
+
+func (s *serverStats) incrCommands() {
+	atomic.AddInt64(&s.commandsProcessed, 1)
+}
+
+// recordLookup 记录一次 key 查找是否命中
+func (s *serverStats) recordLookup(hit bool) {
+	if hit {
+		atomic.AddInt64(&s.keyspaceHits, 1)
+	} else {
+		atomic.AddInt64(&s.keyspaceMisses, 1)
+	}
+}
+
+func (s *serverStats) incrEvicted() {
+	atomic.AddInt64(&s.evictedKeys, 1)
+}
+
+func (s *serverStats) totalCommands() int64 {
+	return atomic.LoadInt64(&s.commandsProcessed)
+}
+
+// lookups 返回 key 查找的命中与未命中次数
+func (s *serverStats) lookups() (hits int64, misses int64) {
+	return atomic.LoadInt64(&s.keyspaceHits), atomic.LoadInt64(&s.keyspaceMisses)
+}
+
+func (s *serverStats) evicted() int64 {
+	return atomic.LoadInt64(&s.evictedKeys)
+}
@@ -66,10 +66,51 @@ func execSTRLEN(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeIntReply(int64(len(bytes)))
 }
 
+// MSET key value [key value ...]
+func execMSET(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeArgNumErrReply("mset")
+	}
+	for i := 0; i < len(args); i += 2 {
+		db.PutEntity(string(args[i]), &database.DataEntity{Data: args[i+1]})
+	}
+	db.addAof(utils.ToCmdLine2("MSET", args...))
+	return reply.MakeOkReply()
+}
+
+// MSETNX key value [key value ...]
+// 只要有一个 key 已存在就不设置任何 key
+func execMSETNX(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeArgNumErrReply("msetnx")
+	}
+	for i := 0; i < len(args); i += 2 {
+		if _, exists := db.GetEntity(string(args[i])); exists {
+			return reply.MakeIntReply(0)
+		}
+	}
+	for i := 0; i < len(args); i += 2 {
+		db.PutEntity(string(args[i]), &database.DataEntity{Data: args[i+1]})
+	}
+	db.addAof(utils.ToCmdLine2("MSET", args...))
+	return reply.MakeIntReply(1)
+}
+
+// prepareMSet 偶数位置的参数为需要修改的 key
+func prepareMSet(args [][]byte) ([]string, []string) {
+	keys := make([]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, string(args[i]))
+	}
+	return keys, nil
+}
+
 func init() {
-	RegisterCommand("GET", execGET, 2)
-	RegisterCommand("SET", execSET, -3)
-	RegisterCommand("SETNX", execSETNX, 3)
-	RegisterCommand("GETSET", execGETSET, 3)
-	RegisterCommand("GSTRLEN", execSTRLEN, 2)
+	RegisterCommand("GET", execGET, readFirstKey, 2, flagReadOnly)
+	RegisterCommand("SET", execSET, writeFirstKey, -3, flagWrite|flagDenyOOM)
+	RegisterCommand("SETNX", execSETNX, writeFirstKey, 3, flagWrite|flagDenyOOM)
+	RegisterCommand("GETSET", execGETSET, writeFirstKey, 3, flagWrite|flagDenyOOM)
+	RegisterCommand("MSET", execMSET, prepareMSet, -3, flagWrite|flagDenyOOM)
+	RegisterCommand("MSETNX", execMSETNX, prepareMSet, -3, flagWrite|flagDenyOOM)
+	RegisterCommand("GSTRLEN", execSTRLEN, readFirstKey, 2, flagReadOnly)
 }
@@ -0,0 +1,257 @@
+package dict
+
+import (
+	"math"
+	"math/bits"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentDict 线程安全的 map，分为 2 的幂个分片，每个分片由各自的读写锁保护
+type ConcurrentDict struct {
+	table []*shard
+	count int32
+}
+
+type shard struct {
+	m  map[string]interface{}
+	mu sync.RWMutex
+}
+
+const prime32 = uint32(16777619)
+
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash *= prime32
+		hash ^= uint32(key[i])
+	}
+	return hash
+}
+
+func computeCapacity(param int) int {
+	if param <= 16 {
+		return 16
+	}
+	n := param - 1
+	n |= n >> 1
+	n |= n >> 2
+	n |= n >> 4
+	n |= n >> 8
+	n |= n >> 16
+	if n < 0 || n >= math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return n + 1
+}
+
+// MakeConcurrent 创建至少有 shardCount 个分片的 ConcurrentDict
+func MakeConcurrent(shardCount int) *ConcurrentDict {
+	shardCount = computeCapacity(shardCount)
+	table := make([]*shard, shardCount)
+	for i := range table {
+		table[i] = &shard{
+			m: make(map[string]interface{}),
+		}
+	}
+	return &ConcurrentDict{
+		table: table,
+	}
+}
+
+func (dict *ConcurrentDict) spread(hashCode uint32) uint32 {
+	return hashCode & uint32(len(dict.table)-1)
+}
+
+func (dict *ConcurrentDict) getShard(key string) *shard {
+	return dict.table[dict.spread(fnv32(key))]
+}
+
+func (dict *ConcurrentDict) Get(key string) (val interface{}, exists bool) {
+	s := dict.getShard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	val, exists = s.m[key]
+	return
+}
+
+func (dict *ConcurrentDict) Len() int {
+	return int(atomic.LoadInt32(&dict.count))
+}
+
+func (dict *ConcurrentDict) Put(key string, val interface{}) (result int) {
+	s := dict.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[key]; ok {
+		s.m[key] = val
+		return 0
+	}
+	s.m[key] = val
+	atomic.AddInt32(&dict.count, 1)
+	return 1
+}
+
+func (dict *ConcurrentDict) PutIfAbsent(key string, val interface{}) (result int) {
+	s := dict.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[key]; ok {
+		return 0
+	}
+	s.m[key] = val
+	atomic.AddInt32(&dict.count, 1)
+	return 1
+}
+
+func (dict *ConcurrentDict) PutIfExists(key string, val interface{}) (result int) {
+	s := dict.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[key]; ok {
+		s.m[key] = val
+		return 1
+	}
+	return 0
+}
+
+func (dict *ConcurrentDict) Remove(key string) (result int) {
+	s := dict.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[key]; ok {
+		delete(s.m, key)
+		atomic.AddInt32(&dict.count, -1)
+		return 1
+	}
+	return 0
+}
+
+// ForEach 逐个分片遍历 dict，consumer 返回 false 时停止。
+// 调用 consumer 前会复制分片，consumer 中可以修改 dict
+func (dict *ConcurrentDict) ForEach(consumer Consumer) {
+	for _, s := range dict.table {
+		for _, e := range s.snapshot() {
+			if !consumer(e.key, e.val) {
+				return
+			}
+		}
+	}
+}
+
+type entry struct {
+	key string
+	val interface{}
+}
+
+func (s *shard) snapshot() []entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]entry, 0, len(s.m))
+	for k, v := range s.m {
+		entries = append(entries, entry{key: k, val: v})
+	}
+	return entries
+}
+
+func (dict *ConcurrentDict) Keys() []string {
+	keys := make([]string, 0, dict.Len())
+	dict.ForEach(func(key string, val interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// randomKey 从随机的非空分片中返回一个 key
+func (dict *ConcurrentDict) randomKey() (string, bool) {
+	if dict.Len() == 0 {
+		return "", false
+	}
+	start := rand.Intn(len(dict.table))
+	for i := 0; i < len(dict.table); i++ {
+		s := dict.table[(start+i)%len(dict.table)]
+		s.mu.RLock()
+		for key := range s.m {
+			s.mu.RUnlock()
+			return key, true
+		}
+		s.mu.RUnlock()
+	}
+	return "", false
+}
+
+// RandomKeys 返回 limit 个 key，可能有重复
+func (dict *ConcurrentDict) RandomKeys(limit int) []string {
+	result := make([]string, 0, limit)
+	for i := 0; i < limit; i++ {
+		key, ok := dict.randomKey()
+		if !ok {
+			break
+		}
+		result = append(result, key)
+	}
+	return result
+}
+
+// RandomDistinctKeys 返回最多 limit 个不重复的 key
+func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
+	size := dict.Len()
+	if limit > size {
+		limit = size
+	}
+	set := make(map[string]struct{}, limit)
+	// key 可能被并发删除，尝试足够多次后放弃
+	for attempts := 0; len(set) < limit && attempts < limit*10; attempts++ {
+		key, ok := dict.randomKey()
+		if !ok {
+			break
+		}
+		set[key] = struct{}{}
+	}
+	result := make([]string, 0, len(set))
+	for key := range set {
+		result = append(result, key)
+	}
+	return result
+}
+
+func (dict *ConcurrentDict) Clear() {
+	for _, s := range dict.table {
+		s.mu.Lock()
+		atomic.AddInt32(&dict.count, -int32(len(s.m)))
+		s.m = make(map[string]interface{})
+		s.mu.Unlock()
+	}
+}
+
+// Scan 从 cursor 开始按整个分片遍历，直到至少 count 个元素传给了 consumer，返回下次调用的游标，0 表示遍历结束。
+// consumer 的返回值被忽略：每个分片总是完整遍历，不会遗漏 key。
+//
+// 与 redis 的 dictScan 一样按分片下标的反向二进制顺序遍历，分片数不同的 dict 上游标依然有效：
+// 整个遍历期间一直存在的 key 至少返回一次，一个 key 可能返回多次
+func (dict *ConcurrentDict) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	mask := uint64(len(dict.table) - 1)
+	visited := 0
+	// 大部分分片为空时限制一次调用遍历的分片数
+	maxShards := count * 10
+	for i := 1; ; i++ {
+		for _, e := range dict.table[cursor&mask].snapshot() {
+			visited++
+			consumer(e.key, e.val)
+		}
+		cursor = nextCursor(cursor, mask)
+		if cursor == 0 || visited >= count || i >= maxShards {
+			return cursor
+		}
+	}
+}
+
+// nextCursor 在 mask 范围内对 cursor 的反向二进制位加 1
+func nextCursor(cursor uint64, mask uint64) uint64 {
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
@@ -0,0 +1,132 @@
+package dict
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestNextCursor(t *testing.T) {
+	tests := []struct {
+		cursor, mask uint64
+		want         uint64
+	}{
+		// 2 个分片：0 -> 1 -> 结束
+		{0, 1, 1},
+		{1, 1, 0},
+		// 8 个分片：0 -> 4 -> 2 -> 6 -> 1 -> 5 -> 3 -> 7 -> 结束
+		{0, 7, 4},
+		{4, 7, 2},
+		{2, 7, 6},
+		{6, 7, 1},
+		{1, 7, 5},
+		{5, 7, 3},
+		{3, 7, 7},
+		{7, 7, 0},
+		// 丢弃 mask 以上的位
+		{7 | 8, 7, 0},
+	}
+	for _, tt := range tests {
+		if got := nextCursor(tt.cursor, tt.mask); got != tt.want {
+			t.Errorf("nextCursor(%d, %d) = %d, want %d", tt.cursor, tt.mask, got, tt.want)
+		}
+	}
+}
+
+func TestNextCursorVisitsEveryShard(t *testing.T) {
+	for _, size := range []uint64{1, 2, 16, 256} {
+		mask := size - 1
+		seen := make(map[uint64]bool)
+		cursor := uint64(0)
+		for {
+			if seen[cursor] {
+				t.Fatalf("size %d: cursor %d visited twice", size, cursor)
+			}
+			seen[cursor] = true
+			cursor = nextCursor(cursor, mask)
+			if cursor == 0 {
+				break
+			}
+		}
+		if uint64(len(seen)) != size {
+			t.Errorf("size %d: visited %d shards", size, len(seen))
+		}
+	}
+}
+
+func makeTestDict(shards, keys int) *ConcurrentDict {
+	d := MakeConcurrent(shards)
+	for i := 0; i < keys; i++ {
+		d.Put("key"+strconv.Itoa(i), i)
+	}
+	return d
+}
+
+// TestScanResize 在分片数不同的 dict 上继续遍历，每个 key 仍需至少返回一次
+func TestScanResize(t *testing.T) {
+	const keys = 1000
+	tests := []struct {
+		name          string
+		before, after int
+		switchAfter   int // calls made on the first dict
+	}{
+		{"same", 64, 64, 5},
+		{"grow", 16, 256, 5},
+		{"shrink", 256, 16, 40},
+		{"shrink to one shard", 64, 1, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := makeTestDict(tt.before, keys)
+			after := makeTestDict(tt.after, keys)
+			seen := make(map[string]bool)
+			consumer := func(key string, val interface{}) bool {
+				seen[key] = true
+				return true
+			}
+			cursor := uint64(0)
+			for i := 0; i < tt.switchAfter; i++ {
+				cursor = before.Scan(cursor, 1, consumer)
+				if cursor == 0 {
+					t.Fatalf("iteration completed before switching dicts")
+				}
+			}
+			for calls := 0; ; calls++ {
+				if calls > keys {
+					t.Fatalf("iteration did not complete")
+				}
+				cursor = after.Scan(cursor, 1, consumer)
+				if cursor == 0 {
+					break
+				}
+			}
+			for i := 0; i < keys; i++ {
+				if key := "key" + strconv.Itoa(i); !seen[key] {
+					t.Fatalf("%s was not returned", key)
+				}
+			}
+		})
+	}
+}
+
+func TestScanCount(t *testing.T) {
+	d := makeTestDict(16, 100)
+	cursor := uint64(0)
+	total := 0
+	for {
+		n := 0
+		cursor = d.Scan(cursor, 30, func(key string, val interface{}) bool {
+			n++
+			return true
+		})
+		total += n
+		if cursor == 0 {
+			break
+		}
+		if n < 30 {
+			t.Fatalf("Scan returned %d entries before the end of the iteration, want at least 30", n)
+		}
+	}
+	if total != 100 {
+		t.Errorf("Scan returned %d entries, want 100", total)
+	}
+}
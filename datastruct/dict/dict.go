@@ -13,5 +13,7 @@ type Dict interface {
 	Keys() []string
 	RandomKeys(limit int) []string
 	RandomDistinctKeys(limit int) []string
+	// Scan 从 cursor 开始将约 count 个元素传给 consumer，返回下次调用的游标，遍历从游标 0 开始并以 0 结束
+	Scan(cursor uint64, count int, consumer Consumer) uint64
 	Clear()
 }
@@ -1,6 +1,9 @@
 package dict
 
-import "sync"
+import (
+	"sort"
+	"sync"
+)
 
 type SyncDict struct {
 	m sync.Map
@@ -74,31 +77,50 @@ func (sd *SyncDict) Keys() []string {
 	return result
 }
 
+// RandomKeys 返回 limit 个 key，可能有重复，sync.Map 的遍历顺序是随机的
 func (sd *SyncDict) RandomKeys(limit int) []string {
-	result := make([]string, sd.Len())
-	for i := 0; i < 100; i++ {
+	result := make([]string, 0, limit)
+	if sd.Len() == 0 {
+		return result
+	}
+	for i := 0; i < limit; i++ {
 		sd.m.Range(func(key, value any) bool {
-			result[i] = key.(string)
+			result = append(result, key.(string))
 			return false
 		})
 	}
 	return result
 }
 
+// RandomDistinctKeys 返回最多 limit 个不重复的 key
 func (sd *SyncDict) RandomDistinctKeys(limit int) []string {
-	result := make([]string, sd.Len())
-	i := 0
+	result := make([]string, 0, limit)
+	if limit <= 0 {
+		return result
+	}
 	sd.m.Range(func(key, value any) bool {
-		result[i] = key.(string)
-		i++
-		if i == limit {
-			return false
-		}
-		return true
+		result = append(result, key.(string))
+		return len(result) < limit
 	})
 	return result
 }
 
+// Scan 以 key 排序后的位置作为游标，参见 Dict.Scan
+func (sd *SyncDict) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	keys := sd.Keys()
+	sort.Strings(keys)
+	i := cursor
+	for ; i < uint64(len(keys)) && i < cursor+uint64(count); i++ {
+		if val, ok := sd.Get(keys[i]); ok {
+			consumer(keys[i], val)
+		}
+	}
+	if i >= uint64(len(keys)) {
+		return 0
+	}
+	return i
+}
+
 func (sd *SyncDict) Clear() {
 	*sd = *MakeSyncDict()
 }
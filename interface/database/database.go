@@ -10,6 +10,51 @@ type Database interface {
 	AfterClientClose(c resp.Connection)
 }
 
+// DBEngine is the storage engine used by cluster nodes, it exposes key locks and undo logs for cross-node transactions
+type DBEngine interface {
+	Database
+	// RWLocks locks keys of a database and returns the epoch of the dataset, the epoch changes when
+	// FLUSHALL, FLUSHDB or SWAPDB replaces the dataset while the keys are locked
+	RWLocks(dbIndex int, writeKeys []string, readKeys []string) uint64
+	RWUnLocks(dbIndex int, writeKeys []string, readKeys []string)
+	// ExecWithLock executes a command on keys locked by RWLocks, it fails without executing if the dataset
+	// has changed since epoch
+	ExecWithLock(conn resp.Connection, epoch uint64, cmdLine [][]byte) resp.Reply
+	// GetUndoLogs returns the commands restoring keys modified by cmdLine, the keys must be locked by RWLocks
+	GetUndoLogs(dbIndex int, epoch uint64, cmdLine [][]byte) ([]CmdLine, error)
+	// ExecUndoLogs locks keys and executes undo logs, it fails without executing if the dataset has changed since epoch
+	ExecUndoLogs(dbIndex int, epoch uint64, writeKeys []string, readKeys []string, undoLogs []CmdLine) error
+	// EnableSlotIndex indexes keys by the slot computed by slotOf, KeysInSlots and CountKeysInSlots read the index
+	EnableSlotIndex(slotOf func(key string) uint32)
+	// KeysInSlots returns keys of the database in slots [start, end], limit <= 0 means no limit
+	KeysInSlots(dbIndex int, start, end uint32, limit int) []string
+	CountKeysInSlots(dbIndex int, start, end uint32) int
+}
+
+// DataEntity stores the value of a key together with metadata used by memory eviction
 type DataEntity struct {
 	Data interface{}
+	// Size is the approximate memory used by the key and value in bytes, set when the entity is stored
+	Size int64
+	// LRU is the access clock in seconds, must be accessed atomically
+	LRU uint32
+	// LFU holds the last decrement time in minutes (high 24 bits) and a logarithmic access counter (low 8 bits),
+	// must be accessed atomically
+	LFU uint32
+}
+
+// ClientRegistry reports the client connections of the network layer, used by commands like INFO
+type ClientRegistry interface {
+	ConnectedClients() int
+	TotalConnectionsReceived() int64
+}
+
+// ClientRegistryAware is implemented by databases that report client connections
+type ClientRegistryAware interface {
+	SetClientRegistry(registry ClientRegistry)
+}
+
+// Snapshotter is implemented by databases that can persist a snapshot of the whole dataset, used by SHUTDOWN SAVE
+type Snapshotter interface {
+	Save() error
 }
@@ -1,7 +1,11 @@
 package resp
 
+import "net"
+
 type Connection interface {
 	Write([]byte) error
 	GetDBIndex() int
 	SelectDB(int)
+	// RemoteAddr returns the client address, or nil for connections without a network peer such as FakeConn
+	RemoteAddr() net.Addr
 }
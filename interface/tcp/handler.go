@@ -9,3 +9,8 @@ type Handler interface {
 	Handle(ctx context.Context, conn net.Conn)
 	Close() error
 }
+
+// ShutdownNotifier is implemented by handlers that can ask the server to shut down, e.g. on the SHUTDOWN command
+type ShutdownNotifier interface {
+	ShutdownRequested() <-chan struct{}
+}
@@ -0,0 +1,151 @@
+// Package latency 与 redis 的 LATENCY 监控一样记录内部事件的延迟尖峰。
+// 只保留不低于阈值的样本，每个事件每秒最多一个样本
+package latency
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 服务器上报的事件
+const (
+	EventCommand        = "command"
+	EventAofWrite       = "aof-write"
+	EventAofFsyncAlways = "aof-fsync-always"
+	EventEvictionCycle  = "eviction-cycle"
+)
+
+// historyLen 每个事件保留的样本数，与 redis 相同
+const historyLen = 160
+
+// Sample 事件在某一秒内的延迟
+type Sample struct {
+	Time     int64 // unix seconds
+	Duration time.Duration
+}
+
+// history 用环形缓冲区保存一个事件最近的样本
+type history struct {
+	samples []Sample
+	next    int
+	max     time.Duration // the highest latency observed since the last reset
+}
+
+func (h *history) add(now int64, d time.Duration) {
+	if d > h.max {
+		h.max = d
+	}
+	// 同一秒内的样本合并，保留最高的延迟
+	if len(h.samples) > 0 {
+		last := &h.samples[(h.next-1+len(h.samples))%len(h.samples)]
+		if last.Time == now {
+			if d > last.Duration {
+				last.Duration = d
+			}
+			return
+		}
+	}
+	if len(h.samples) < historyLen {
+		h.samples = append(h.samples, Sample{Time: now, Duration: d})
+		h.next = len(h.samples) % historyLen
+		return
+	}
+	h.samples[h.next] = Sample{Time: now, Duration: d}
+	h.next = (h.next + 1) % historyLen
+}
+
+// ordered 从旧到新返回样本
+func (h *history) ordered() []Sample {
+	result := make([]Sample, 0, len(h.samples))
+	if len(h.samples) < historyLen {
+		return append(result, h.samples...)
+	}
+	result = append(result, h.samples[h.next:]...)
+	return append(result, h.samples[:h.next]...)
+}
+
+var (
+	mu        sync.Mutex
+	histories = make(map[string]*history)
+	// threshold 记录的最小延迟，为 0 时关闭监控
+	threshold time.Duration
+)
+
+// SetThreshold 设置记录的最小延迟，为 0 时关闭监控
+func SetThreshold(d time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	threshold = d
+}
+
+// AddSampleIfNeeded 监控开启且 d 达到阈值时记录事件的延迟
+func AddSampleIfNeeded(event string, d time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	if threshold <= 0 || d < threshold {
+		return
+	}
+	h, ok := histories[event]
+	if !ok {
+		h = &history{}
+		histories[event] = h
+	}
+	h.add(time.Now().Unix(), d)
+}
+
+// Latest 事件最近一次与最高的延迟
+type Latest struct {
+	Event  string
+	Sample Sample
+	Max    time.Duration
+}
+
+// GetLatest 按事件名排序返回每个事件最近的样本
+func GetLatest() []Latest {
+	mu.Lock()
+	defer mu.Unlock()
+	result := make([]Latest, 0, len(histories))
+	for event, h := range histories {
+		samples := h.ordered()
+		result = append(result, Latest{
+			Event:  event,
+			Sample: samples[len(samples)-1],
+			Max:    h.max,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Event < result[j].Event
+	})
+	return result
+}
+
+// GetHistory 从旧到新返回事件的样本
+func GetHistory(event string) []Sample {
+	mu.Lock()
+	defer mu.Unlock()
+	h, ok := histories[event]
+	if !ok {
+		return nil
+	}
+	return h.ordered()
+}
+
+// Reset 删除指定事件的样本，未指定事件时删除所有事件的样本，返回被重置的事件数
+func Reset(events ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) == 0 {
+		count := len(histories)
+		histories = make(map[string]*history)
+		return count
+	}
+	count := 0
+	for _, event := range events {
+		if _, ok := histories[event]; ok {
+			delete(histories, event)
+			count++
+		}
+	}
+	return count
+}
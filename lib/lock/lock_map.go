@@ -0,0 +1,139 @@
+package lock
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	prime32 = uint32(16777619)
+)
+
+// Locks 为 key 提供读写锁，多个 key 通过哈希映射到固定数量的锁上
+type Locks struct {
+	table []*sync.RWMutex
+}
+
+// Make 创建一个包含 tableSize 个锁的 Locks，tableSize 会被调整为 2 的幂
+func Make(tableSize int) *Locks {
+	size := 1
+	for size < tableSize {
+		size <<= 1
+	}
+	table := make([]*sync.RWMutex, size)
+	for i := 0; i < size; i++ {
+		table[i] = &sync.RWMutex{}
+	}
+	return &Locks{
+		table: table,
+	}
+}
+
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash *= prime32
+		hash ^= uint32(key[i])
+	}
+	return hash
+}
+
+func (locks *Locks) spread(hashCode uint32) uint32 {
+	return hashCode & uint32(len(locks.table)-1)
+}
+
+// Lock 获取 key 的写锁
+func (locks *Locks) Lock(key string) {
+	locks.table[locks.spread(fnv32(key))].Lock()
+}
+
+// RLock 获取 key 的读锁
+func (locks *Locks) RLock(key string) {
+	locks.table[locks.spread(fnv32(key))].RLock()
+}
+
+// UnLock 释放 key 的写锁
+func (locks *Locks) UnLock(key string) {
+	locks.table[locks.spread(fnv32(key))].Unlock()
+}
+
+// TryLock 尝试获取 key 的写锁，锁已被占用时立即返回 false
+func (locks *Locks) TryLock(key string) bool {
+	return locks.table[locks.spread(fnv32(key))].TryLock()
+}
+
+// RUnLock 释放 key 的读锁
+func (locks *Locks) RUnLock(key string) {
+	locks.table[locks.spread(fnv32(key))].RUnlock()
+}
+
+// toLockIndices 计算 key 对应的锁下标，去重后排序，保证所有协程按相同顺序加锁以避免死锁
+func (locks *Locks) toLockIndices(keys []string, reverse bool) []uint32 {
+	indexMap := make(map[uint32]struct{})
+	for _, key := range keys {
+		indexMap[locks.spread(fnv32(key))] = struct{}{}
+	}
+	indices := make([]uint32, 0, len(indexMap))
+	for index := range indexMap {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		if !reverse {
+			return indices[i] < indices[j]
+		}
+		return indices[i] > indices[j]
+	})
+	return indices
+}
+
+// Locks 获取多个 key 的写锁
+func (locks *Locks) Locks(keys ...string) {
+	for _, index := range locks.toLockIndices(keys, false) {
+		locks.table[index].Lock()
+	}
+}
+
+// UnLocks 释放多个 key 的写锁
+func (locks *Locks) UnLocks(keys ...string) {
+	for _, index := range locks.toLockIndices(keys, true) {
+		locks.table[index].Unlock()
+	}
+}
+
+// RWLocks 获取 writeKeys 的写锁和 readKeys 的读锁，同时出现在两者中的 key 只获取写锁
+func (locks *Locks) RWLocks(writeKeys []string, readKeys []string) {
+	keys := make([]string, 0, len(writeKeys)+len(readKeys))
+	keys = append(append(keys, writeKeys...), readKeys...)
+	indices := locks.toLockIndices(keys, false)
+	writeIndices := locks.writeIndexSet(writeKeys)
+	for _, index := range indices {
+		if _, w := writeIndices[index]; w {
+			locks.table[index].Lock()
+		} else {
+			locks.table[index].RLock()
+		}
+	}
+}
+
+// RWUnLocks 释放由 RWLocks 获取的锁
+func (locks *Locks) RWUnLocks(writeKeys []string, readKeys []string) {
+	keys := make([]string, 0, len(writeKeys)+len(readKeys))
+	keys = append(append(keys, writeKeys...), readKeys...)
+	indices := locks.toLockIndices(keys, true)
+	writeIndices := locks.writeIndexSet(writeKeys)
+	for _, index := range indices {
+		if _, w := writeIndices[index]; w {
+			locks.table[index].Unlock()
+		} else {
+			locks.table[index].RUnlock()
+		}
+	}
+}
+
+func (locks *Locks) writeIndexSet(writeKeys []string) map[uint32]struct{} {
+	set := make(map[uint32]struct{}, len(writeKeys))
+	for _, key := range writeKeys {
+		set[locks.spread(fnv32(key))] = struct{}{}
+	}
+	return set
+}
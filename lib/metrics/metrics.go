@@ -0,0 +1,307 @@
+// Package metrics 实现 Prometheus 指标类型和文本格式的一个最小子集，服务器不需要引入额外的依赖
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are latency buckets in seconds, from 10µs to 10s
+var DefaultBuckets = []float64{.00001, .00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector 以文本格式输出一个指标族
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry 保存所有指标族，按注册顺序输出
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// Default 是 Handler 暴露的默认注册表
+var Default = &Registry{}
+
+// register 注册 c，替换之前注册的同名指标族
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.collectors {
+		if existing.name() == c.name() {
+			r.collectors[i] = c
+			return
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteText 以 Prometheus 文本格式输出所有指标族
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	collectors := make([]collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mu.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// formatLabels 生成 {name="value",...}，extra 是已经格式化的标签，追加在最后
+func formatLabels(names, values []string, extra string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, n := range names {
+		pairs = append(pairs, n+"="+strconv.Quote(values[i]))
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// vec 保存标签值到指标族中子指标的映射
+type vec struct {
+	metricName string
+	help       string
+	labels     []string
+
+	mu       sync.RWMutex
+	children map[string]interface{}
+	values   map[string][]string
+}
+
+func makeVec(name, help string, labels []string) vec {
+	return vec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		children:   make(map[string]interface{}),
+		values:     make(map[string][]string),
+	}
+}
+
+func (v *vec) name() string {
+	return v.metricName
+}
+
+func (v *vec) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic("metrics: " + v.metricName + " expects " + strconv.Itoa(len(v.labels)) + " label values")
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	child, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return child
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if child, ok = v.children[key]; ok {
+		return child
+	}
+	child = create()
+	v.children[key] = child
+	v.values[key] = append([]string(nil), values...)
+	return child
+}
+
+// each 按标签值排序遍历所有子指标
+func (v *vec) each(fn func(values []string, child interface{})) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		v.mu.RLock()
+		child, values := v.children[key], v.values[key]
+		v.mu.RUnlock()
+		fn(values, child)
+	}
+}
+
+// Counter 单调递增的整数计数器
+type Counter struct {
+	value uint64
+}
+
+// Inc 计数加 1
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add 计数加 n
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// CounterVec 按标签区分的一组计数器
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec 在默认注册表中注册计数器族
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: makeVec(name, help, labels)}
+	Default.register(c)
+	return c
+}
+
+// WithLabelValues 返回标签值对应的计数器，不存在时创建
+func (c *CounterVec) WithLabelValues(values ...string) *Counter {
+	return c.get(values, func() interface{} {
+		return &Counter{}
+	}).(*Counter)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	writeHeader(w, c.metricName, c.help, "counter")
+	c.each(func(values []string, child interface{}) {
+		_, _ = fmt.Fprintf(w, "%s%s %d\n", c.metricName, formatLabels(c.labels, values, ""),
+			atomic.LoadUint64(&child.(*Counter).value))
+	})
+}
+
+// Histogram 将观测值累计到各个桶中
+type Histogram struct {
+	upperBounds []float64
+	counts      []uint64 // counts[i] observations in (upperBounds[i-1], upperBounds[i]], the last one is +Inf
+	count       uint64
+	sumBits     uint64 // float64 bits of the sum
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		upperBounds: buckets,
+		counts:      make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	for {
+		old := atomic.LoadUint64(&h.sumBits)
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&h.sumBits, old, sum) {
+			break
+		}
+	}
+	atomic.AddUint64(&h.count, 1)
+}
+
+// HistogramVec 按标签区分的一组直方图
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// NewHistogramVec 在默认注册表中注册直方图族，buckets 必须有序
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{vec: makeVec(name, help, labels), buckets: buckets}
+	Default.register(h)
+	return h
+}
+
+// WithLabelValues 返回标签值对应的直方图，不存在时创建
+func (h *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return h.get(values, func() interface{} {
+		return newHistogram(h.buckets)
+	}).(*Histogram)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	writeHeader(w, h.metricName, h.help, "histogram")
+	h.each(func(values []string, child interface{}) {
+		hist := child.(*Histogram)
+		var cumulative uint64
+		for i, bound := range hist.upperBounds {
+			cumulative += atomic.LoadUint64(&hist.counts[i])
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName,
+				formatLabels(h.labels, values, `le="`+formatFloat(bound)+`"`), cumulative)
+		}
+		cumulative += atomic.LoadUint64(&hist.counts[len(hist.upperBounds)])
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(h.labels, values, `le="+Inf"`), cumulative)
+		labels := formatLabels(h.labels, values, "")
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labels,
+			formatFloat(math.Float64frombits(atomic.LoadUint64(&hist.sumBits))))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labels, atomic.LoadUint64(&hist.count))
+	})
+}
+
+// funcCollector 在抓取时计算并输出指标值
+type funcCollector struct {
+	metricName string
+	help       string
+	typ        string
+	label      string
+	fn         func() map[string]float64
+}
+
+func (f *funcCollector) name() string {
+	return f.metricName
+}
+
+func (f *funcCollector) write(w io.Writer) {
+	writeHeader(w, f.metricName, f.help, f.typ)
+	values := f.fn()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		labels := ""
+		if f.label != "" {
+			labels = formatLabels([]string{f.label}, []string{key}, "")
+		}
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", f.metricName, labels, formatFloat(values[key]))
+	}
+}
+
+// NewGaugeFunc 注册一个 gauge，抓取时调用 fn 计算其值
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.register(&funcCollector{metricName: name, help: help, typ: "gauge", fn: func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}})
+}
+
+// NewCounterFunc 注册一个 counter，抓取时调用 fn 计算其值
+func NewCounterFunc(name, help string, fn func() float64) {
+	Default.register(&funcCollector{metricName: name, help: help, typ: "counter", fn: func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}})
+}
+
+// NewGaugeVecFunc 注册只有一个标签的 gauge 族，fn 返回每个标签值对应的值
+func NewGaugeVecFunc(name, help, label string, fn func() map[string]float64) {
+	Default.register(&funcCollector{metricName: name, help: help, typ: "gauge", label: label, fn: fn})
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+)
+
+func init() {
+	registerRuntimeMetrics()
+}
+
+// registerRuntimeMetrics 注册 Go 运行时指标，名称与 Prometheus 官方客户端一致
+func registerRuntimeMetrics() {
+	NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	memStat := func(get func(*runtime.MemStats) uint64) func() float64 {
+		return func() float64 {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			return float64(get(&stats))
+		}
+	}
+	NewGaugeFunc("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.",
+		memStat(func(s *runtime.MemStats) uint64 { return s.HeapAlloc }))
+	NewGaugeFunc("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.",
+		memStat(func(s *runtime.MemStats) uint64 { return s.HeapInuse }))
+	NewGaugeFunc("go_memstats_sys_bytes", "Number of bytes obtained from system.",
+		memStat(func(s *runtime.MemStats) uint64 { return s.Sys }))
+	NewCounterFunc("go_gc_cycles_total", "Number of completed GC cycles.",
+		memStat(func(s *runtime.MemStats) uint64 { return uint64(s.NumGC) }))
+	NewCounterFunc("go_gc_pause_seconds_total", "Total time spent in GC stop-the-world pauses.", func() float64 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return time.Duration(stats.PauseTotalNs).Seconds()
+	})
+}
+
+// Handler 以 Prometheus 文本格式输出默认注册表
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.WriteText(w)
+	})
+}
+
+// ListenAndServe 在 addr 上提供 /metrics，阻塞直到监听失败
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+// Since 返回从 start 开始经过的秒数，便于传给 Histogram.Observe
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
@@ -0,0 +1,69 @@
+// Package redact 在命令参数被 MONITOR 输出或记录到 SLOWLOG 之前隐藏其中的凭据
+package redact
+
+import "strings"
+
+// Redacted 替换用户名、密码与令牌
+var Redacted = []byte("(redacted)")
+
+// Args 替换 AUTH、HELLO AUTH、MIGRATE AUTH/AUTH2 与 CLUSTER PEERAUTH 中的凭据。
+// 没有需要隐藏的内容时原样返回 args，否则返回副本
+func Args(args [][]byte) [][]byte {
+	if len(args) == 0 {
+		return args
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "auth":
+		result := make([][]byte, len(args))
+		result[0] = args[0]
+		for i := 1; i < len(args); i++ {
+			result[i] = Redacted
+		}
+		return result
+	case "hello":
+		// HELLO [protover [AUTH username password] [SETNAME clientname]]
+		result := make([][]byte, len(args))
+		copy(result, args)
+		for i := 1; i < len(args); i++ {
+			if strings.EqualFold(string(args[i]), "auth") {
+				for j := i + 1; j < len(args) && j <= i+2; j++ {
+					result[j] = Redacted
+				}
+				i += 2
+			}
+		}
+		return result
+	case "migrate":
+		// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password | AUTH2 username password] [KEYS key ...]
+		result := make([][]byte, len(args))
+		copy(result, args)
+		for i := 6; i < len(args); i++ {
+			switch strings.ToLower(string(args[i])) {
+			case "auth":
+				if i+1 < len(args) {
+					result[i+1] = Redacted
+				}
+				i++
+			case "auth2":
+				for j := i + 1; j < len(args) && j <= i+2; j++ {
+					result[j] = Redacted
+				}
+				i += 2
+			case "keys":
+				return result
+			}
+		}
+		return result
+	case "cluster":
+		// CLUSTER PEERAUTH token，由集群中的其他节点发送
+		if len(args) > 2 && strings.EqualFold(string(args[1]), "peerauth") {
+			result := make([][]byte, len(args))
+			copy(result, args)
+			for i := 2; i < len(args); i++ {
+				result[i] = Redacted
+			}
+			return result
+		}
+	}
+	return args
+}
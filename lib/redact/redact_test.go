@@ -0,0 +1,41 @@
+package redact
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestArgs(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"GET key", "GET key"},
+		{"AUTH secret", "AUTH (redacted)"},
+		{"auth user secret", "auth (redacted) (redacted)"},
+		{"HELLO 3 AUTH user secret SETNAME app", "HELLO 3 AUTH (redacted) (redacted) SETNAME app"},
+		{"MIGRATE host 6379 key 0 1000 COPY AUTH secret", "MIGRATE host 6379 key 0 1000 COPY AUTH (redacted)"},
+		{"MIGRATE host 6379 key 0 1000 AUTH2 user secret", "MIGRATE host 6379 key 0 1000 AUTH2 (redacted) (redacted)"},
+		// KEYS 之后的参数是 key 名，不是凭据
+		{"MIGRATE host 6379 \"\" 0 1000 KEYS auth secret", "MIGRATE host 6379 \"\" 0 1000 KEYS auth secret"},
+		{"CLUSTER PEERAUTH token", "CLUSTER PEERAUTH (redacted)"},
+		{"CLUSTER NODES", "CLUSTER NODES"},
+	}
+	for _, tt := range tests {
+		args := make([][]byte, 0)
+		for _, field := range strings.Fields(tt.cmd) {
+			args = append(args, []byte(field))
+		}
+		got := make([]string, 0, len(args))
+		for _, arg := range Args(args) {
+			got = append(got, string(arg))
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("Args(%q) = %q, want %q", tt.cmd, strings.Join(got, " "), tt.want)
+		}
+		if string(bytes.Join(args, []byte(" "))) != tt.cmd {
+			t.Errorf("Args(%q) modified its input", tt.cmd)
+		}
+	}
+}
@@ -4,19 +4,17 @@ import (
 	"fmt"
 	"go-redis/config"
 	"go-redis/lib/logger"
+	"go-redis/lib/metrics"
+	"go-redis/resp/client"
 	"go-redis/resp/handler"
 	"go-redis/tcp"
 	"os"
+	"strconv"
+	"time"
 )
 
 const configFile string = "redis.conf"
 
-var defaultProperties = &config.ServerProperties{
-	Bind:      "0.0.0.0",
-	Port:      6379,
-	Databases: 16,
-}
-
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	return err == nil && !info.IsDir()
@@ -30,13 +28,57 @@ func main() {
 		TimeFormat: "2006-01-02",
 	})
 
+	// 没有配置文件时使用 config 包中的默认配置
 	if fileExists(configFile) {
 		config.SetupConfig(configFile)
-	} else {
-		config.Properties = defaultProperties
 	}
 
-	err := tcp.ListenAndServeWithSignal(&tcp.Config{Address: fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port)}, handler.MakeHandler())
+	if config.Properties.MetricsPort > 0 {
+		go func() {
+			addr := fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.MetricsPort)
+			if err := metrics.ListenAndServe(addr); err != nil {
+				logger.Error("metrics server: " + err.Error())
+			}
+		}()
+	}
+
+	cfg := &tcp.Config{
+		KeepAlive: time.Duration(config.Properties.TCPKeepalive) * time.Second,
+	}
+	// 与 Redis 相同，port 为 0 时不监听明文 TCP 端口，只通过 TLS 端口或 Unix socket 提供服务
+	if config.Properties.Port > 0 {
+		cfg.Address = fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port)
+	} else if config.Properties.TLSPort <= 0 && config.Properties.UnixSocket == "" {
+		logger.Fatal("port is 0 and neither tls-port nor unixsocket is set, there is nothing to listen on")
+	}
+	if config.Properties.TLSPort > 0 {
+		tlsConfig, err := config.Properties.TLSServerConfig()
+		if err != nil {
+			logger.Fatal("tls: " + err.Error())
+		}
+		cfg.TLSAddress = fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.TLSPort)
+		cfg.TLSConfig = tlsConfig
+	}
+	if config.Properties.UnixSocket != "" {
+		cfg.UnixSocket = config.Properties.UnixSocket
+		if config.Properties.UnixSocketPerm != "" {
+			perm, err := strconv.ParseUint(config.Properties.UnixSocketPerm, 8, 32)
+			if err != nil || perm > 0777 {
+				logger.Fatal("invalid unixsocketperm: " + config.Properties.UnixSocketPerm)
+			}
+			cfg.UnixSocketPerm = os.FileMode(perm)
+		}
+	}
+	// 集群节点之间以及 MIGRATE 的连接使用 TLS，需要在创建集群之前设置
+	if config.Properties.TLSCluster {
+		tlsConfig, err := config.Properties.TLSClientConfig()
+		if err != nil {
+			logger.Fatal("tls: " + err.Error())
+		}
+		client.SetTLSConfig(tlsConfig)
+	}
+
+	err := tcp.ListenAndServeWithSignal(cfg, handler.MakeHandler())
 	if err != nil {
 		logger.Error(err.Error())
 	}
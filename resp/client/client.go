@@ -1,68 +1,132 @@
 package client
 
 import (
+	"crypto/tls"
+	"errors"
 	"go-redis/interface/resp"
 	"go-redis/lib/logger"
 	"go-redis/lib/sync/wait"
 	"go-redis/resp/parser"
 	"go-redis/resp/reply"
 	"net"
-	"runtime/debug"
+	"strconv"
 	"sync"
 	"time"
 )
 
 // Client 是一个使用管道模式的 Redis 客户端
 type Client struct {
-	conn        net.Conn
+	mu          sync.Mutex    // 保护 conn 的替换
+	conn        *connection   // 当前连接，出错关闭后由写协程在发送下一个请求前重新建立
 	pendingReqs chan *request // 待发送的请求
-	waitingReqs chan *request // 等待响应的请求
 	ticker      *time.Ticker
 	addr        string
+	timeout     time.Duration   // 建立连接及等待响应的超时时间
+	selectedDB  int             // 连接当前选择的数据库，只在写协程中访问
+	username    string          // AUTH 使用的用户名，为空时只发送密码
+	password    string          // 不为空时，每个新连接在第一个请求之前先发送 AUTH
+	handshake   func() [][]byte // 不为 nil 时，每个新连接在 AUTH 之后发送它返回的命令
+	authed      bool            // 当前连接是否已发送 AUTH 及握手命令，只在写协程中访问
 
 	working *sync.WaitGroup // 计数器，表示未完成的请求（包括待发送和等待响应的）
 }
 
+// connection 是到服务器的一条连接及已写入该连接、等待响应的请求
+// 读取响应出错或协议错误后连接不再可用：连接被关闭，所有等待响应的请求以失败结束，不会继续读取
+type connection struct {
+	conn    net.Conn
+	mu      sync.Mutex
+	waiting []*request // 按发送顺序排列
+	closed  bool
+}
+
 // request 是发送到 Redis 服务器的消息
 type request struct {
 	id        uint64
 	args      [][]byte
 	reply     resp.Reply
 	heartbeat bool
+	dbIndex   int // 需要在哪个数据库上执行，为负数时不关心当前数据库
 	waiting   *wait.Wait
 	err       error
 }
 
+// Future 是一个已发送但可能尚未返回结果的请求
+type Future struct {
+	client  *Client
+	request *request
+	once    sync.Once
+}
+
 const (
 	chanSize = 256
 	maxWait  = 3 * time.Second
 )
 
+// tlsConfig 不为 nil 时使用 TLS 连接服务器
+var tlsConfig *tls.Config
+
+// SetTLSConfig 设置连接服务器使用的 TLS 配置，开启 tls-cluster 时由 main 在启动时设置，
+// 集群节点之间的连接、集群总线与 MIGRATE 都会使用 TLS
+func SetTLSConfig(cfg *tls.Config) {
+	tlsConfig = cfg
+}
+
+// Dial 连接 addr，设置了 TLS 配置时完成 TLS 握手后返回
+func Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	if tlsConfig == nil {
+		return net.DialTimeout("tcp", addr, timeout)
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, tlsConfig)
+}
+
+var (
+	// ErrTimeout 表示在超时时间内没有收到响应
+	ErrTimeout = errors.New("server time out")
+	// ErrRequestFailed 表示请求未能发送到服务器
+	ErrRequestFailed = errors.New("request failed")
+)
+
 // MakeClient 创建一个新的客户端
 func MakeClient(addr string) (*Client, error) {
-	conn, err := net.Dial("tcp", addr)
+	return MakeClientWithTimeout(addr, maxWait)
+}
+
+// MakeClientWithTimeout 创建一个新的客户端，timeout 同时用于建立连接和 Send 等待响应
+func MakeClientWithTimeout(addr string, timeout time.Duration) (*Client, error) {
+	if timeout <= 0 {
+		timeout = maxWait
+	}
+	conn, err := Dial(addr, timeout)
 	if err != nil {
 		return nil, err
 	}
 	return &Client{
 		addr:        addr,
-		conn:        conn,
+		timeout:     timeout,
+		conn:        &connection{conn: conn},
 		pendingReqs: make(chan *request, chanSize),
-		waitingReqs: make(chan *request, chanSize),
 		working:     &sync.WaitGroup{},
 	}, nil
 }
 
+// SetAuth 设置连接服务器使用的用户名和密码，username 可以为空，需要在 Start 之前调用
+func (client *Client) SetAuth(username, password string) {
+	client.username = username
+	client.password = password
+}
+
+// SetHandshake 设置每个新连接在 AUTH 之后、第一个请求之前发送的命令，需要在 Start 之前调用
+// handshake 在建立连接后调用，返回 nil 时不发送，其响应不会返回给调用方
+func (client *Client) SetHandshake(handshake func() [][]byte) {
+	client.handshake = handshake
+}
+
 // Start 启动异步协程
 func (client *Client) Start() {
 	client.ticker = time.NewTicker(10 * time.Second)
 	go client.handleWrite()
-	go func() {
-		err := client.handleRead()
-		if err != nil {
-			logger.Error(err)
-		}
-	}()
+	go client.handleRead(client.conn)
 	go client.heartbeat()
 }
 
@@ -76,31 +140,27 @@ func (client *Client) Close() {
 	client.working.Wait()
 
 	// 清理
-	_ = client.conn.Close()
-	close(client.waitingReqs)
+	client.mu.Lock()
+	cc := client.conn
+	client.mu.Unlock()
+	cc.fail(net.ErrClosed)
 }
 
-// handleConnectionError 处理连接错误，重新建立连接
-func (client *Client) handleConnectionError(err error) error {
-	err1 := client.conn.Close()
-	if err1 != nil {
-		if opErr, ok := err1.(*net.OpError); ok {
-			if opErr.Err.Error() != "use of closed network connection" {
-				return err1
-			}
-		} else {
-			return err1
-		}
-	}
-	conn, err1 := net.Dial("tcp", client.addr)
-	if err1 != nil {
-		logger.Error(err1)
-		return err1
+// reconnect 在当前连接出错关闭后重新建立连接，只在写协程中调用
+func (client *Client) reconnect() error {
+	conn, err := Dial(client.addr, client.timeout)
+	if err != nil {
+		logger.Error(err)
+		return err
 	}
-	client.conn = conn
-	go func() {
-		_ = client.handleRead()
-	}()
+	cc := &connection{conn: conn}
+	client.mu.Lock()
+	client.conn = cc
+	client.mu.Unlock()
+	// 新连接默认使用 0 号数据库，且需要重新认证
+	client.selectedDB = 0
+	client.authed = false
+	go client.handleRead(cc)
 	return nil
 }
 
@@ -120,23 +180,47 @@ func (client *Client) handleWrite() {
 
 // Send 发送一个请求到 Redis 服务器
 func (client *Client) Send(args [][]byte) resp.Reply {
+	return client.SendAsync(-1, args).Get(client.timeout)
+}
+
+// SendAsync 将请求加入发送队列后立即返回，请求会在 dbIndex 指定的数据库上执行
+// 客户端记录连接当前选择的数据库，只在需要切换时才在请求前插入 SELECT，多个调用方可以共享同一个客户端以管道方式发送请求
+// 调用方必须调用返回的 Future 的 Get 方法
+func (client *Client) SendAsync(dbIndex int, args [][]byte) *Future {
 	request := &request{
 		args:      args,
 		heartbeat: false,
+		dbIndex:   dbIndex,
 		waiting:   &wait.Wait{},
 	}
 	request.waiting.Add(1)
 	client.working.Add(1)
-	defer client.working.Done()
 	client.pendingReqs <- request
-	timeout := request.waiting.WaitWithTimeout(maxWait)
-	if timeout {
-		return reply.MakeErrReply("server time out")
+	return &Future{
+		client:  client,
+		request: request,
+	}
+}
+
+// Get 等待请求的结果，超过 timeout 仍未返回时返回超时错误
+func (f *Future) Get(timeout time.Duration) resp.Reply {
+	result, err := f.Result(timeout)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return result
+}
+
+// Result 等待请求的结果，与 Get 不同的是超时或发送失败以 error 返回，便于调用方区分网络故障与服务器返回的错误回复
+func (f *Future) Result(timeout time.Duration) (resp.Reply, error) {
+	defer f.once.Do(f.client.working.Done)
+	if timeout <= 0 || f.request.waiting.WaitWithTimeout(timeout) {
+		return nil, ErrTimeout
 	}
-	if request.err != nil {
-		return reply.MakeErrReply("request failed")
+	if f.request.err != nil {
+		return nil, ErrRequestFailed
 	}
-	return request.reply
+	return f.request.reply, nil
 }
 
 // doHeartbeat 执行心跳请求
@@ -144,6 +228,7 @@ func (client *Client) doHeartbeat() {
 	request := &request{
 		args:      [][]byte{[]byte("PING")},
 		heartbeat: true,
+		dbIndex:   -1,
 		waiting:   &wait.Wait{},
 	}
 	request.waiting.Add(1)
@@ -154,56 +239,150 @@ func (client *Client) doHeartbeat() {
 }
 
 // doRequest 执行实际的请求发送
+// 请求在写入连接之前加入该连接的等待队列，写入失败时连接被关闭，请求与队列中的其他请求一同失败；
+// 请求可能已部分写入，因此不会在新连接上重发
 func (client *Client) doRequest(req *request) {
 	if req == nil || len(req.args) == 0 {
 		return
 	}
-	re := reply.MakeMultiBulkReply(req.args)
-	bytes := re.ToBytes()
-	_, err := client.conn.Write(bytes)
-	i := 0
-	for err != nil && i < 3 {
-		err = client.handleConnectionError(err)
-		if err == nil {
-			_, err = client.conn.Write(bytes)
+	cc := client.conn
+	if cc.isClosed() {
+		if err := client.reconnect(); err != nil {
+			req.err = err
+			req.waiting.Done()
+			return
 		}
-		i++
+		cc = client.conn
 	}
-	if err == nil {
-		client.waitingReqs <- req
-	} else {
-		req.err = err
+	internalReqs, buf := client.buildRequest(req)
+	if !cc.enqueue(append(internalReqs, req)) {
+		// 连接在加入队列前已被读协程关闭，请求尚未写入，由下一个请求重新建立连接
+		req.err = ErrRequestFailed
 		req.waiting.Done()
+		return
+	}
+	if _, err := cc.conn.Write(buf); err != nil {
+		cc.fail(err)
 	}
 }
 
-// finishRequest 完成请求，将响应放入等待队列
-func (client *Client) finishRequest(reply resp.Reply) {
-	defer func() {
-		if err := recover(); err != nil {
-			debug.PrintStack()
-			logger.Error(err)
+// buildRequest 编码请求，新连接尚未认证时在请求之前插入 AUTH 及握手命令，
+// 连接当前选择的数据库与请求不一致时在请求之前插入 SELECT
+// 返回插入的内部请求，它们的响应不会返回给调用方
+func (client *Client) buildRequest(req *request) ([]*request, []byte) {
+	var internalReqs []*request
+	var buf []byte
+	if client.password != "" && !client.authed {
+		authReq := &request{
+			args:    [][]byte{[]byte("AUTH"), []byte(client.password)},
+			dbIndex: -1,
+		}
+		if client.username != "" {
+			authReq.args = [][]byte{[]byte("AUTH"), []byte(client.username), []byte(client.password)}
+		}
+		internalReqs = append(internalReqs, authReq)
+		buf = append(buf, reply.MakeMultiBulkReply(authReq.args).ToBytes()...)
+	}
+	if client.handshake != nil && !client.authed {
+		if args := client.handshake(); len(args) > 0 {
+			handshakeReq := &request{
+				args:    args,
+				dbIndex: -1,
+			}
+			internalReqs = append(internalReqs, handshakeReq)
+			buf = append(buf, reply.MakeMultiBulkReply(handshakeReq.args).ToBytes()...)
+		}
+	}
+	if req.dbIndex >= 0 && req.dbIndex != client.selectedDB {
+		selectReq := &request{
+			args:    [][]byte{[]byte("SELECT"), []byte(strconv.Itoa(req.dbIndex))},
+			dbIndex: req.dbIndex,
+		}
+		internalReqs = append(internalReqs, selectReq)
+		buf = append(buf, reply.MakeMultiBulkReply(selectReq.args).ToBytes()...)
+	}
+	buf = append(buf, reply.MakeMultiBulkReply(req.args).ToBytes()...)
+	// 写入失败时连接会被关闭，重新建立连接时重置这些状态
+	client.authed = true
+	if req.dbIndex >= 0 {
+		client.selectedDB = req.dbIndex
+	}
+	return internalReqs, buf
+}
+
+// handleRead 处理从服务器接收的响应，连接出错或收到无法解析的数据时关闭连接并结束
+func (client *Client) handleRead(cc *connection) {
+	reader := parser.NewReader(cc.conn)
+	defer reader.Release()
+	for {
+		result, err := reader.ReadReply()
+		if err != nil {
+			// 协议错误之后的数据无法与请求对应，不能继续读取
+			cc.fail(err)
+			return
 		}
-	}()
-	request := <-client.waitingReqs
-	if request == nil {
+		cc.finish(result)
+	}
+}
+
+// isClosed 返回连接是否已关闭
+func (cc *connection) isClosed() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.closed
+}
+
+// enqueue 将即将写入连接的请求加入等待队列，连接已关闭时返回 false
+func (cc *connection) enqueue(reqs []*request) bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.closed {
+		return false
+	}
+	cc.waiting = append(cc.waiting, reqs...)
+	return true
+}
+
+// finish 以收到的响应完成队列中最早的请求
+func (cc *connection) finish(result resp.Reply) {
+	cc.mu.Lock()
+	if len(cc.waiting) == 0 {
+		cc.mu.Unlock()
+		logger.Warn("unexpected reply: " + string(result.ToBytes()))
 		return
 	}
-	request.reply = reply
-	if request.waiting != nil {
-		request.waiting.Done()
+	req := cc.waiting[0]
+	cc.waiting[0] = nil
+	cc.waiting = cc.waiting[1:]
+	cc.mu.Unlock()
+
+	req.reply = result
+	if req.waiting != nil {
+		req.waiting.Done()
+	} else if errReply, ok := result.(interface{ Error() string }); ok {
+		// 插入的 AUTH、握手命令、SELECT 没有调用方等待，失败时只记录日志
+		logger.Warn(string(req.args[0]) + " failed: " + errReply.Error())
 	}
 }
 
-// handleRead 处理从服务器接收的响应
-func (client *Client) handleRead() error {
-	ch := parser.ParseStream(client.conn)
-	for payload := range ch {
-		if payload.Err != nil {
-			client.finishRequest(reply.MakeErrReply(payload.Err.Error()))
+// fail 关闭连接，队列中所有等待响应的请求以 err 失败，重复调用时不做任何事
+func (cc *connection) fail(err error) {
+	cc.mu.Lock()
+	if cc.closed {
+		cc.mu.Unlock()
+		return
+	}
+	cc.closed = true
+	reqs := cc.waiting
+	cc.waiting = nil
+	cc.mu.Unlock()
+
+	_ = cc.conn.Close()
+	for _, req := range reqs {
+		if req.waiting == nil {
 			continue
 		}
-		client.finishRequest(payload.Data)
+		req.err = err
+		req.waiting.Done()
 	}
-	return nil
 }
@@ -2,20 +2,98 @@ package connection
 
 import (
 	"bytes"
+	"errors"
+	"go-redis/lib/logger"
 	"go-redis/lib/sync/wait"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// flushThreshold 输出缓冲区超过该大小时立即写出，不再等待读取下一批命令
+	flushThreshold = 64 * 1024
+	// maxSpareBuffer 写出后复用的缓冲区的最大容量，更大的缓冲区交给 GC 回收
+	maxSpareBuffer = 1 << 20
+)
+
+// ErrOutputBufferLimit 表示连接的输出缓冲区超过了限制，连接已被关闭
+var ErrOutputBufferLimit = errors.New("output buffer limit reached")
+
+// connectionID 用于为每个连接分配递增的 ID
+var connectionID uint64
+
+// OutputBufferLimit 是 client-output-buffer-limit 中一类客户端的限制，为 0 的项不做限制
+// 待写出的数据超过 Hard，或者持续 SoftSeconds 超过 Soft 时关闭连接
+type OutputBufferLimit struct {
+	Hard        int64
+	Soft        int64
+	SoftSeconds time.Duration
+}
+
 type Connection struct {
 	conn         net.Conn
 	waitingReply wait.Wait
-	mu           sync.Mutex
-	selectedDB   int
+	selectedDB   int32 // 原子访问，CLIENT LIST 会在其他协程中读取
+
+	// mu 保护输出缓冲区相关的字段
+	mu       sync.Mutex
+	out      []byte // 等待写出的数据
+	spare    []byte // 写出完成后复用的缓冲区
+	pending  int64  // 已缓冲但尚未写出的字节数，包括正在写出的数据
+	flushing bool   // 是否有协程正在写出，其他协程追加的数据由它一并写出
+	// flushDone 在写出结束、flushing 变为 false 时广播，使用 mu 作为锁，在第一次等待时初始化
+	flushDone sync.Cond
+	writeErr  error // 写出失败或超过输出缓冲区限制后，之后的写入都返回该错误
+
+	outputLimit    OutputBufferLimit
+	softLimitSince time.Time // 开始持续超过软限制的时间
+
+	unixSocket string // 通过 Unix socket 连接时为 socket 的路径
+
+	id        uint64
+	createdAt time.Time
+	// 最近一次执行命令的时间，unix 纳秒，原子访问
+	lastInteraction int64
+	// 开始等待客户端发送下一条命令的时间（UnixNano），为 0 表示没有在等待，原子访问
+	waitingSince int64
+	noEvict      atomic.Bool
+	// CLIENT KILL 关闭自身连接时，在回复发出后再关闭
+	closeAfterReply atomic.Bool
+	// HELLO 协商的协议版本，0 表示 RESP2
+	protocol      int32
+	authenticated atomic.Bool
+	// 是否为通过 CLUSTER PEERAUTH 认证的集群节点连接
+	clusterPeer atomic.Bool
+
+	// infoMu 保护以下可被 CLIENT 命令修改或读取的字段
+	infoMu  sync.Mutex
+	name    string
+	lastCmd string
+	user    string
+}
+
+// unixSocketAddr 是 Unix socket 连接两端的地址，与 Redis 相同显示为 socket 路径加端口 0
+type unixSocketAddr string
+
+func (a unixSocketAddr) Network() string {
+	return "unix"
+}
+
+func (a unixSocketAddr) String() string {
+	return string(a) + ":0"
 }
 
+// RemoteAddr 返回客户端地址，没有网络连接时（如 FakeConn）返回 nil
 func (c *Connection) RemoteAddr() net.Addr {
+	if c.conn == nil {
+		return nil
+	}
+	if c.unixSocket != "" {
+		return unixSocketAddr(c.unixSocket)
+	}
 	return c.conn.RemoteAddr()
 }
 
@@ -25,34 +103,353 @@ func (c *Connection) Close() error {
 	return nil
 }
 
+// Read 从连接中读取数据，阻塞读取之前先写出缓冲的回复，使管道中的多个回复合并为一次写出
+func (c *Connection) Read(p []byte) (int, error) {
+	if err := c.Flush(); err != nil {
+		return 0, err
+	}
+	return c.conn.Read(p)
+}
+
+// InterruptRead 使阻塞中及之后的读取立即返回错误，不影响写出，关闭服务器时用于停止接收新的命令
+func (c *Connection) InterruptRead() {
+	if c.conn != nil {
+		_ = c.conn.SetReadDeadline(time.Now())
+	}
+}
+
+// Write 将数据追加到输出缓冲区并立即写出
 func (c *Connection) Write(bytes []byte) error {
 	if len(bytes) == 0 {
 		return nil
 	}
+	c.mu.Lock()
+	err := c.appendLocked(bytes)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.Flush()
+}
 
+// Buffer 将数据追加到输出缓冲区，缓冲的数据超过 flushThreshold 时才写出，
+// 否则在下一次阻塞读取之前或调用 Flush 时写出
+func (c *Connection) Buffer(bytes []byte) error {
+	if len(bytes) == 0 {
+		return nil
+	}
 	c.mu.Lock()
-	c.waitingReply.Add(1)
-	defer func() {
-		c.waitingReply.Done()
+	err := c.appendLocked(bytes)
+	full := len(c.out) >= flushThreshold
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if full {
+		return c.Flush()
+	}
+	return nil
+}
+
+// WriteAsync 将数据追加到输出缓冲区并在后台协程中写出，调用方不会因为客户端读取缓慢而阻塞，
+// 用于 MONITOR 等向其他连接推送的数据，积压的数据受输出缓冲区限制约束
+func (c *Connection) WriteAsync(bytes []byte) error {
+	if len(bytes) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	if err := c.appendLocked(bytes); err != nil {
 		c.mu.Unlock()
+		return err
+	}
+	if c.flushing {
+		c.mu.Unlock()
+		return nil
+	}
+	c.flushing = true
+	c.mu.Unlock()
+	go func() {
+		c.mu.Lock()
+		_ = c.flushLocked()
 	}()
+	return nil
+}
+
+// Flush 写出输出缓冲区中的数据，返回时之前追加的数据都已写出
+// 如果其他协程正在写出，数据由该协程一并写出，Flush 等待它结束
+func (c *Connection) Flush() error {
+	c.mu.Lock()
+	if c.flushing {
+		if c.flushDone.L == nil {
+			c.flushDone.L = &c.mu
+		}
+		for c.flushing {
+			c.flushDone.Wait()
+		}
+	}
+	if len(c.out) == 0 || c.writeErr != nil {
+		err := c.writeErr
+		c.mu.Unlock()
+		return err
+	}
+	c.flushing = true
+	return c.flushLocked()
+}
+
+// flushLocked 循环写出输出缓冲区直到为空，调用方需持有 mu 并已将 flushing 置为 true，返回时释放 mu
+func (c *Connection) flushLocked() error {
+	c.waitingReply.Add(1)
+	defer c.waitingReply.Done()
+	for {
+		data := c.out
+		c.out = c.spare
+		c.spare = nil
+		c.mu.Unlock()
+
+		_, err := c.conn.Write(data)
+
+		c.mu.Lock()
+		c.pending -= int64(len(data))
+		if cap(data) <= maxSpareBuffer {
+			c.spare = data[:0]
+		}
+		if err != nil && c.writeErr == nil {
+			c.writeErr = err
+		}
+		if c.writeErr != nil || len(c.out) == 0 {
+			c.flushing = false
+			c.flushDone.Broadcast()
+			err = c.writeErr
+			c.mu.Unlock()
+			return err
+		}
+	}
+}
+
+// appendLocked 追加数据到输出缓冲区并检查输出缓冲区限制，调用方需持有 mu
+func (c *Connection) appendLocked(bytes []byte) error {
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+	c.out = append(c.out, bytes...)
+	c.pending += int64(len(bytes))
+	if c.overOutputLimitLocked() {
+		addr := ""
+		if remote := c.RemoteAddr(); remote != nil {
+			addr = remote.String()
+		}
+		logger.Warn("client id=" + strconv.FormatUint(c.id, 10) + " addr=" + addr +
+			" closed for overcoming of output buffer limits")
+		c.writeErr = ErrOutputBufferLimit
+		c.out = nil
+		// 直接关闭底层连接，阻塞中的读取与写出都会返回错误
+		if c.conn != nil {
+			_ = c.conn.Close()
+		}
+		return c.writeErr
+	}
+	return nil
+}
+
+func (c *Connection) overOutputLimitLocked() bool {
+	limit := c.outputLimit
+	if limit.Hard > 0 && c.pending >= limit.Hard {
+		return true
+	}
+	if limit.Soft > 0 && c.pending >= limit.Soft {
+		if c.softLimitSince.IsZero() {
+			c.softLimitSince = time.Now()
+			return false
+		}
+		return time.Since(c.softLimitSince) >= limit.SoftSeconds
+	}
+	c.softLimitSince = time.Time{}
+	return false
+}
 
-	_, err := c.conn.Write(bytes)
-	return err
+// SetOutputBufferLimit 设置连接的输出缓冲区限制
+func (c *Connection) SetOutputBufferLimit(limit OutputBufferLimit) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.outputLimit = limit
+	c.softLimitSince = time.Time{}
+}
+
+// OutputBufferLength 返回已缓冲但尚未写出的字节数
+func (c *Connection) OutputBufferLength() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pending
 }
 
 func (c *Connection) GetDBIndex() int {
-	return c.selectedDB
+	return int(atomic.LoadInt32(&c.selectedDB))
 }
 
 func (c *Connection) SelectDB(i int) {
-	c.selectedDB = i
+	atomic.StoreInt32(&c.selectedDB, int32(i))
+}
+
+// LocalAddr 返回服务端地址，没有网络连接时返回 nil
+func (c *Connection) LocalAddr() net.Addr {
+	if c.conn == nil {
+		return nil
+	}
+	if c.unixSocket != "" {
+		return unixSocketAddr(c.unixSocket)
+	}
+	return c.conn.LocalAddr()
+}
+
+// IsUnixSocket 返回连接是否来自 Unix socket
+func (c *Connection) IsUnixSocket() bool {
+	return c.unixSocket != ""
+}
+
+// ID 返回连接的唯一 ID
+func (c *Connection) ID() uint64 {
+	return c.id
+}
+
+// CreatedAt 返回连接建立的时间
+func (c *Connection) CreatedAt() time.Time {
+	return c.createdAt
+}
+
+// Name 返回 CLIENT SETNAME 设置的名称
+func (c *Connection) Name() string {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	return c.name
+}
+
+// SetName 设置连接的名称
+func (c *Connection) SetName(name string) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	c.name = name
+}
+
+// User 返回连接认证的用户名，未认证时为 default
+func (c *Connection) User() string {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	if c.user == "" {
+		return "default"
+	}
+	return c.user
+}
+
+// SetUser 设置连接认证的用户名
+func (c *Connection) SetUser(user string) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	c.user = user
+}
+
+// LastCommand 返回最近一次执行的命令名
+func (c *Connection) LastCommand() string {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	return c.lastCmd
+}
+
+// Touch 记录连接即将执行的命令，并更新最近一次交互的时间
+func (c *Connection) Touch(cmdName string) {
+	c.infoMu.Lock()
+	c.lastCmd = cmdName
+	c.infoMu.Unlock()
+	atomic.StoreInt64(&c.lastInteraction, time.Now().UnixNano())
+}
+
+// IdleTime 返回距离最近一次执行命令的时间
+func (c *Connection) IdleTime() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&c.lastInteraction)))
+}
+
+// SetWaitingCommand 在开始读取下一条命令前以 true 调用，读取完成后以 false 调用
+func (c *Connection) SetWaitingCommand(waiting bool) {
+	var since int64
+	if waiting {
+		since = time.Now().UnixNano()
+	}
+	atomic.StoreInt64(&c.waitingSince, since)
+}
+
+// WaitingTime 返回连接已经等待下一条命令多长时间，正在执行命令或等待 CLIENT PAUSE 时返回 0
+func (c *Connection) WaitingTime() time.Duration {
+	since := atomic.LoadInt64(&c.waitingSince)
+	if since == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, since))
+}
+
+// NoEvict 返回是否设置了 CLIENT NO-EVICT on
+func (c *Connection) NoEvict() bool {
+	return c.noEvict.Load()
+}
+
+// SetNoEvict 设置 CLIENT NO-EVICT
+func (c *Connection) SetNoEvict(noEvict bool) {
+	c.noEvict.Store(noEvict)
+}
+
+// Protocol 返回连接使用的协议版本，2 或 3
+func (c *Connection) Protocol() int {
+	if p := atomic.LoadInt32(&c.protocol); p != 0 {
+		return int(p)
+	}
+	return 2
+}
+
+// SetProtocol 设置 HELLO 协商的协议版本
+func (c *Connection) SetProtocol(protocol int) {
+	atomic.StoreInt32(&c.protocol, int32(protocol))
+}
+
+// Authenticated 返回连接是否已通过 AUTH 认证
+func (c *Connection) Authenticated() bool {
+	return c.authenticated.Load()
+}
+
+// SetAuthenticated 设置连接的认证状态
+func (c *Connection) SetAuthenticated(authenticated bool) {
+	c.authenticated.Store(authenticated)
+}
+
+// ClusterPeer 返回连接是否为集群中其他节点的连接
+func (c *Connection) ClusterPeer() bool {
+	return c.clusterPeer.Load()
+}
+
+// SetClusterPeer 标记连接是否为集群中其他节点的连接
+func (c *Connection) SetClusterPeer(peer bool) {
+	c.clusterPeer.Store(peer)
+}
+
+// SetCloseAfterReply 标记连接在当前命令的回复发出后关闭
+func (c *Connection) SetCloseAfterReply() {
+	c.closeAfterReply.Store(true)
+}
+
+// CloseAfterReply 返回连接是否需要在回复发出后关闭
+func (c *Connection) CloseAfterReply() bool {
+	return c.closeAfterReply.Load()
 }
 
 func NewConn(conn net.Conn) *Connection {
-	return &Connection{
-		conn: conn,
+	now := time.Now()
+	c := &Connection{
+		conn:            conn,
+		id:              atomic.AddUint64(&connectionID, 1),
+		createdAt:       now,
+		lastInteraction: now.UnixNano(),
 	}
+	if addr, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+		c.unixSocket = addr.Name
+	}
+	return c
 }
 
 // FakeConn 实现了用于测试的 redis.Connection 接口
@@ -67,6 +464,16 @@ func (c *FakeConn) Write(b []byte) error {
 	return nil
 }
 
+// Buffer 与 Write 相同，FakeConn 不需要写出
+func (c *FakeConn) Buffer(b []byte) error {
+	return c.Write(b)
+}
+
+// Flush FakeConn 没有需要写出的数据
+func (c *FakeConn) Flush() error {
+	return nil
+}
+
 // Clean 重置缓冲区
 func (c *FakeConn) Clean() {
 	c.buf.Reset()
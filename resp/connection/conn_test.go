@@ -0,0 +1,143 @@
+package connection
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// makePipeConn 返回服务端连接与客户端一侧，net.Pipe 没有缓冲，客户端不读取时写出会一直阻塞
+func makePipeConn(t *testing.T) (*Connection, net.Conn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+	return NewConn(server), client
+}
+
+func TestOutputBufferLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     OutputBufferLimit
+		writes    []int // 每次缓冲的字节数
+		wantErrAt int   // 第一次返回 ErrOutputBufferLimit 的写入，-1 表示都成功
+	}{
+		{"no limit", OutputBufferLimit{}, []int{1000, 1000, 1000}, -1},
+		{"under hard limit", OutputBufferLimit{Hard: 100}, []int{40, 40}, -1},
+		{"reach hard limit", OutputBufferLimit{Hard: 100}, []int{60, 40, 10}, 1},
+		{"over soft limit within soft seconds", OutputBufferLimit{Soft: 50, SoftSeconds: time.Hour}, []int{60, 60, 60}, -1},
+		{"over soft limit for soft seconds", OutputBufferLimit{Soft: 50}, []int{60, 10}, 1},
+		{"hard limit before soft seconds", OutputBufferLimit{Hard: 100, Soft: 50, SoftSeconds: time.Hour}, []int{60, 60}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, client := makePipeConn(t)
+			c.SetOutputBufferLimit(tt.limit)
+			for i, n := range tt.writes {
+				err := c.Buffer(bytes.Repeat([]byte{'x'}, n))
+				if i < tt.wantErrAt || tt.wantErrAt < 0 {
+					if err != nil {
+						t.Fatalf("write %d: unexpected error %v", i, err)
+					}
+					continue
+				}
+				if err != ErrOutputBufferLimit {
+					t.Fatalf("write %d: got %v, want ErrOutputBufferLimit", i, err)
+				}
+			}
+			if tt.wantErrAt < 0 {
+				return
+			}
+			// 超过限制后缓冲区被丢弃，之后的写入与写出都返回错误，底层连接被关闭
+			if err := c.Buffer([]byte("x")); err != ErrOutputBufferLimit {
+				t.Errorf("Buffer after the limit: got %v", err)
+			}
+			if err := c.Flush(); err != ErrOutputBufferLimit {
+				t.Errorf("Flush after the limit: got %v", err)
+			}
+			_ = client.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+				t.Errorf("client read: got %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+// TestOutputBufferSoftLimitReset 输出缓冲区回落到软限制以下后重新计时
+func TestOutputBufferSoftLimitReset(t *testing.T) {
+	c, client := makePipeConn(t)
+	c.SetOutputBufferLimit(OutputBufferLimit{Soft: 50, SoftSeconds: 0})
+	go func() {
+		_, _ = io.Copy(io.Discard, client)
+	}()
+	if err := c.Buffer(bytes.Repeat([]byte{'x'}, 60)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.OutputBufferLength(); n != 0 {
+		t.Fatalf("OutputBufferLength = %d after Flush", n)
+	}
+	if err := c.Buffer(bytes.Repeat([]byte{'x'}, 10)); err != nil {
+		t.Fatal(err)
+	}
+	// 重新开始超过软限制，不应立即关闭
+	if err := c.Buffer(bytes.Repeat([]byte{'x'}, 45)); err != nil {
+		t.Fatalf("got %v, the soft limit timer is not reset", err)
+	}
+}
+
+// TestFlushWaitsForAsyncWrite Flush 需要等待 WriteAsync 的后台协程写出之前追加的数据
+func TestFlushWaitsForAsyncWrite(t *testing.T) {
+	c, client := makePipeConn(t)
+	if err := c.WriteAsync([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Buffer([]byte(" world")); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Flush()
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Flush returned %v before the data is read", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	buf := make([]byte, len("hello world"))
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "hello world" {
+		t.Fatalf("client read %q, %v", buf, err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Flush did not return after the data is written")
+	}
+	if n := c.OutputBufferLength(); n != 0 {
+		t.Errorf("OutputBufferLength = %d after Flush", n)
+	}
+}
+
+// TestFlushReturnsAsyncWriteError 后台写出失败时 Flush 返回该错误
+func TestFlushReturnsAsyncWriteError(t *testing.T) {
+	c, client := makePipeConn(t)
+	_ = client.Close()
+	if err := c.WriteAsync([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != io.ErrClosedPipe {
+		t.Fatalf("Flush: got %v, want io.ErrClosedPipe", err)
+	}
+	if err := c.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Errorf("Write after the error: got %v", err)
+	}
+}
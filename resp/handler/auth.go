@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"go-redis/cluster"
+	"go-redis/config"
+	"go-redis/database"
+	"go-redis/interface/resp"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// defaultUser 是唯一的用户，目前不支持 ACL
+const defaultUser = "default"
+
+var (
+	errNoAuth    = reply.MakeErrReply("NOAUTH Authentication required.")
+	errWrongPass = reply.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+)
+
+// requireAuth 判断连接在执行 cmdName 之前是否需要先认证
+func requireAuth(client *connection.Connection, cmdName string) bool {
+	if config.Properties.RequirePass == "" || client.Authenticated() {
+		return false
+	}
+	// HELLO 可以携带 AUTH 选项，在 execHello 中检查
+	return cmdName != "auth" && cmdName != "hello"
+}
+
+// checkPassword 校验用户名和密码，未设置 requirepass 时 default 用户不需要密码
+func checkPassword(user, password string) bool {
+	if user != defaultUser {
+		return false
+	}
+	if config.Properties.RequirePass == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(config.Properties.RequirePass)) == 1
+}
+
+// AUTH [username] password
+func execAuth(client *connection.Connection, args [][]byte) resp.Reply {
+	var user, password string
+	switch len(args) {
+	case 2:
+		if config.Properties.RequirePass == "" {
+			return reply.MakeErrReply("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		}
+		user, password = defaultUser, string(args[1])
+	case 3:
+		user, password = string(args[1]), string(args[2])
+	default:
+		return reply.MakeArgNumErrReply("auth")
+	}
+	if !checkPassword(user, password) {
+		return errWrongPass
+	}
+	client.SetAuthenticated(true)
+	client.SetUser(user)
+	return reply.MakeOkReply()
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func (r *RespHandler) execHello(client *connection.Connection, args [][]byte) resp.Reply {
+	protocol := client.Protocol()
+	var user, password, name string
+	var hasAuth, hasName bool
+	if len(args) >= 2 {
+		version, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return reply.MakeErrReply("ERR Protocol version is not an integer or out of range")
+		}
+		if version != reply.Resp2 && version != reply.Resp3 {
+			return reply.MakeErrReply("NOPROTO unsupported protocol version")
+		}
+		protocol = version
+		for i := 2; i < len(args); i++ {
+			switch {
+			case strings.EqualFold(string(args[i]), "auth") && i+2 < len(args):
+				user, password, hasAuth = string(args[i+1]), string(args[i+2]), true
+				i += 2
+			case strings.EqualFold(string(args[i]), "setname") && i+1 < len(args):
+				name, hasName = string(args[i+1]), true
+				i++
+			default:
+				return reply.MakeErrReply("ERR Syntax error in HELLO option '" + string(args[i]) + "'")
+			}
+		}
+	}
+	if hasAuth {
+		if !checkPassword(user, password) {
+			return errWrongPass
+		}
+		client.SetAuthenticated(true)
+		client.SetUser(user)
+	} else if requireAuth(client, "") {
+		return reply.MakeErrReply("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+	if hasName {
+		if !validClientName(name) {
+			return reply.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		client.SetName(name)
+	}
+	client.SetProtocol(protocol)
+
+	mode := "standalone"
+	if _, ok := r.db.(*cluster.ClusterDatabase); ok {
+		mode = "cluster"
+	}
+	return reply.MakeMapReply().
+		AddString("server", reply.MakeBulkReply([]byte("redis"))).
+		AddString("version", reply.MakeBulkReply([]byte(database.RedisVersion))).
+		AddString("proto", reply.MakeIntReply(int64(protocol))).
+		AddString("id", reply.MakeIntReply(int64(client.ID()))).
+		AddString("mode", reply.MakeBulkReply([]byte(mode))).
+		AddString("role", reply.MakeBulkReply([]byte("master"))).
+		AddString("modules", reply.MakeEmptyMultiBulkReply())
+}
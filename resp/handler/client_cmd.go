@@ -0,0 +1,336 @@
+package handler
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execClient 执行 CLIENT 子命令，连接信息由 RespHandler 维护，因此在网络层处理
+func (r *RespHandler) execClient(client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("client")
+	}
+	subCmd := strings.ToUpper(string(args[1]))
+	switch {
+	case subCmd == "ID" && len(args) == 2:
+		return reply.MakeIntReply(int64(client.ID()))
+	case subCmd == "GETNAME" && len(args) == 2:
+		if name := client.Name(); name != "" {
+			return reply.MakeBulkReply([]byte(name))
+		}
+		return reply.MakeNullBulkReply()
+	case subCmd == "SETNAME" && len(args) == 3:
+		name := string(args[2])
+		if !validClientName(name) {
+			return reply.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		client.SetName(name)
+		return reply.MakeOkReply()
+	case subCmd == "INFO" && len(args) == 2:
+		return reply.MakeVerbatimReply("txt", []byte(r.clientInfo(client)+"\n"))
+	case subCmd == "LIST":
+		return r.execClientList(args[2:])
+	case subCmd == "KILL":
+		return r.execClientKill(client, args[2:])
+	case subCmd == "PAUSE" && (len(args) == 3 || len(args) == 4):
+		return r.execClientPause(args[2:])
+	case subCmd == "UNPAUSE" && len(args) == 2:
+		r.pause.unpause()
+		return reply.MakeOkReply()
+	case subCmd == "NO-EVICT" && len(args) == 3:
+		switch strings.ToLower(string(args[2])) {
+		case "on":
+			client.SetNoEvict(true)
+		case "off":
+			client.SetNoEvict(false)
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+		return reply.MakeOkReply()
+	case subCmd == "HELP" && len(args) == 2:
+		return reply.MakeHelpReply([]string{
+			"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"GETNAME",
+			"    Return the name of the current connection.",
+			"ID",
+			"    Return the ID of the current connection.",
+			"INFO",
+			"    Return information about the current client connection.",
+			"KILL <ip:port>",
+			"    Kill connection made from <ip:port>.",
+			"KILL <option> <value> [<option> <value> [...]]",
+			"    Kill connections. Options are:",
+			"    * ADDR (<ip:port>|<unixsocket>:0)",
+			"      Kill connections made from the specified address",
+			"    * LADDR (<ip:port>|<unixsocket>:0)",
+			"      Kill connections made to specified local address",
+			"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+			"      Kill connections by type.",
+			"    * USER <username>",
+			"      Kill connections authenticated by <username>.",
+			"    * SKIPME (YES|NO)",
+			"      Skip killing current connection (default: yes).",
+			"    * ID <client-id>",
+			"      Kill connections by client id.",
+			"LIST [options ...]",
+			"    Return information about client connections. Options:",
+			"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+			"      Return clients of specified type.",
+			"    * ID <client-id> [<client-id> ...]",
+			"      Return clients of specified IDs only.",
+			"PAUSE <timeout> [WRITE|ALL]",
+			"    Suspend all, or just write, clients for <timeout> milliseconds.",
+			"UNPAUSE",
+			"    Stop the current client pause, resuming traffic.",
+			"SETNAME <name>",
+			"    Assign the name <name> to the current connection.",
+			"NO-EVICT (ON|OFF)",
+			"    Protect current client connection from eviction.",
+			"HELP",
+			"    Prints this help.",
+		})
+	}
+	return reply.MakeErrReply("ERR unknown subcommand or wrong number of arguments for '" + string(args[1]) + "'. Try CLIENT HELP.")
+}
+
+// validClientName 与 Redis 相同，名称只能包含空格以外的可打印字符
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// clients 返回按 ID 排序的所有连接
+func (r *RespHandler) clients() []*connection.Connection {
+	result := make([]*connection.Connection, 0)
+	r.activeConn.Range(func(key, value any) bool {
+		result = append(result, key.(*connection.Connection))
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID() < result[j].ID()
+	})
+	return result
+}
+
+func addrString(addr interface{ String() string }) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// clientInfo 生成 CLIENT LIST 中的一行，字段与 Redis 相同，不支持的字段取默认值
+func (r *RespHandler) clientInfo(client *connection.Connection) string {
+	flags := ""
+	if r.monitors.contains(client) {
+		flags += "O"
+	}
+	if client.NoEvict() {
+		flags += "e"
+	}
+	if client.IsUnixSocket() {
+		flags += "U"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	cmd := client.LastCommand()
+	if cmd == "" {
+		cmd = "NULL"
+	}
+	fields := []string{
+		"id=" + strconv.FormatUint(client.ID(), 10),
+		"addr=" + addrString(client.RemoteAddr()),
+		"laddr=" + addrString(client.LocalAddr()),
+		"name=" + client.Name(),
+		"age=" + strconv.FormatInt(int64(time.Since(client.CreatedAt())/time.Second), 10),
+		"idle=" + strconv.FormatInt(int64(client.IdleTime()/time.Second), 10),
+		"flags=" + flags,
+		"db=" + strconv.Itoa(client.GetDBIndex()),
+		"sub=0",
+		"psub=0",
+		"multi=-1",
+		"omem=" + strconv.FormatInt(client.OutputBufferLength(), 10),
+		"cmd=" + cmd,
+		"user=" + client.User(),
+		"resp=" + strconv.Itoa(client.Protocol()),
+	}
+	return strings.Join(fields, " ")
+}
+
+// clientType 返回连接的类型，目前只有普通连接，monitor 与 Redis 一样归为 normal
+func clientType(client *connection.Connection) string {
+	return "normal"
+}
+
+func validClientType(typ string) bool {
+	switch typ {
+	case "normal", "master", "replica", "slave", "pubsub":
+		return true
+	}
+	return false
+}
+
+// CLIENT LIST [TYPE type] [ID id [id ...]]
+func (r *RespHandler) execClientList(args [][]byte) resp.Reply {
+	var typ string
+	var ids map[uint64]bool
+	if len(args) > 0 {
+		switch strings.ToUpper(string(args[0])) {
+		case "TYPE":
+			if len(args) != 2 {
+				return reply.MakeSyntaxErrReply()
+			}
+			typ = strings.ToLower(string(args[1]))
+			if !validClientType(typ) {
+				return reply.MakeErrReply("ERR Unknown client type '" + string(args[1]) + "'")
+			}
+		case "ID":
+			if len(args) < 2 {
+				return reply.MakeSyntaxErrReply()
+			}
+			ids = make(map[uint64]bool)
+			for _, arg := range args[1:] {
+				id, err := strconv.ParseUint(string(arg), 10, 64)
+				if err != nil || id == 0 {
+					return reply.MakeErrReply("ERR Invalid client ID")
+				}
+				ids[id] = true
+			}
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	var builder strings.Builder
+	for _, client := range r.clients() {
+		if typ != "" && clientType(client) != typ {
+			continue
+		}
+		if ids != nil && !ids[client.ID()] {
+			continue
+		}
+		builder.WriteString(r.clientInfo(client))
+		builder.WriteByte('\n')
+	}
+	return reply.MakeVerbatimReply("txt", []byte(builder.String()))
+}
+
+// clientFilter 为 CLIENT KILL 的过滤条件，为空的条件不参与过滤
+type clientFilter struct {
+	id     uint64
+	addr   string
+	laddr  string
+	user   string
+	typ    string
+	skipMe bool
+}
+
+func (f *clientFilter) match(client *connection.Connection) bool {
+	return (f.id == 0 || client.ID() == f.id) &&
+		(f.addr == "" || addrString(client.RemoteAddr()) == f.addr) &&
+		(f.laddr == "" || addrString(client.LocalAddr()) == f.laddr) &&
+		(f.user == "" || client.User() == f.user) &&
+		(f.typ == "" || clientType(client) == f.typ)
+}
+
+// CLIENT KILL ip:port 或 CLIENT KILL <filter> <value> ...
+func (r *RespHandler) execClientKill(self *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	// 旧格式只按地址匹配，返回 OK 或错误
+	if len(args) == 1 {
+		filter := &clientFilter{addr: string(args[0])}
+		if r.killClients(self, filter) == 0 {
+			return reply.MakeErrReply("ERR No such client")
+		}
+		return reply.MakeOkReply()
+	}
+	if len(args)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	filter := &clientFilter{skipMe: true}
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch strings.ToUpper(string(args[i])) {
+		case "ID":
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || id == 0 {
+				return reply.MakeErrReply("ERR client-id should be greater than 0")
+			}
+			filter.id = id
+		case "ADDR":
+			filter.addr = value
+		case "LADDR":
+			filter.laddr = value
+		case "USER":
+			filter.user = value
+		case "TYPE":
+			filter.typ = strings.ToLower(value)
+			if !validClientType(filter.typ) {
+				return reply.MakeErrReply("ERR Unknown client type '" + value + "'")
+			}
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				filter.skipMe = true
+			case "no":
+				filter.skipMe = false
+			default:
+				return reply.MakeSyntaxErrReply()
+			}
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	return reply.MakeIntReply(int64(r.killClients(self, filter)))
+}
+
+// killClients 关闭所有匹配 filter 的连接，当前连接在回复发出后关闭，返回关闭的连接数
+func (r *RespHandler) killClients(self *connection.Connection, filter *clientFilter) int {
+	killed := 0
+	for _, client := range r.clients() {
+		if !filter.match(client) {
+			continue
+		}
+		if client == self {
+			if filter.skipMe {
+				continue
+			}
+			client.SetCloseAfterReply()
+		} else {
+			// 关闭连接后，该连接的 Handle 协程读取失败并完成清理
+			go func(client *connection.Connection) {
+				_ = client.Close()
+			}(client)
+		}
+		killed++
+	}
+	return killed
+}
+
+// CLIENT PAUSE timeout [WRITE|ALL]
+func (r *RespHandler) execClientPause(args [][]byte) resp.Reply {
+	timeout, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil || timeout < 0 {
+		return reply.MakeErrReply("ERR timeout is not an integer or out of range")
+	}
+	mode := pauseAll
+	if len(args) == 2 {
+		mode = strings.ToLower(string(args[1]))
+		if mode != pauseAll && mode != pauseWrite {
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	r.pause.pause(time.Now().Add(time.Duration(timeout)*time.Millisecond), mode)
+	return reply.MakeOkReply()
+}
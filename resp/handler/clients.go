@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"go-redis/lib/metrics"
+	"sync/atomic"
+)
+
+// ConnectedClients 返回当前的客户端连接数
+func (r *RespHandler) ConnectedClients() int {
+	return int(atomic.LoadInt32(&r.connectedClients))
+}
+
+// TotalConnectionsReceived 返回启动以来接受的客户端连接总数
+func (r *RespHandler) TotalConnectionsReceived() int64 {
+	return atomic.LoadInt64(&r.totalConnections)
+}
+
+// registerMetrics 注册客户端连接相关的指标
+func (r *RespHandler) registerMetrics() {
+	metrics.NewGaugeFunc("godis_connected_clients", "Number of client connections.", func() float64 {
+		return float64(r.ConnectedClients())
+	})
+	metrics.NewCounterFunc("godis_connections_received_total", "Number of connections accepted.", func() float64 {
+		return float64(r.TotalConnectionsReceived())
+	})
+}
+
+func (r *RespHandler) onConnect() {
+	atomic.AddInt32(&r.connectedClients, 1)
+	atomic.AddInt64(&r.totalConnections, 1)
+}
+
+func (r *RespHandler) onDisconnect() {
+	atomic.AddInt32(&r.connectedClients, -1)
+}
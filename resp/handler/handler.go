@@ -7,12 +7,12 @@ import (
 	"go-redis/config"
 	"go-redis/database"
 	databaseface "go-redis/interface/database"
+	"go-redis/interface/resp"
 	"go-redis/lib/logger"
 	"go-redis/lib/sync/atomic"
 	"go-redis/resp/connection"
 	"go-redis/resp/parser"
 	"go-redis/resp/reply"
-	"io"
 	"net"
 	"strings"
 	"sync"
@@ -23,98 +23,186 @@ type RespHandler struct {
 	activeConn sync.Map              // 保存活跃的连接
 	db         databaseface.Database // 数据库接口
 	closing    atomic.Boolean        // 用于标记关闭状态
+
+	connectedClients int32 // 当前连接数，原子访问
+	totalConnections int64 // 累计接受的连接数，原子访问
+	monitors         monitors
+	pause            *pauseState
+	// 各类客户端的输出缓冲区限制，key 为 normal、replica、pubsub
+	outputLimits map[string]connection.OutputBufferLimit
+
+	// SHUTDOWN 命令请求关闭服务器时关闭 shutdownChan，shutdownSave 表示关闭前是否保存快照
+	shutdownChan chan struct{}
+	shutdownOnce sync.Once
+	shutdownSave atomic.Boolean
+	// 处理器关闭时通知空闲连接检查协程退出
+	stopReaper chan struct{}
+	// 保证 Close 只执行一次，重复调用直接返回
+	closeOnce sync.Once
 }
 
 // MakeHandler 创建 RespHandler 实例的工厂函数
 func MakeHandler() *RespHandler {
 	var db databaseface.Database
 	// 创建数据库实例，集群或单体
-	if config.Properties.Self != "" &&
-		len(config.Properties.Peers) > 0 {
+	if config.Properties.ClusterEnabled ||
+		(config.Properties.Self != "" && len(config.Properties.Peers) > 0) {
 		db = cluster.MakeClusterDatabase()
 	} else {
 		db = database.NewStandaloneDatabase()
 	}
 
-	return &RespHandler{
-		db: db,
+	h := &RespHandler{
+		db:           db,
+		pause:        makePauseState(),
+		outputLimits: makeOutputBufferLimits(),
+		shutdownChan: make(chan struct{}),
+		stopReaper:   make(chan struct{}),
 	}
+	// 向数据库提供客户端连接信息，供 INFO 等命令使用
+	if aware, ok := db.(databaseface.ClientRegistryAware); ok {
+		aware.SetClientRegistry(h)
+	}
+	h.registerMetrics()
+	h.startIdleReaper()
+	return h
 }
 
 // closeClient 用于关闭客户端连接
 func (r *RespHandler) closeClient(client *connection.Connection) {
 	_ = client.Close()
+	r.monitors.remove(client)
 	// 在数据库中处理客户端关闭事件
 	r.db.AfterClientClose(client)
-	r.activeConn.Delete(client)
+	if _, loaded := r.activeConn.LoadAndDelete(client); loaded {
+		r.onDisconnect()
+	}
 }
 
 // Handle 处理客户端连接的函数
 func (r *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 	if r.closing.Get() {
 		_ = conn.Close()
+		return
 	}
 	client := connection.NewConn(conn)
+	client.SetOutputBufferLimit(r.outputLimits[clientType(client)])
 	r.activeConn.Store(client, struct{}{})
+	r.onConnect()
 
-	// 解析客户端发来的命令
-	ch := parser.ParseStream(conn)
-	for payload := range ch {
-		// 解析错误处理
-		if payload.Err != nil {
-			if payload.Err == io.EOF || errors.Is(payload.Err, io.ErrUnexpectedEOF) || strings.Contains(payload.Err.Error(), "use of closed network connection") {
-				r.closeClient(client)
-				logger.Info("connection closed: " + client.RemoteAddr().String())
-				return
-			}
-
-			// 协议错误，向客户端回复错误信息
-			errReply := reply.MakeErrReply(payload.Err.Error())
-			err := client.Write(errReply.ToBytes())
-			if err != nil {
-				r.closeClient(client)
-				logger.Info("connection closed: " + client.RemoteAddr().String())
-				return
+	// 同步地逐条读取客户端发来的命令，回复先写入连接的输出缓冲区，
+	// 在读取缓冲区中没有更多命令、需要从网络读取时合并写出
+	reader := parser.NewReader(client)
+	defer reader.Release()
+	reader.SetLimits(parser.Limits{
+		MaxBulkLen:  config.Properties.ProtoMaxBulkLen,
+		MaxQueryLen: config.Properties.ClientQueryBufferLimit,
+	})
+	for {
+		// 只有等待客户端发送命令的时间计入空闲时间，执行耗时较长的命令时不会因 timeout 被关闭
+		client.SetWaitingCommand(true)
+		args, err := reader.ReadCommand()
+		client.SetWaitingCommand(false)
+		if err != nil {
+			var protoErr *parser.ProtocolError
+			if errors.As(err, &protoErr) {
+				// 协议错误后无法确定下一条命令的位置，与 Redis 相同，回复错误信息后关闭连接
+				errReply := reply.MakeErrReply("ERR Protocol error: " + protoErr.Msg)
+				if client.Buffer(errReply.ToBytes()) == nil {
+					_ = client.Flush()
+				}
+				logger.Info("protocol error from client " + client.RemoteAddr().String() + ": " + protoErr.Msg)
+			} else if err == parser.ErrQueryBufferLimit {
+				logger.Warn("closing client " + client.RemoteAddr().String() + " that reached max query buffer length")
 			}
-			continue
+			// 连接关闭或读写出错
+			r.closeClient(client)
+			logger.Info("connection closed: " + client.RemoteAddr().String())
+			return
 		}
-
-		// 解析成功，执行命令
-		if payload.Data == nil {
+		if len(args) == 0 {
 			continue
 		}
-		multiBulkReply, ok := payload.Data.(*reply.MultiBulkReply)
-		if !ok {
-			logger.Error("require multiBulkReply")
+		cmdName := strings.ToLower(string(args[0]))
+		if requireAuth(client, cmdName) {
+			_ = client.Buffer(reply.Marshal(errNoAuth, client.Protocol()))
 			continue
 		}
+		if r.pause.affects(cmdName) {
+			// 暂停期间先写出已经生成的回复
+			_ = client.Flush()
+			r.pause.waitIfPaused(cmdName)
+		}
+		// 服务器正在关闭，不再执行新的命令
+		if r.closing.Get() {
+			_ = client.Flush()
+			r.closeClient(client)
+			return
+		}
+		client.Touch(cmdName)
+		r.monitors.feed(client, args)
 
-		// 在数据库中执行命令，并将结果写回客户端
-		result := r.db.Exec(client, multiBulkReply.Args)
+		// 在数据库中执行命令，并将结果写回客户端，MONITOR 与 CLIENT 由网络层处理
+		var result resp.Reply
+		switch cmdName {
+		case "monitor":
+			r.monitors.add(client)
+			result = reply.MakeOkReply()
+		case "client":
+			result = r.execClient(client, args)
+		case "auth":
+			result = execAuth(client, args)
+		case "hello":
+			result = r.execHello(client, args)
+		case "shutdown":
+			result = r.execShutdown(args)
+			if result == nil {
+				// 关闭成功时不回复，直接断开连接
+				_ = client.Flush()
+				r.closeClient(client)
+				return
+			}
+		default:
+			result = r.db.Exec(client, args)
+		}
 		if result != nil {
-			_ = client.Write(result.ToBytes())
+			_ = client.Buffer(reply.Marshal(result, client.Protocol()))
 		} else {
 			// 未知错误处理，向客户端回复错误信息
 			unknownErrReply := reply.UnknownErrReply{}
-			_ = client.Write(unknownErrReply.ToBytes())
+			_ = client.Buffer(unknownErrReply.ToBytes())
+		}
+		if client.CloseAfterReply() {
+			_ = client.Flush()
+			r.closeClient(client)
+			logger.Info("connection killed: " + client.RemoteAddr().String())
+			return
 		}
 	}
 }
 
-// Close 关闭 RespHandler，释放资源
+// Close 关闭 RespHandler，释放资源，可以重复调用
+// 等待正在执行的命令完成后关闭所有连接，SHUTDOWN SAVE 时保存快照，最后关闭数据库写出并 fsync AOF
 func (r *RespHandler) Close() error {
-	logger.Info("handler shutting down...")
-	r.closing.Set(true)
-
-	// 遍历所有活跃的连接，关闭它们
-	r.activeConn.Range(func(key, value any) bool {
-		client := key.(*connection.Connection)
-		_ = client.Close()
-		return true
-	})
+	r.closeOnce.Do(func() {
+		logger.Info("handler shutting down...")
+		r.closing.Set(true)
+		close(r.stopReaper)
+		r.drainClients()
 
-	// 关闭数据库
-	r.db.Close()
+		if r.shutdownSave.Get() {
+			if snapshotter, ok := r.db.(databaseface.Snapshotter); ok {
+				if err := snapshotter.Save(); err != nil {
+					logger.Error("failed to save snapshot on shutdown: " + err.Error())
+				} else {
+					logger.Info("snapshot saved on shutdown")
+				}
+			}
+		}
 
+		// 关闭数据库
+		r.db.Close()
+		logger.Info("handler closed")
+	})
 	return nil
 }
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"go-redis/lib/redact"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// monitors 保存执行了 MONITOR 的连接，每条命令执行前都会以文本形式发送给这些连接
+type monitors struct {
+	// count 为当前的 monitor 数量，为 0 时跳过格式化，使没有 monitor 时的开销只有一次原子读
+	count int32
+	conns sync.Map // *connection.Connection -> struct{}
+}
+
+func (m *monitors) add(client *connection.Connection) {
+	if _, loaded := m.conns.LoadOrStore(client, struct{}{}); !loaded {
+		atomic.AddInt32(&m.count, 1)
+	}
+}
+
+func (m *monitors) remove(client *connection.Connection) {
+	if _, loaded := m.conns.LoadAndDelete(client); loaded {
+		atomic.AddInt32(&m.count, -1)
+	}
+}
+
+func (m *monitors) contains(client *connection.Connection) bool {
+	_, ok := m.conns.Load(client)
+	return ok
+}
+
+// feed 将 client 执行的命令发送给所有 monitor
+func (m *monitors) feed(client *connection.Connection, args [][]byte) {
+	if atomic.LoadInt32(&m.count) == 0 {
+		return
+	}
+	line := reply.MakeStatusReply(formatMonitorLine(time.Now(), client, args)).ToBytes()
+	m.conns.Range(func(key, value any) bool {
+		monitor := key.(*connection.Connection)
+		if monitor != client {
+			_ = monitor.WriteAsync(line)
+		}
+		return true
+	})
+}
+
+// formatMonitorLine 生成与 Redis 相同格式的输出：1339518083.107412 [0 127.0.0.1:60866] "keys" "*"
+func formatMonitorLine(now time.Time, client *connection.Connection, args [][]byte) string {
+	var builder strings.Builder
+	builder.WriteString(strconv.FormatInt(now.Unix(), 10))
+	builder.WriteByte('.')
+	micros := strconv.Itoa(now.Nanosecond() / 1000)
+	builder.WriteString(strings.Repeat("0", 6-len(micros)) + micros)
+	builder.WriteString(" [" + strconv.Itoa(client.GetDBIndex()) + " ")
+	if addr := client.RemoteAddr(); addr != nil {
+		builder.WriteString(addr.String())
+	}
+	builder.WriteString("]")
+	for _, arg := range redact.Args(args) {
+		builder.WriteByte(' ')
+		builder.WriteString(quoteArg(arg))
+	}
+	return builder.String()
+}
+
+// quoteArg 与 Redis 的 sdscatrepr 相同，用双引号包围参数并转义特殊字符
+func quoteArg(arg []byte) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for _, b := range arg {
+		switch b {
+		case '\\', '"':
+			builder.WriteByte('\\')
+			builder.WriteByte(b)
+		case '\n':
+			builder.WriteString("\\n")
+		case '\r':
+			builder.WriteString("\\r")
+		case '\t':
+			builder.WriteString("\\t")
+		case '\a':
+			builder.WriteString("\\a")
+		case '\b':
+			builder.WriteString("\\b")
+		default:
+			if b >= 0x20 && b < 0x7f {
+				builder.WriteByte(b)
+			} else {
+				builder.WriteString("\\x")
+				builder.WriteString(strconv.FormatUint(uint64(b>>4), 16))
+				builder.WriteString(strconv.FormatUint(uint64(b&0xf), 16))
+			}
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"errors"
+	"go-redis/config"
+	"go-redis/lib/logger"
+	"go-redis/resp/connection"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultOutputBufferLimits 是默认的 client-output-buffer-limit，与 Redis 相同
+const defaultOutputBufferLimits = "normal 0 0 0 replica 256mb 64mb 60 pubsub 32mb 8mb 60"
+
+// parseOutputBufferLimits 解析 client-output-buffer-limit <class> <hard limit> <soft limit> <soft seconds> [...]
+func parseOutputBufferLimits(value string) (map[string]connection.OutputBufferLimit, error) {
+	fields := strings.Fields(value)
+	if len(fields)%4 != 0 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	limits := make(map[string]connection.OutputBufferLimit)
+	for i := 0; i < len(fields); i += 4 {
+		class := strings.ToLower(fields[i])
+		if class == "slave" {
+			class = "replica"
+		}
+		if class != "normal" && class != "replica" && class != "pubsub" {
+			return nil, errors.New("invalid client class " + fields[i])
+		}
+		hard, err := config.ParseMemorySize(fields[i+1])
+		if err != nil {
+			return nil, errors.New("invalid hard limit " + fields[i+1])
+		}
+		soft, err := config.ParseMemorySize(fields[i+2])
+		if err != nil {
+			return nil, errors.New("invalid soft limit " + fields[i+2])
+		}
+		seconds, err := strconv.ParseInt(fields[i+3], 10, 64)
+		if err != nil || seconds < 0 {
+			return nil, errors.New("invalid soft seconds " + fields[i+3])
+		}
+		limits[class] = connection.OutputBufferLimit{
+			Hard:        hard,
+			Soft:        soft,
+			SoftSeconds: time.Duration(seconds) * time.Second,
+		}
+	}
+	return limits, nil
+}
+
+// makeOutputBufferLimits 返回各类客户端的输出缓冲区限制，配置中未指定的类别使用默认值
+func makeOutputBufferLimits() map[string]connection.OutputBufferLimit {
+	limits, _ := parseOutputBufferLimits(defaultOutputBufferLimits)
+	if config.Properties.ClientOutputBufferLimit == "" {
+		return limits
+	}
+	configured, err := parseOutputBufferLimits(config.Properties.ClientOutputBufferLimit)
+	if err != nil {
+		logger.Warn("invalid client-output-buffer-limit: " + err.Error())
+		return limits
+	}
+	for class, limit := range configured {
+		limits[class] = limit
+	}
+	return limits
+}
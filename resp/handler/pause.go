@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"go-redis/database"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CLIENT PAUSE 的模式
+const (
+	pauseAll   = "all"
+	pauseWrite = "write"
+)
+
+// pauseState 实现 CLIENT PAUSE：暂停期间客户端的命令在执行前等待，直到超时或 CLIENT UNPAUSE
+type pauseState struct {
+	mu    sync.Mutex
+	until time.Time
+	mode  string
+	// resumed 在 UNPAUSE 时关闭，唤醒所有等待中的客户端
+	resumed chan struct{}
+	// untilNano 为 until 的 unix 纳秒，原子访问，使没有暂停时不需要加锁
+	untilNano int64
+}
+
+func makePauseState() *pauseState {
+	return &pauseState{
+		resumed: make(chan struct{}),
+	}
+}
+
+// pause 暂停客户端直到 until，与 Redis 相同，正在进行的暂停只会被延长，模式以限制更多的为准
+func (p *pauseState) pause(until time.Time, mode string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Now().After(p.until) {
+		p.mode = mode
+	} else if mode == pauseAll {
+		p.mode = pauseAll
+	}
+	if until.After(p.until) {
+		p.until = until
+		atomic.StoreInt64(&p.untilNano, until.UnixNano())
+	}
+}
+
+func (p *pauseState) unpause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.until = time.Time{}
+	atomic.StoreInt64(&p.untilNano, 0)
+	close(p.resumed)
+	p.resumed = make(chan struct{})
+}
+
+// active 判断当前是否处于暂停期间
+func (p *pauseState) active() bool {
+	return atomic.LoadInt64(&p.untilNano) > time.Now().UnixNano()
+}
+
+// affects 判断当前是否处于暂停期间且 cmdName 可能需要等待，没有暂停时只需一次原子读取
+func (p *pauseState) affects(cmdName string) bool {
+	return p.active() && !strings.EqualFold(cmdName, "client")
+}
+
+// waitIfPaused 在暂停期间阻塞受影响的命令，CLIENT 命令不受影响以便执行 CLIENT UNPAUSE
+func (p *pauseState) waitIfPaused(cmdName string) {
+	if !p.affects(cmdName) {
+		return
+	}
+	for {
+		p.mu.Lock()
+		remaining := time.Until(p.until)
+		if remaining <= 0 || (p.mode == pauseWrite && !database.IsWriteCommand(cmdName)) {
+			p.mu.Unlock()
+			return
+		}
+		resumed := p.resumed
+		p.mu.Unlock()
+		timer := time.NewTimer(remaining)
+		select {
+		case <-timer.C:
+		case <-resumed:
+			timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"fmt"
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"strings"
+	"time"
+)
+
+// shutdownPollInterval 关闭服务器时检查剩余连接数的间隔
+const shutdownPollInterval = 10 * time.Millisecond
+
+// execShutdown 处理 SHUTDOWN [NOSAVE|SAVE]
+// 参数正确时请求 tcp 层关闭服务器并返回 nil，与 Redis 相同不回复客户端；SAVE 在关闭前将数据库快照写入 AOF 文件
+// 服务器没有复制功能，关闭时不等待从节点追上复制偏移量，shutdown-timeout 只用于等待客户端正在执行的命令，
+// 因此不支持 Redis 中与从节点相关的 NOW、FORCE、ABORT 选项
+func (r *RespHandler) execShutdown(args [][]byte) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	save := false
+	if len(args) == 2 {
+		switch strings.ToUpper(string(args[1])) {
+		case "NOSAVE":
+		case "SAVE":
+			save = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if save && !config.Properties.AppendOnly {
+		logger.Warn("SHUTDOWN SAVE requires appendonly, the snapshot is saved to the AOF file")
+		return reply.MakeErrReply("ERR Errors trying to SHUTDOWN. Check logs.")
+	}
+	logger.Info("user requested shutdown, no replicas to wait for...")
+	r.shutdownOnce.Do(func() {
+		r.shutdownSave.Set(save)
+		close(r.shutdownChan)
+	})
+	return nil
+}
+
+// ShutdownRequested 返回在 SHUTDOWN 命令请求关闭服务器时关闭的通道
+func (r *RespHandler) ShutdownRequested() <-chan struct{} {
+	return r.shutdownChan
+}
+
+// drainClients 停止读取新的命令，等待正在执行的命令完成并写出回复，超过 shutdown-timeout 后关闭剩余的连接
+// 与 Redis 不同，这里只等待客户端，没有需要等待的从节点
+func (r *RespHandler) drainClients() {
+	r.activeConn.Range(func(key, value any) bool {
+		key.(*connection.Connection).InterruptRead()
+		return true
+	})
+	// 唤醒因 CLIENT PAUSE 等待的客户端，使其不再执行命令并退出
+	r.pause.unpause()
+
+	deadline := time.Now().Add(time.Duration(config.Properties.ShutdownTimeout) * time.Second)
+	for r.ConnectedClients() > 0 && time.Now().Before(deadline) {
+		time.Sleep(shutdownPollInterval)
+	}
+	if n := r.ConnectedClients(); n > 0 {
+		logger.Warn(fmt.Sprintf("closing %d clients that did not finish before shutdown-timeout", n))
+	}
+	r.activeConn.Range(func(key, value any) bool {
+		_ = key.(*connection.Connection).Close()
+		return true
+	})
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"go-redis/config"
+	"go-redis/lib/logger"
+	"go-redis/resp/connection"
+	"time"
+)
+
+// idleCheckInterval 检查空闲连接的间隔
+const idleCheckInterval = time.Second
+
+// startIdleReaper 配置了 timeout 时启动后台协程，定期关闭空闲时间超过 timeout 的连接，直到处理器关闭
+func (r *RespHandler) startIdleReaper() {
+	if config.Properties.Timeout <= 0 {
+		return
+	}
+	timeout := time.Duration(config.Properties.Timeout) * time.Second
+	go func() {
+		ticker := time.NewTicker(idleCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.closeIdleClients(timeout)
+			case <-r.stopReaper:
+				return
+			}
+		}
+	}()
+}
+
+// closeIdleClients 关闭等待下一条命令超过 timeout 的连接，正在执行命令的连接不算空闲
+// 与 Redis 相同，pubsub、replica 以及 MONITOR 连接不会因空闲被关闭，CLIENT PAUSE 期间等待中的客户端也不算空闲
+func (r *RespHandler) closeIdleClients(timeout time.Duration) {
+	if r.pause.active() {
+		return
+	}
+	r.activeConn.Range(func(key, value any) bool {
+		client := key.(*connection.Connection)
+		if clientType(client) != "normal" || r.monitors.contains(client) {
+			return true
+		}
+		if client.WaitingTime() > timeout {
+			logger.Info("closing idle client " + client.RemoteAddr().String())
+			// 关闭网络连接后，处理该连接的协程读取失败并完成清理；
+			// Close 会等待正在写出的回复，不阻塞对其他连接的检查
+			go func(client *connection.Connection) {
+				_ = client.Close()
+			}(client)
+		}
+		return true
+	})
+}
@@ -8,294 +8,660 @@ import (
 	"go-redis/lib/logger"
 	"go-redis/resp/reply"
 	"io"
+	"math"
+	"math/big"
 	"runtime/debug"
 	"strconv"
-	"strings"
+	"sync"
 )
 
+const (
+	// readBufferSize is the size of the pooled buffer each Reader reads through
+	readBufferSize = 16 * 1024
+	// argChunkSize is the size of the chunks small bulk strings are carved from
+	argChunkSize = 4 * 1024
+	// maxChunkedArg is the largest bulk string carved from a chunk, larger ones get their own allocation
+	maxChunkedArg = 256
+	// maxInlineSize is the longest inline command or header line accepted from clients
+	maxInlineSize = 64 * 1024
+	// maxMultiBulkLen is the largest number of arguments of a command
+	maxMultiBulkLen = 1024 * 1024
+	// maxPrealloc bounds the memory allocated from a length header before the data arrives,
+	// larger aggregates and bulk strings grow as they are read
+	maxPrealloc = 1024 * 1024
+)
+
+// ErrQueryBufferLimit is returned by ReadCommand when the arguments of a command exceed Limits.MaxQueryLen
+var ErrQueryBufferLimit = errors.New("max query buffer length reached")
+
+// Limits bounds the commands accepted by ReadCommand, zero values mean no limit
+type Limits struct {
+	MaxBulkLen  int64 // proto-max-bulk-len, the largest bulk string
+	MaxQueryLen int64 // client-query-buffer-limit, the total size of the arguments of one command
+}
+
+var bufReaderPool = sync.Pool{
+	New: func() any {
+		return bufio.NewReaderSize(nil, readBufferSize)
+	},
+}
+
 // Payload stores redis.Reply or error
 type Payload struct {
 	Data resp.Reply
 	Err  error
 }
 
-// ParseStream reads data from io.Reader and send payloads through channel
+// ProtocolError reports malformed input. The Reader may be in the middle of a message afterwards,
+// so servers should reply the error and close the connection.
+type ProtocolError struct {
+	Msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "protocol error: " + e.Msg
+}
+
+func protocolError(msg string) error {
+	return &ProtocolError{Msg: msg}
+}
+
+// Reader reads redis serialization protocol messages synchronously, the caller pulls one message at a time
+type Reader struct {
+	br *bufio.Reader
+	// line holds lines which do not fit in the read buffer
+	line []byte
+	// chunk is the unused tail of the chunk small bulk strings are carved from
+	chunk []byte
+	// scratch is reused to unquote inline arguments
+	scratch []byte
+	limits  Limits
+}
+
+// NewReader creates a Reader on a pooled buffer, call Release once the Reader is no longer used
+func NewReader(rd io.Reader) *Reader {
+	br := bufReaderPool.Get().(*bufio.Reader)
+	br.Reset(rd)
+	return &Reader{br: br}
+}
+
+// Release returns the buffer to the pool, the Reader must not be used afterwards
+func (r *Reader) Release() {
+	if r.br == nil {
+		return
+	}
+	r.br.Reset(nil)
+	bufReaderPool.Put(r.br)
+	r.br = nil
+}
+
+// Buffered returns the number of bytes which can be read without blocking
+func (r *Reader) Buffered() int {
+	return r.br.Buffered()
+}
+
+// SetLimits sets the limits applied to the following commands
+func (r *Reader) SetLimits(limits Limits) {
+	r.limits = limits
+}
+
+// ReadCommand reads the next request, either a multi bulk of bulk strings or an inline command.
+// Inline commands may be terminated by a bare LF so that they can be typed in nc or telnet.
+// Empty requests are returned as an empty slice and should be skipped by the caller.
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	line, err := r.readRawLine(maxInlineSize)
+	if err == ErrQueryBufferLimit {
+		if len(line) > 0 && line[0] == '*' {
+			return nil, protocolError("too big mbulk count string")
+		}
+		return nil, protocolError("too big inline request")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return r.parseInline(bytes.TrimSuffix(line, []byte{'\r'}))
+	}
+	if line[len(line)-1] != '\r' {
+		return nil, protocolError("line is not terminated by CRLF")
+	}
+	n, ok := parseInt(line[1 : len(line)-1])
+	if !ok || n > maxMultiBulkLen {
+		return nil, protocolError("invalid multibulk length")
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	args := make([][]byte, 0, min(n, maxPrealloc/8))
+	var queryLen int64
+	for i := int64(0); i < n; i++ {
+		line, err = r.readRawLine(maxInlineSize)
+		if err == ErrQueryBufferLimit {
+			return nil, protocolError("too big bulk count string")
+		}
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if len(line) == 0 || line[len(line)-1] != '\r' {
+			return nil, protocolError("line is not terminated by CRLF")
+		}
+		line = line[:len(line)-1]
+		if len(line) == 0 {
+			return nil, protocolError("expected '$', got empty line")
+		}
+		if line[0] != '$' {
+			return nil, protocolError("expected '$', got '" + string(line[:1]) + "'")
+		}
+		size, ok := parseInt(line[1:])
+		if !ok || size < 0 || (r.limits.MaxBulkLen > 0 && size > r.limits.MaxBulkLen) {
+			return nil, protocolError("invalid bulk length")
+		}
+		queryLen += size
+		if r.limits.MaxQueryLen > 0 && queryLen > r.limits.MaxQueryLen {
+			return nil, ErrQueryBufferLimit
+		}
+		arg, err := r.readBulk(size)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// ReadReply reads the next reply of any RESP2 or RESP3 type, aggregates are read recursively
+func (r *Reader) ReadReply() (resp.Reply, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	return r.readElement(line)
+}
+
+// ParseStream reads replies from io.Reader in a new goroutine and sends payloads through channel,
+// the channel is closed after the first error
 func ParseStream(reader io.Reader) <-chan *Payload {
 	ch := make(chan *Payload)
-	go parse0(reader, ch)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				logger.Error(string(debug.Stack()))
+			}
+		}()
+		defer close(ch)
+		r := NewReader(reader)
+		defer r.Release()
+		for {
+			result, err := r.ReadReply()
+			ch <- &Payload{Data: result, Err: err}
+			if err != nil {
+				return
+			}
+		}
+	}()
 	return ch
 }
 
 // ParseBytes reads data from []byte and return all replies
 func ParseBytes(data []byte) ([]resp.Reply, error) {
-	ch := make(chan *Payload)
-	reader := bytes.NewReader(data)
-	go parse0(reader, ch)
+	r := NewReader(bytes.NewReader(data))
+	defer r.Release()
 	var results []resp.Reply
-	for payload := range ch {
-		if payload == nil {
-			return nil, errors.New("no reply")
+	for {
+		result, err := r.ReadReply()
+		if err == io.EOF {
+			return results, nil
 		}
-		if payload.Err != nil {
-			if payload.Err == io.EOF {
-				break
-			}
-			return nil, payload.Err
+		if err != nil {
+			return nil, err
 		}
-		results = append(results, payload.Data)
+		results = append(results, result)
 	}
-	return results, nil
 }
 
-// ParseOne reads data from []byte and return the first payload
+// ParseOne reads data from []byte and return the first reply
 func ParseOne(data []byte) (resp.Reply, error) {
-	ch := make(chan *Payload)
-	reader := bytes.NewReader(data)
-	go parse0(reader, ch)
-	payload := <-ch // parse0 will close the channel
-	if payload == nil {
+	r := NewReader(bytes.NewReader(data))
+	defer r.Release()
+	result, err := r.ReadReply()
+	if err == io.EOF {
 		return nil, errors.New("no reply")
 	}
-	return payload.Data, payload.Err
+	return result, err
 }
 
-type readState struct {
-	readingMultiLine  bool
-	expectedArgsCount int
-	msgType           byte
-	args              [][]byte
-	bulkLen           int64
+// readLine returns the next line without the trailing CRLF, the slice is only valid until the next read
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.readRawLine(0)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[len(line)-1] != '\r' {
+		return nil, protocolError("line is not terminated by CRLF")
+	}
+	return line[:len(line)-1], nil
 }
 
-func (s *readState) finished() bool {
-	return s.expectedArgsCount > 0 && len(s.args) == s.expectedArgsCount
+// readRawLine returns the next line without the trailing LF, the slice is only valid until the next read.
+// Once a line grows longer than maxLen, ErrQueryBufferLimit is returned with its first byte without
+// reading the rest of the line, maxLen 0 means no limit.
+func (r *Reader) readRawLine(maxLen int) ([]byte, error) {
+	line, err := r.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// the line is longer than the read buffer, collect it in r.line
+		r.line = append(r.line[:0], line...)
+		for err == bufio.ErrBufferFull {
+			if maxLen > 0 && len(r.line) > maxLen {
+				return r.line[:1], ErrQueryBufferLimit
+			}
+			line, err = r.br.ReadSlice('\n')
+			r.line = append(r.line, line...)
+		}
+		line = r.line
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if maxLen > 0 && len(line)-1 > maxLen {
+		return line[:1], ErrQueryBufferLimit
+	}
+	return line[:len(line)-1], nil
 }
 
-func parse0(reader io.Reader, ch chan<- *Payload) {
-	defer func() {
-		if err := recover(); err != nil {
-			logger.Error(string(debug.Stack()))
+// readBulk reads a bulk body of size bytes followed by CRLF
+func (r *Reader) readBulk(size int64) ([]byte, error) {
+	var body []byte
+	if size <= maxPrealloc {
+		body = r.alloc(size)
+		if _, err := io.ReadFull(r.br, body); err != nil {
+			return nil, unexpectedEOF(err)
 		}
-	}()
-
-	bufReader := bufio.NewReader(reader)
-	var state readState
-	var err error
-	var msg []byte
-	for {
-		// read line
-		var ioErr bool
-		msg, ioErr, err = readLine(bufReader, &state)
-		if err != nil {
-			if ioErr { // encounter io err, stop read
-				ch <- &Payload{
-					Err: err,
-				}
-				close(ch)
-				return
+	} else {
+		// do not trust the length header, grow the body as the data arrives
+		body = make([]byte, 0, maxPrealloc)
+		for int64(len(body)) < size {
+			if len(body) == cap(body) {
+				grown := make([]byte, len(body), min(2*int64(cap(body)), size))
+				copy(grown, body)
+				body = grown
 			}
-			// protocol err, reset read state
-			ch <- &Payload{
-				Err: err,
+			n, err := io.ReadFull(r.br, body[len(body):cap(body)])
+			body = body[:len(body)+n]
+			if err != nil {
+				return nil, unexpectedEOF(err)
 			}
-			state = readState{}
-			continue
 		}
+	}
+	cr, err := r.br.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	lf, err := r.br.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if cr != '\r' || lf != '\n' {
+		return nil, protocolError("bulk string is not terminated by CRLF")
+	}
+	return body, nil
+}
 
-		// parse line
-		if !state.readingMultiLine {
-			// receive new response
-			if msg[0] == '*' {
-				// multi bulk reply
-				err = parseMultiBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{
-						Err: errors.New("protocol error: " + string(msg)),
-					}
-					state = readState{} // reset state
-					continue
+// readBlob reads the body of a length prefixed string whose header line is line, returns nil for length -1
+func (r *Reader) readBlob(line []byte) ([]byte, error) {
+	size, ok := parseInt(line[1:])
+	if !ok || size < -1 {
+		return nil, protocolError("invalid bulk length")
+	}
+	if size == -1 {
+		return nil, nil
+	}
+	return r.readBulk(size)
+}
+
+// alloc returns a slice of n bytes. Small slices are carved from a shared chunk with their capacity
+// capped, so appending to one of them never overwrites its neighbours.
+func (r *Reader) alloc(n int64) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+	if n > maxChunkedArg {
+		return make([]byte, n)
+	}
+	if int64(len(r.chunk)) < n {
+		r.chunk = make([]byte, argChunkSize)
+	}
+	b := r.chunk[:n:n]
+	r.chunk = r.chunk[n:]
+	return b
+}
+
+// parseInline splits an inline command into arguments the same way as sdssplitargs in redis:
+// arguments are separated by blanks and may be double quoted with escapes like \n and \x41,
+// or single quoted where \' is the only escape
+func (r *Reader) parseInline(line []byte) ([][]byte, error) {
+	var args [][]byte
+	p := 0
+	for {
+		for p < len(line) && isSpace(line[p]) {
+			p++
+		}
+		if p == len(line) {
+			return args, nil
+		}
+		current := r.scratch[:0]
+		inDoubleQuotes, inSingleQuotes := false, false
+		for done := false; !done; p++ {
+			switch {
+			case inDoubleQuotes:
+				if p == len(line) {
+					return nil, protocolError("unbalanced quotes in request")
 				}
-				if state.expectedArgsCount == 0 {
-					ch <- &Payload{
-						Data: &reply.EmptyMultiBulkReply{},
+				if line[p] == '\\' && p+3 < len(line) && line[p+1] == 'x' && isHex(line[p+2]) && isHex(line[p+3]) {
+					current = append(current, unhex(line[p+2])<<4|unhex(line[p+3]))
+					p += 3
+				} else if line[p] == '\\' && p+1 < len(line) {
+					p++
+					current = append(current, unescape(line[p]))
+				} else if line[p] == '"' {
+					// the closing quote must be followed by a blank or nothing
+					if p+1 < len(line) && !isSpace(line[p+1]) {
+						return nil, protocolError("unbalanced quotes in request")
 					}
-					state = readState{} // reset state
-					continue
+					done = true
+				} else {
+					current = append(current, line[p])
 				}
-			} else if msg[0] == '$' { // bulk reply
-				err = parseBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{
-						Err: errors.New("protocol error: " + string(msg)),
-					}
-					state = readState{} // reset state
-					continue
+			case inSingleQuotes:
+				if p == len(line) {
+					return nil, protocolError("unbalanced quotes in request")
 				}
-				if state.bulkLen == -1 { // null bulk reply
-					ch <- &Payload{
-						Data: &reply.NullBulkReply{},
+				if line[p] == '\\' && p+1 < len(line) && line[p+1] == '\'' {
+					p++
+					current = append(current, '\'')
+				} else if line[p] == '\'' {
+					if p+1 < len(line) && !isSpace(line[p+1]) {
+						return nil, protocolError("unbalanced quotes in request")
 					}
-					state = readState{} // reset state
-					continue
+					done = true
+				} else {
+					current = append(current, line[p])
 				}
-			} else {
-				// single line reply
-				result, err := parseSingleLineReply(msg)
-				ch <- &Payload{
-					Data: result,
-					Err:  err,
-				}
-				state = readState{} // reset state
-				continue
-			}
-		} else {
-			// receive following bulk reply
-			err = readBody(msg, &state)
-			if err != nil {
-				ch <- &Payload{
-					Err: errors.New("protocol error: " + string(msg)),
+			default:
+				if p == len(line) || isSpace(line[p]) {
+					done = true
+				} else if line[p] == '"' {
+					inDoubleQuotes = true
+				} else if line[p] == '\'' {
+					inSingleQuotes = true
+				} else {
+					current = append(current, line[p])
 				}
-				state = readState{} // reset state
-				continue
-			}
-			// if sending finished
-			if state.finished() {
-				var result resp.Reply
-				if state.msgType == '*' {
-					result = reply.MakeMultiBulkReply(state.args)
-				} else if state.msgType == '$' {
-					result = reply.MakeBulkReply(state.args[0])
-				}
-				ch <- &Payload{
-					Data: result,
-					Err:  err,
-				}
-				state = readState{}
 			}
 		}
+		arg := r.alloc(int64(len(current)))
+		copy(arg, current)
+		args = append(args, arg)
+		r.scratch = current
+		if p > len(line) {
+			return args, nil
+		}
 	}
 }
 
-func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
-	var msg []byte
-	var err error
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
 
-	if state.bulkLen == 0 { // read normal line
-		//1.以“\r\n”切分，读以‘\n’结尾
-		msg, err = bufReader.ReadBytes('\n')
+// unescape returns the byte a backslash escape inside double quotes stands for
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+	return c
+}
+
+// readElement reads a whole element whose header line is line, aggregates are read recursively
+func (r *Reader) readElement(line []byte) (resp.Reply, error) {
+	if len(line) == 0 {
+		return nil, protocolError("empty line")
+	}
+	switch line[0] {
+	case '+':
+		return reply.MakeStatusReply(string(line[1:])), nil
+	case '-':
+		return reply.MakeErrReply(string(line[1:])), nil
+	case ':':
+		value, ok := parseInt(line[1:])
+		if !ok {
+			return nil, protocolError("invalid integer " + strconv.Quote(string(line[1:])))
+		}
+		return reply.MakeIntReply(value), nil
+	case '$':
+		body, err := r.readBlob(line)
 		if err != nil {
-			return nil, true, err
+			return nil, err
 		}
-		//检查行尾是否为“\r\n”
-		if len(msg) == 0 || msg[len(msg)-2] != '\r' {
-			return nil, false, errors.New("protocol error: " + string(msg))
+		if body == nil {
+			return reply.MakeNullBulkReply(), nil
 		}
-	} else { // read bulk line (binary safe)
-		//2.读到了“$Num”，严格读取Num个字符 + 2（“\r\n”）
-		msg = make([]byte, state.bulkLen+2)
-		_, err = io.ReadFull(bufReader, msg)
+		return reply.MakeBulkReply(body), nil
+	case '*':
+		n, ok := parseInt(line[1:])
+		if !ok || n < -1 {
+			return nil, protocolError("invalid multibulk length")
+		}
+		if n == -1 {
+			return reply.MakeNullBulkReply(), nil
+		}
+		if n == 0 {
+			return reply.MakeEmptyMultiBulkReply(), nil
+		}
+		return r.readArray(n)
+	case '~', '>':
+		n, ok := parseInt(line[1:])
+		if !ok || n < 0 {
+			return nil, protocolError("invalid aggregate length")
+		}
+		kind := line[0]
+		replies, err := r.readElements(n)
+		if err != nil {
+			return nil, err
+		}
+		if kind == '~' {
+			return reply.MakeSetReply(replies), nil
+		}
+		return reply.MakePushReply(replies), nil
+	case '%':
+		return r.readMap(line)
+	case '|':
+		attributes, err := r.readMap(line)
 		if err != nil {
-			return nil, true, err
+			return nil, err
 		}
-		//检查行尾是否为“\r\n”
-		if len(msg) == 0 || msg[len(msg)-2] != '\r' || msg[len(msg)-1] != '\n' {
-			return nil, false, errors.New("protocol error: " + string(msg))
+		elem, err := r.ReadReply()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return reply.MakeAttributeReply(attributes, elem), nil
+	case ',':
+		value, err := strconv.ParseFloat(string(line[1:]), 64)
+		if err != nil {
+			return nil, protocolError("invalid double " + strconv.Quote(string(line[1:])))
 		}
-		//重置bulkLen
-		state.bulkLen = 0
+		return reply.MakeDoubleReply(value), nil
+	case '#':
+		switch string(line[1:]) {
+		case "t":
+			return reply.MakeBooleanReply(true), nil
+		case "f":
+			return reply.MakeBooleanReply(false), nil
+		}
+		return nil, protocolError("invalid boolean " + strconv.Quote(string(line[1:])))
+	case '_':
+		return reply.MakeNullBulkReply(), nil
+	case '(':
+		value, ok := new(big.Int).SetString(string(line[1:]), 10)
+		if !ok {
+			return nil, protocolError("invalid big number " + strconv.Quote(string(line[1:])))
+		}
+		return reply.MakeBigNumberReply(value), nil
+	case '=':
+		body, err := r.readBlob(line)
+		if err != nil {
+			return nil, err
+		}
+		if len(body) < 4 || body[3] != ':' {
+			return nil, protocolError("bad verbatim string")
+		}
+		return reply.MakeVerbatimReply(string(body[:3]), body[4:]), nil
+	case '!':
+		body, err := r.readBlob(line)
+		if err != nil {
+			return nil, err
+		}
+		return reply.MakeErrReply(string(body)), nil
 	}
-
-	return msg, false, nil
+	return nil, protocolError("unexpected type byte " + strconv.QuoteRune(rune(line[0])))
 }
 
-func parseMultiBulkHeader(msg []byte, state *readState) error {
-	var err error
-	var expectedLine uint64
-
-	expectedLine, err = strconv.ParseUint(string(msg[1:len(msg)-2]), 10, 32)
-	if err != nil {
-		return errors.New("protocol error: " + string(msg))
-	}
-	if expectedLine == 0 {
-		state.expectedArgsCount = 0
-		return nil
-	} else if expectedLine > 0 {
-		// first line of multi bulk reply
-		state.msgType = msg[0]
-		state.readingMultiLine = true
-		state.expectedArgsCount = int(expectedLine)
-		state.args = make([][]byte, 0, expectedLine)
-		return nil
-	} else {
-		return errors.New("protocol error: " + string(msg))
+// readArray reads n elements of a multi bulk reply. Arrays of bulk strings are returned as
+// MultiBulkReply with nil for null elements, arrays containing other types as MultiRawReply.
+func (r *Reader) readArray(n int64) (resp.Reply, error) {
+	args := make([][]byte, 0, min(n, maxPrealloc/8))
+	var replies []resp.Reply // not nil once an element other than a bulk string is met
+	for i := int64(0); i < n; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if replies == nil && len(line) > 0 && line[0] == '$' {
+			arg, err := r.readBlob(line)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			continue
+		}
+		if replies == nil {
+			replies = make([]resp.Reply, 0, min(n, maxPrealloc/16))
+			for _, arg := range args {
+				if arg == nil {
+					replies = append(replies, reply.MakeNullBulkReply())
+				} else {
+					replies = append(replies, reply.MakeBulkReply(arg))
+				}
+			}
+		}
+		elem, err := r.readElement(line)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		replies = append(replies, elem)
 	}
-}
-
-func parseBulkHeader(msg []byte, state *readState) error {
-	var err error
-	state.bulkLen, err = strconv.ParseInt(string(msg[1:len(msg)-2]), 10, 64)
-	if err != nil {
-		return errors.New("protocol error: " + string(msg))
-	}
-	if state.bulkLen == -1 { // null bulk
-		return nil
-	} else if state.bulkLen > 0 {
-		state.msgType = msg[0]
-		state.readingMultiLine = true
-		state.expectedArgsCount = 1
-		state.args = make([][]byte, 0, 1)
-		return nil
-	} else {
-		return errors.New("protocol error: " + string(msg))
+	if replies != nil {
+		return reply.MakeMultiRawReply(replies), nil
 	}
+	return reply.MakeMultiBulkReply(args), nil
 }
 
-func parseSingleLineReply(msg []byte) (resp.Reply, error) {
-	str := strings.TrimSuffix(string(msg), "\r\n")
-	var result resp.Reply
-
-	switch msg[0] {
-	case '+': // status reply
-		result = reply.MakeStatusReply(str[1:])
-	case '-': // err reply
-		result = reply.MakeErrReply(str[1:])
-	case ':': // int reply
-		val, err := strconv.ParseInt(str[1:], 10, 64)
+// readElements reads n elements following an aggregate header
+func (r *Reader) readElements(n int64) ([]resp.Reply, error) {
+	replies := make([]resp.Reply, 0, min(n, maxPrealloc/16))
+	for i := int64(0); i < n; i++ {
+		elem, err := r.ReadReply()
 		if err != nil {
-			return nil, errors.New("protocol error: " + string(msg))
+			return nil, unexpectedEOF(err)
 		}
-		result = reply.MakeIntReply(val)
-	default:
-		// parse as text protocol
-		strs := strings.Split(str, " ")
-		args := make([][]byte, len(strs))
-		for i, s := range strs {
-			args[i] = []byte(s)
-		}
-		result = reply.MakeMultiBulkReply(args)
+		replies = append(replies, elem)
 	}
+	return replies, nil
+}
 
+// readMap reads the key value pairs of a map or attribute whose header line is line
+func (r *Reader) readMap(line []byte) (*reply.MapReply, error) {
+	n, ok := parseInt(line[1:])
+	if !ok || n < 0 {
+		return nil, protocolError("invalid aggregate length")
+	}
+	elements, err := r.readElements(2 * n)
+	if err != nil {
+		return nil, err
+	}
+	result := reply.MakeMapReply()
+	for i := 0; i < len(elements); i += 2 {
+		result.Add(elements[i], elements[i+1])
+	}
 	return result, nil
 }
 
-// read the non-first lines of multi bulk reply or bulk reply
-func readBody(msg []byte, state *readState) error {
-	line := msg[0 : len(msg)-2]
-	var err error
+// unexpectedEOF converts io.EOF met in the middle of a message to io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
 
-	if line[0] == '$' {
-		// bulk reply
-		state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
-		if err != nil {
-			return errors.New("protocol error: " + string(msg))
+// parseInt parses a decimal integer in a header line without allocating
+func parseInt(b []byte) (int64, bool) {
+	neg := false
+	if len(b) > 0 && b[0] == '-' {
+		neg = true
+		b = b[1:]
+	}
+	if len(b) == 0 {
+		return 0, false
+	}
+	var n int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
 		}
-		if state.bulkLen <= 0 { // null bulk in multi bulks
-			state.args = append(state.args, []byte{})
-			state.bulkLen = 0
+		d := int64(c - '0')
+		if n > (math.MaxInt64-d)/10 {
+			return 0, false
 		}
-	} else {
-		state.args = append(state.args, line)
+		n = n*10 + d
+	}
+	if neg {
+		return -n, true
 	}
+	return n, true
+}
 
-	return nil
+func min(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
 }
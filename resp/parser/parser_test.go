@@ -0,0 +1,456 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"go-redis/resp/reply"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// readers returns the ways a test input is fed to the Reader: all at once, and one byte per read
+// so that every header and bulk string is split across reads
+func readers(data string) map[string]io.Reader {
+	return map[string]io.Reader{
+		"whole":   strings.NewReader(data),
+		"onebyte": iotest.OneByteReader(strings.NewReader(data)),
+	}
+}
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  [][]byte
+	}{
+		{"multibulk", "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n", [][]byte{[]byte("SET"), []byte("key"), []byte("value")}},
+		{"empty bulk", "*2\r\n$4\r\nECHO\r\n$0\r\n\r\n", [][]byte{[]byte("ECHO"), {}}},
+		{"binary bulk", "*2\r\n$4\r\nECHO\r\n$4\r\na\r\nb\r\n", [][]byte{[]byte("ECHO"), []byte("a\r\nb")}},
+		{"zero count", "*0\r\n", nil},
+		{"negative count", "*-1\r\n", nil},
+		{"inline", "SET key value\r\n", [][]byte{[]byte("SET"), []byte("key"), []byte("value")}},
+		{"inline bare LF", "PING\n", [][]byte{[]byte("PING")}},
+		{"inline empty", "\r\n", nil},
+		{"long bulk", "*1\r\n$20000\r\n" + strings.Repeat("x", 20000) + "\r\n", [][]byte{bytes.Repeat([]byte("x"), 20000)}},
+	}
+	for _, tt := range tests {
+		for mode, rd := range readers(tt.input) {
+			r := NewReader(rd)
+			got, err := r.ReadCommand()
+			r.Release()
+			if err != nil {
+				t.Errorf("%s/%s: unexpected error %v", tt.name, mode, err)
+				continue
+			}
+			if !equalArgs(got, tt.want) {
+				t.Errorf("%s/%s: got %q, want %q", tt.name, mode, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestReadCommandPipelined(t *testing.T) {
+	input := "*1\r\n$4\r\nPING\r\nECHO a\r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"
+	want := [][][]byte{
+		{[]byte("PING")},
+		{[]byte("ECHO"), []byte("a")},
+		{[]byte("GET"), []byte("k")},
+	}
+	for mode, rd := range readers(input) {
+		r := NewReader(rd)
+		for i, w := range want {
+			got, err := r.ReadCommand()
+			if err != nil || !equalArgs(got, w) {
+				t.Errorf("%s: command %d = %q, %v, want %q", mode, i, got, err, w)
+			}
+		}
+		if _, err := r.ReadCommand(); err != io.EOF {
+			t.Errorf("%s: got %v at the end of input, want io.EOF", mode, err)
+		}
+		r.Release()
+	}
+}
+
+func TestReadCommandErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		limits   Limits
+		wantErr  error // compared with errors.Is, nil means a *ProtocolError
+		protocol bool
+	}{
+		{name: "bad count", input: "*abc\r\n", protocol: true},
+		{name: "count too big", input: "*99999999999\r\n", protocol: true},
+		{name: "missing CR", input: "*1\n$4\r\nPING\r\n", protocol: true},
+		{name: "not a bulk", input: "*1\r\n+PING\r\n", protocol: true},
+		{name: "negative bulk", input: "*1\r\n$-1\r\n", protocol: true},
+		{name: "bulk without CRLF", input: "*1\r\n$4\r\nPINGxx", protocol: true},
+		{name: "unbalanced quotes", input: "SET \"key value\r\n", protocol: true},
+		{name: "truncated bulk", input: "*1\r\n$4\r\nPI", wantErr: io.ErrUnexpectedEOF},
+		{name: "truncated count", input: "*2\r\n$4\r\nPING\r\n", wantErr: io.ErrUnexpectedEOF},
+		{name: "bulk over proto-max-bulk-len", input: "*1\r\n$4\r\nPING\r\n", limits: Limits{MaxBulkLen: 3}, protocol: true},
+		{name: "query over client-query-buffer-limit", input: "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n",
+			limits: Limits{MaxQueryLen: 5}, wantErr: ErrQueryBufferLimit},
+		{name: "inline too big", input: strings.Repeat("x", maxInlineSize+1) + "\r\n", protocol: true},
+	}
+	for _, tt := range tests {
+		for mode, rd := range readers(tt.input) {
+			r := NewReader(rd)
+			r.SetLimits(tt.limits)
+			_, err := r.ReadCommand()
+			r.Release()
+			var protoErr *ProtocolError
+			if tt.protocol && !errors.As(err, &protoErr) {
+				t.Errorf("%s/%s: got %v, want a protocol error", tt.name, mode, err)
+			}
+			if !tt.protocol && !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s/%s: got %v, want %v", tt.name, mode, err, tt.wantErr)
+			}
+		}
+	}
+}
+
+func TestParseInline(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", line: "", want: nil},
+		{name: "blanks only", line: " \t ", want: nil},
+		{name: "blanks around arguments", line: "  GET \t key  ", want: []string{"GET", "key"}},
+		{name: "double quotes", line: `SET "a b" c`, want: []string{"SET", "a b", "c"}},
+		{name: "empty double quotes", line: `ECHO ""`, want: []string{"ECHO", ""}},
+		{name: "escapes", line: `"a\nb\r\t\b\a\\\"c"`, want: []string{"a\nb\r\t\b\a\\\"c"}},
+		{name: "unknown escape", line: `"\q"`, want: []string{"q"}},
+		{name: "hex escape", line: `"\x41\x4a\x00"`, want: []string{"AJ\x00"}},
+		{name: "invalid hex escape", line: `"\x4g"`, want: []string{"x4g"}},
+		{name: "truncated hex escape", line: `"\x4"`, want: []string{"x4"}},
+		{name: "single quotes", line: `'a "b" \n'`, want: []string{`a "b" \n`}},
+		{name: "escaped single quote", line: `'it\'s'`, want: []string{"it's"}},
+		{name: "empty single quotes", line: `''`, want: []string{""}},
+		{name: "quotes inside an argument", line: `a"b c" x'd'`, want: []string{"ab c", "xd"}},
+		{name: "no escapes outside quotes", line: `\x41\n`, want: []string{`\x41\n`}},
+		{name: "unterminated double quotes", line: `"abc`, wantErr: true},
+		{name: "escaped closing quote", line: `"abc\"`, wantErr: true},
+		{name: "unterminated single quotes", line: `'abc`, wantErr: true},
+		{name: "text after double quotes", line: `"a"b`, wantErr: true},
+		{name: "text after single quotes", line: `'a'b`, wantErr: true},
+	}
+	for _, tt := range tests {
+		r := NewReader(strings.NewReader(""))
+		got, err := r.parseInline([]byte(tt.line))
+		r.Release()
+		if tt.wantErr {
+			var protoErr *ProtocolError
+			if !errors.As(err, &protoErr) {
+				t.Errorf("%s: got %q, %v, want a protocol error", tt.name, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		want := make([][]byte, 0, len(tt.want))
+		for _, arg := range tt.want {
+			want = append(want, []byte(arg))
+		}
+		if !equalArgs(got, want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+// endlessReader returns c forever, a line without LF never ends
+type endlessReader byte
+
+func (c endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(c)
+	}
+	return len(p), nil
+}
+
+func TestReadCommandStopsAtLineLimit(t *testing.T) {
+	for _, c := range []byte{'a', '*'} {
+		r := NewReader(endlessReader(c))
+		_, err := r.ReadCommand()
+		r.Release()
+		var protoErr *ProtocolError
+		if !errors.As(err, &protoErr) {
+			t.Errorf("line of %q: got %v, want a protocol error", c, err)
+		}
+	}
+	r := NewReader(endlessReader('a'))
+	defer r.Release()
+	if _, err := r.readRawLine(maxInlineSize); err != ErrQueryBufferLimit {
+		t.Errorf("readRawLine: got %v, want %v", err, ErrQueryBufferLimit)
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string // the reply encoded in RESP3, empty means the same as input
+	}{
+		{name: "status", input: "+OK\r\n"},
+		{name: "error", input: "-ERR wrong\r\n"},
+		{name: "integer", input: ":-42\r\n"},
+		{name: "bulk", input: "$5\r\nhello\r\n"},
+		{name: "empty bulk", input: "$0\r\n\r\n"},
+		{name: "null bulk", input: "$-1\r\n", want: "_\r\n"},
+		{name: "null array", input: "*-1\r\n", want: "_\r\n"},
+		{name: "empty array", input: "*0\r\n"},
+		{name: "bulk array with null", input: "*3\r\n$1\r\na\r\n$-1\r\n$0\r\n\r\n", want: "*3\r\n$1\r\na\r\n_\r\n$0\r\n\r\n"},
+		{name: "mixed array", input: "*3\r\n$1\r\na\r\n:1\r\n*1\r\n+x\r\n"},
+		{name: "map", input: "%2\r\n+a\r\n:1\r\n+b\r\n:2\r\n"},
+		{name: "set", input: "~2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{name: "push", input: ">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$2\r\nhi\r\n"},
+		{name: "double", input: ",1.5\r\n"},
+		{name: "boolean", input: "#t\r\n"},
+		{name: "null", input: "_\r\n"},
+		{name: "big number", input: "(3492890328409238509324850943850943825024385\r\n"},
+		{name: "verbatim", input: "=15\r\ntxt:Some string\r\n"},
+		{name: "blob error", input: "!11\r\nSYNTAX oops\r\n", want: "-SYNTAX oops\r\n"},
+		{name: "attribute", input: "|1\r\n+ttl\r\n:3600\r\n$1\r\nv\r\n"},
+	}
+	for _, tt := range tests {
+		want := tt.want
+		if want == "" {
+			want = tt.input
+		}
+		for mode, rd := range readers(tt.input) {
+			r := NewReader(rd)
+			got, err := r.ReadReply()
+			r.Release()
+			if err != nil {
+				t.Errorf("%s/%s: unexpected error %v", tt.name, mode, err)
+				continue
+			}
+			if encoded := string(reply.Marshal(got, reply.Resp3)); encoded != want {
+				t.Errorf("%s/%s: got %q, want %q", tt.name, mode, encoded, want)
+			}
+		}
+	}
+}
+
+func TestReadReplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"unknown type", "@foo\r\n"},
+		{"bad integer", ":12a\r\n"},
+		{"bad bulk length", "$-2\r\n"},
+		{"bad boolean", "#x\r\n"},
+		{"bad double", ",abc\r\n"},
+		{"bad verbatim", "=3\r\ntxt\r\n"},
+		{"missing CR", "+OK\n"},
+	}
+	for _, tt := range tests {
+		r := NewReader(strings.NewReader(tt.input))
+		_, err := r.ReadReply()
+		r.Release()
+		var protoErr *ProtocolError
+		if !errors.As(err, &protoErr) {
+			t.Errorf("%s: got %v, want a protocol error", tt.name, err)
+		}
+	}
+}
+
+func TestParseStream(t *testing.T) {
+	ch := ParseStream(strings.NewReader("+OK\r\n:1\r\n$-1\r\n"))
+	var got []string
+	for payload := range ch {
+		if payload.Err != nil {
+			if payload.Err != io.EOF {
+				t.Errorf("unexpected error %v", payload.Err)
+			}
+			break
+		}
+		got = append(got, string(payload.Data.ToBytes()))
+	}
+	if want := []string{"+OK\r\n", ":1\r\n", "$-1\r\n"}; strings.Join(got, "") != strings.Join(want, "") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func equalArgs(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// repeatReader returns data n times, benchmarks read one long stream the way a connection does
+type repeatReader struct {
+	data []byte
+	n    int
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	written := 0
+	for written < len(p) && r.n > 0 {
+		c := copy(p[written:], r.data[r.off:])
+		written += c
+		r.off += c
+		if r.off == len(r.data) {
+			r.off = 0
+			r.n--
+		}
+	}
+	return written, nil
+}
+
+// benchmarkInputs are the payloads read by the benchmarks, each with the number of commands it holds
+var benchmarkInputs = []struct {
+	name     string
+	payload  string
+	commands int
+}{
+	{"multibulk", "*3\r\n$3\r\nSET\r\n$16\r\nkey:000000000001\r\n$64\r\n" + strings.Repeat("v", 64) + "\r\n", 1},
+	{"pipelined", strings.Repeat("*2\r\n$3\r\nGET\r\n$16\r\nkey:000000000001\r\n", 100), 100},
+	{"inline", "SET key:000000000001 " + strings.Repeat("v", 64) + "\r\n", 1},
+}
+
+func BenchmarkReadCommand(b *testing.B) {
+	for _, input := range benchmarkInputs {
+		b.Run(input.name, func(b *testing.B) {
+			b.SetBytes(int64(len(input.payload)))
+			b.ReportAllocs()
+			r := NewReader(&repeatReader{data: []byte(input.payload), n: b.N})
+			defer r.Release()
+			b.ResetTimer()
+			for i := 0; i < b.N*input.commands; i++ {
+				if _, err := r.ReadCommand(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkParseStream reads the same requests through the channel based ParseStream,
+// which the server used before ReadCommand. It does not parse inline commands.
+func BenchmarkParseStream(b *testing.B) {
+	for _, input := range benchmarkInputs {
+		if input.name == "inline" {
+			continue
+		}
+		b.Run(input.name, func(b *testing.B) {
+			b.SetBytes(int64(len(input.payload)))
+			b.ReportAllocs()
+			ch := ParseStream(&repeatReader{data: []byte(input.payload), n: b.N})
+			b.ResetTimer()
+			for i := 0; i < b.N*input.commands; i++ {
+				payload := <-ch
+				if payload.Err != nil {
+					b.Fatal(payload.Err)
+				}
+			}
+			b.StopTimer()
+			for range ch {
+			}
+		})
+	}
+}
+
+// FuzzReadCommand reads commands until the first error under the fuzzed limits, the limits must hold
+// for every command returned and malformed input must end in an error rather than a panic
+func FuzzReadCommand(f *testing.F) {
+	seeds := []struct {
+		data        string
+		maxBulkLen  int64
+		maxQueryLen int64
+	}{
+		{"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n", 0, 0},
+		{"*1\r\n$4\r\nPING\r\nPING\r\n", 0, 0},
+		// proto-max-bulk-len
+		{"*2\r\n$4\r\nECHO\r\n$5\r\nhello\r\n", 4, 0},
+		{"*1\r\n$9223372036854775807\r\n", 1 << 20, 0},
+		// multibulk count
+		{"*1048577\r\n$1\r\na\r\n", 0, 0},
+		{"*-5\r\n*0\r\n*3\r\n$1\r\na\r\n", 0, 0},
+		{"*9999999999999999999999\r\n", 0, 0},
+		// client-query-buffer-limit
+		{"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n", 0, 8},
+		{"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n", 0, 6},
+		{"SET \"a\\x41\" 'b\\'c'\r\n", 0, 0},
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed.data), seed.maxBulkLen, seed.maxQueryLen)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, maxBulkLen int64, maxQueryLen int64) {
+		r := NewReader(bytes.NewReader(data))
+		defer r.Release()
+		r.SetLimits(Limits{MaxBulkLen: maxBulkLen, MaxQueryLen: maxQueryLen})
+		for {
+			// the limits apply to multi bulk requests, inline commands are only bounded by the length of the line
+			next, _ := r.br.Peek(1)
+			multiBulk := len(next) == 1 && next[0] == '*'
+			args, err := r.ReadCommand()
+			if err != nil {
+				return
+			}
+			if len(args) > maxMultiBulkLen {
+				t.Fatalf("%d arguments, more than %d", len(args), maxMultiBulkLen)
+			}
+			if !multiBulk {
+				continue
+			}
+			var queryLen int64
+			for _, arg := range args {
+				if maxBulkLen > 0 && int64(len(arg)) > maxBulkLen {
+					t.Fatalf("argument of %d bytes, proto-max-bulk-len is %d", len(arg), maxBulkLen)
+				}
+				queryLen += int64(len(arg))
+			}
+			if maxQueryLen > 0 && queryLen > maxQueryLen {
+				t.Fatalf("arguments of %d bytes, client-query-buffer-limit is %d", queryLen, maxQueryLen)
+			}
+		}
+	})
+}
+
+// FuzzParseStream reads replies through ParseStream, the stream must always end with an error payload
+func FuzzParseStream(f *testing.F) {
+	seeds := []string{
+		"+OK\r\n-ERR x\r\n:1\r\n$-1\r\n*-1\r\n$0\r\n\r\n",
+		"*2\r\n$1\r\na\r\n:1\r\n",
+		"%1\r\n+a\r\n~1\r\n,1.5\r\n",
+		">2\r\n#t\r\n_\r\n|1\r\n+k\r\n+v\r\n(1\r\n",
+		"=7\r\ntxt:abc\r\n!3\r\nERR\r\n",
+		"*1048577\r\n",
+		"$9223372036854775807\r\n",
+		"*3\r\n*3\r\n*3\r\n",
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var last *Payload
+		for payload := range ParseStream(bytes.NewReader(data)) {
+			if payload.Err == nil && payload.Data == nil {
+				t.Fatal("payload without data or error")
+			}
+			last = payload
+		}
+		if last == nil || last.Err == nil {
+			t.Fatal("stream did not end with an error")
+		}
+	})
+}
@@ -7,8 +7,6 @@ import (
 )
 
 var (
-	nullBulkReplyBytes = []byte("$-1")
-
 	// CRLF is the line separator of redis serialization protocol
 	CRLF = "\r\n"
 )
@@ -27,10 +25,10 @@ func MakeBulkReply(arg []byte) *BulkReply {
 	}
 }
 
-// ToBytes marshal redis.Reply
+// ToBytes marshal redis.Reply, a nil Arg is encoded as null bulk and an empty one as $0
 func (r *BulkReply) ToBytes() []byte {
-	if len(r.Arg) == 0 {
-		return nullBulkReplyBytes
+	if r.Arg == nil {
+		return nullBulkBytes
 	}
 	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
 }
@@ -56,7 +54,7 @@ func (r *MultiBulkReply) ToBytes() []byte {
 	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
 	for _, arg := range r.Args {
 		if arg == nil {
-			buf.WriteString(string(nullBulkReplyBytes) + CRLF)
+			buf.Write(nullBulkBytes)
 		} else {
 			buf.WriteString("$" + strconv.Itoa(len(arg)) + CRLF + string(arg) + CRLF)
 		}
@@ -64,6 +62,39 @@ func (r *MultiBulkReply) ToBytes() []byte {
 	return buf.Bytes()
 }
 
+/* ---- Multi Raw Reply ---- */
+
+// MultiRawReply stores a list of replies which may be nested, e.g. the reply of SCAN
+type MultiRawReply struct {
+	Replies []resp.Reply
+}
+
+// MakeMultiRawReply creates MultiRawReply
+func MakeMultiRawReply(replies []resp.Reply) *MultiRawReply {
+	return &MultiRawReply{
+		Replies: replies,
+	}
+}
+
+// MakeHelpReply creates MultiRawReply with a status reply for each line, used by the HELP subcommands
+func MakeHelpReply(lines []string) *MultiRawReply {
+	replies := make([]resp.Reply, len(lines))
+	for i, line := range lines {
+		replies[i] = MakeStatusReply(line)
+	}
+	return MakeMultiRawReply(replies)
+}
+
+// ToBytes marshal redis.Reply
+func (r *MultiRawReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(r.Replies)) + CRLF)
+	for _, arg := range r.Replies {
+		buf.Write(arg.ToBytes())
+	}
+	return buf.Bytes()
+}
+
 /* ---- Status Reply ---- */
 
 // StatusReply stores a simple status string
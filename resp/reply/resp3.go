@@ -0,0 +1,334 @@
+package reply
+
+import (
+	"bytes"
+	"go-redis/interface/resp"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Protocol versions negotiated by HELLO
+const (
+	Resp2 = 2
+	Resp3 = 3
+)
+
+// ProtocolReply is implemented by replies whose encoding depends on the protocol version,
+// ToBytes always returns the RESP2 encoding
+type ProtocolReply interface {
+	resp.Reply
+	ToBytesWithProtocol(protocol int) []byte
+}
+
+// Marshal encodes r for the given protocol version
+func Marshal(r resp.Reply, protocol int) []byte {
+	if pr, ok := r.(ProtocolReply); ok {
+		return pr.ToBytesWithProtocol(protocol)
+	}
+	return r.ToBytes()
+}
+
+// writeAggregate writes a header followed by the elements encoded for protocol
+func writeAggregate(prefix byte, length int, elements []resp.Reply, protocol int) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(prefix)
+	buf.WriteString(strconv.Itoa(length) + CRLF)
+	for _, elem := range elements {
+		buf.Write(Marshal(elem, protocol))
+	}
+	return buf.Bytes()
+}
+
+/* ---- Null Reply ---- */
+
+var nullBytes = []byte("_\r\n")
+
+// ToBytesWithProtocol encodes null as _ in RESP3
+func (r *NullBulkReply) ToBytesWithProtocol(protocol int) []byte {
+	if protocol >= Resp3 {
+		return nullBytes
+	}
+	return r.ToBytes()
+}
+
+// ToBytesWithProtocol encodes nil elements as RESP3 nulls
+func (r *MultiBulkReply) ToBytesWithProtocol(protocol int) []byte {
+	if protocol < Resp3 {
+		return r.ToBytes()
+	}
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(r.Args)) + CRLF)
+	for _, arg := range r.Args {
+		if arg == nil {
+			buf.Write(nullBytes)
+		} else {
+			buf.WriteString("$" + strconv.Itoa(len(arg)) + CRLF + string(arg) + CRLF)
+		}
+	}
+	return buf.Bytes()
+}
+
+// ToBytesWithProtocol encodes nested replies for protocol
+func (r *MultiRawReply) ToBytesWithProtocol(protocol int) []byte {
+	return writeAggregate('*', len(r.Replies), r.Replies, protocol)
+}
+
+/* ---- Map Reply ---- */
+
+// MapReply is an ordered list of key value pairs, encoded as a flat array in RESP2
+type MapReply struct {
+	Keys   []resp.Reply
+	Values []resp.Reply
+}
+
+// MakeMapReply creates an empty MapReply, use Add to append pairs
+func MakeMapReply() *MapReply {
+	return &MapReply{}
+}
+
+// Add appends a key value pair
+func (r *MapReply) Add(key, value resp.Reply) *MapReply {
+	r.Keys = append(r.Keys, key)
+	r.Values = append(r.Values, value)
+	return r
+}
+
+// AddString appends a pair with a bulk string key
+func (r *MapReply) AddString(key string, value resp.Reply) *MapReply {
+	return r.Add(MakeBulkReply([]byte(key)), value)
+}
+
+func (r *MapReply) flatten() []resp.Reply {
+	elements := make([]resp.Reply, 0, 2*len(r.Keys))
+	for i := range r.Keys {
+		elements = append(elements, r.Keys[i], r.Values[i])
+	}
+	return elements
+}
+
+// ToBytes marshal redis.Reply
+func (r *MapReply) ToBytes() []byte {
+	return r.ToBytesWithProtocol(Resp2)
+}
+
+// ToBytesWithProtocol encodes the map as %n in RESP3
+func (r *MapReply) ToBytesWithProtocol(protocol int) []byte {
+	if protocol >= Resp3 {
+		return writeAggregate('%', len(r.Keys), r.flatten(), protocol)
+	}
+	return writeAggregate('*', 2*len(r.Keys), r.flatten(), protocol)
+}
+
+/* ---- Set Reply ---- */
+
+// SetReply is an unordered collection of distinct elements, encoded as an array in RESP2
+type SetReply struct {
+	Members []resp.Reply
+}
+
+// MakeSetReply creates SetReply
+func MakeSetReply(members []resp.Reply) *SetReply {
+	return &SetReply{
+		Members: members,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *SetReply) ToBytes() []byte {
+	return r.ToBytesWithProtocol(Resp2)
+}
+
+// ToBytesWithProtocol encodes the set as ~n in RESP3
+func (r *SetReply) ToBytesWithProtocol(protocol int) []byte {
+	if protocol >= Resp3 {
+		return writeAggregate('~', len(r.Members), r.Members, protocol)
+	}
+	return writeAggregate('*', len(r.Members), r.Members, protocol)
+}
+
+/* ---- Push Reply ---- */
+
+// PushReply is out-of-band data like pubsub messages, encoded as an array in RESP2
+type PushReply struct {
+	Replies []resp.Reply
+}
+
+// MakePushReply creates PushReply
+func MakePushReply(replies []resp.Reply) *PushReply {
+	return &PushReply{
+		Replies: replies,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *PushReply) ToBytes() []byte {
+	return r.ToBytesWithProtocol(Resp2)
+}
+
+// ToBytesWithProtocol encodes the push as >n in RESP3
+func (r *PushReply) ToBytesWithProtocol(protocol int) []byte {
+	if protocol >= Resp3 {
+		return writeAggregate('>', len(r.Replies), r.Replies, protocol)
+	}
+	return writeAggregate('*', len(r.Replies), r.Replies, protocol)
+}
+
+/* ---- Attribute Reply ---- */
+
+// AttributeReply attaches auxiliary data to a reply, the attributes are dropped in RESP2
+type AttributeReply struct {
+	Attributes *MapReply
+	Reply      resp.Reply
+}
+
+// MakeAttributeReply creates AttributeReply
+func MakeAttributeReply(attributes *MapReply, r resp.Reply) *AttributeReply {
+	return &AttributeReply{
+		Attributes: attributes,
+		Reply:      r,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *AttributeReply) ToBytes() []byte {
+	return r.ToBytesWithProtocol(Resp2)
+}
+
+// ToBytesWithProtocol encodes the attributes as |n followed by the reply in RESP3
+func (r *AttributeReply) ToBytesWithProtocol(protocol int) []byte {
+	if protocol < Resp3 {
+		return Marshal(r.Reply, protocol)
+	}
+	buf := writeAggregate('|', len(r.Attributes.Keys), r.Attributes.flatten(), protocol)
+	return append(buf, Marshal(r.Reply, protocol)...)
+}
+
+/* ---- Double Reply ---- */
+
+// DoubleReply is a floating point number, encoded as a bulk string in RESP2
+type DoubleReply struct {
+	Value float64
+}
+
+// MakeDoubleReply creates DoubleReply
+func MakeDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{
+		Value: value,
+	}
+}
+
+func formatDouble(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	case math.IsNaN(v):
+		return "nan"
+	}
+	return strconv.FormatFloat(v, 'g', 17, 64)
+}
+
+// ToBytes marshal redis.Reply
+func (r *DoubleReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(formatDouble(r.Value))).ToBytes()
+}
+
+// ToBytesWithProtocol encodes the number as ,value in RESP3
+func (r *DoubleReply) ToBytesWithProtocol(protocol int) []byte {
+	if protocol >= Resp3 {
+		return []byte("," + formatDouble(r.Value) + CRLF)
+	}
+	return r.ToBytes()
+}
+
+/* ---- Boolean Reply ---- */
+
+// BooleanReply is true or false, encoded as 1 or 0 in RESP2
+type BooleanReply struct {
+	Value bool
+}
+
+// MakeBooleanReply creates BooleanReply
+func MakeBooleanReply(value bool) *BooleanReply {
+	return &BooleanReply{
+		Value: value,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *BooleanReply) ToBytes() []byte {
+	if r.Value {
+		return []byte(":1" + CRLF)
+	}
+	return []byte(":0" + CRLF)
+}
+
+// ToBytesWithProtocol encodes the value as #t or #f in RESP3
+func (r *BooleanReply) ToBytesWithProtocol(protocol int) []byte {
+	if protocol < Resp3 {
+		return r.ToBytes()
+	}
+	if r.Value {
+		return []byte("#t" + CRLF)
+	}
+	return []byte("#f" + CRLF)
+}
+
+/* ---- Big Number Reply ---- */
+
+// BigNumberReply is an integer out of the range of int64, encoded as a bulk string in RESP2
+type BigNumberReply struct {
+	Value *big.Int
+}
+
+// MakeBigNumberReply creates BigNumberReply
+func MakeBigNumberReply(value *big.Int) *BigNumberReply {
+	return &BigNumberReply{
+		Value: value,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *BigNumberReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(r.Value.String())).ToBytes()
+}
+
+// ToBytesWithProtocol encodes the number as (value in RESP3
+func (r *BigNumberReply) ToBytesWithProtocol(protocol int) []byte {
+	if protocol >= Resp3 {
+		return []byte("(" + r.Value.String() + CRLF)
+	}
+	return r.ToBytes()
+}
+
+/* ---- Verbatim String Reply ---- */
+
+// VerbatimReply is a string with a three characters format such as txt or mkd,
+// encoded as a bulk string in RESP2
+type VerbatimReply struct {
+	Format string
+	Text   []byte
+}
+
+// MakeVerbatimReply creates VerbatimReply
+func MakeVerbatimReply(format string, text []byte) *VerbatimReply {
+	return &VerbatimReply{
+		Format: format,
+		Text:   text,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *VerbatimReply) ToBytes() []byte {
+	return MakeBulkReply(r.Text).ToBytes()
+}
+
+// ToBytesWithProtocol encodes the string as =len\r\nfmt:text in RESP3
+func (r *VerbatimReply) ToBytesWithProtocol(protocol int) []byte {
+	if protocol < Resp3 {
+		return r.ToBytes()
+	}
+	return []byte("=" + strconv.Itoa(len(r.Format)+1+len(r.Text)) + CRLF + r.Format + ":" + string(r.Text) + CRLF)
+}
@@ -2,6 +2,8 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"go-redis/interface/tcp"
 	"go-redis/lib/logger"
@@ -10,71 +12,166 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 )
 
 // Config 包含服务的配置信息
 type Config struct {
-	Address string // 服务监听的地址
+	Address        string        // 明文 TCP 监听的地址，为空时不监听
+	TLSAddress     string        // TLS 监听的地址，为空时不监听
+	TLSConfig      *tls.Config   // TLS 监听使用的证书及客户端校验配置
+	UnixSocket     string        // Unix socket 的路径，为空时不监听
+	UnixSocketPerm os.FileMode   // Unix socket 文件的权限，为 0 时不修改
+	KeepAlive      time.Duration // 客户端 TCP 连接发送 keepalive 探测的间隔，为 0 时关闭 keepalive
 }
 
 // ListenAndServeWithSignal 启动TCP服务，并监听系统信号以优雅地关闭服务
 func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
-	closeChan := make(chan struct{}) // 用于通知关闭的通道
-	sigChan := make(chan os.Signal)  // 用于接收系统信号的通道
+	closeChan := make(chan struct{})   // 用于通知关闭的通道
+	sigChan := make(chan os.Signal, 1) // 用于接收系统信号的通道
 	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 
-	// 启动协程监听系统信号
+	// 处理器可以通过 SHUTDOWN 命令请求关闭服务，未实现时该通道为 nil，永远不会就绪
+	var shutdownChan <-chan struct{}
+	if notifier, ok := handler.(tcp.ShutdownNotifier); ok {
+		shutdownChan = notifier.ShutdownRequested()
+	}
+	// 启动协程监听系统信号及关闭请求
 	go func() {
-		sig := <-sigChan
-		switch sig {
-		case syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+		select {
+		case sig := <-sigChan:
+			switch sig {
+			case syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+				logger.Info(fmt.Sprintf("received %s, scheduling shutdown...", sig))
+				closeChan <- struct{}{}
+			}
+		case <-shutdownChan:
 			closeChan <- struct{}{}
 		}
 	}()
 
 	// 监听指定地址
-	listener, err := net.Listen("tcp", cfg.Address)
-	if err != nil {
-		return err
+	var listeners []net.Listener
+	closeListeners := func() {
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
+	}
+	if cfg.Address != "" {
+		listener, err := net.Listen("tcp", cfg.Address)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
+	}
+	if cfg.TLSAddress != "" {
+		listener, err := tls.Listen("tcp", cfg.TLSAddress, cfg.TLSConfig)
+		if err != nil {
+			closeListeners()
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info(fmt.Sprintf("bind: %s, start listening with TLS...", cfg.TLSAddress))
+	}
+	if cfg.UnixSocket != "" {
+		listener, err := listenUnix(cfg.UnixSocket, cfg.UnixSocketPerm)
+		if err != nil {
+			closeListeners()
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info(fmt.Sprintf("bind: %s, start listening on unix socket...", cfg.UnixSocket))
+	}
+	if len(listeners) == 0 {
+		return errors.New("no address to listen on")
 	}
-	logger.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
 
 	// 调用ListenAndServe处理连接和关闭
-	ListenAndServe(listener, handler, closeChan)
+	ListenAndServe(listeners, handler, closeChan, cfg.KeepAlive)
 
 	return nil
 }
 
-// ListenAndServe 处理TCP连接和关闭服务的函数
-func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan struct{}) {
+// listenUnix 监听 Unix socket，启动前删除上次运行残留的 socket 文件，关闭监听器时 socket 文件会被删除
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err = os.Chmod(path, perm); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// setKeepAlive 按 tcp-keepalive 设置客户端连接的 keepalive，TLS 连接设置在底层的 TCP 连接上，Unix socket 连接不需要设置
+func setKeepAlive(conn net.Conn, period time.Duration) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if period <= 0 {
+		_ = tcpConn.SetKeepAlive(false)
+		return
+	}
+	_ = tcpConn.SetKeepAlive(true)
+	_ = tcpConn.SetKeepAlivePeriod(period)
+}
+
+// ListenAndServe 在所有监听器上接受连接并交给同一个处理器，收到关闭信号或任一监听器停止时关闭全部监听器与处理器
+// keepAlive 为客户端 TCP 连接发送 keepalive 探测的间隔，为 0 时关闭 keepalive
+func ListenAndServe(listeners []net.Listener, handler tcp.Handler, closeChan <-chan struct{}, keepAlive time.Duration) {
+	var closeOnce sync.Once
+	shutdown := func() {
+		closeOnce.Do(func() {
+			for _, listener := range listeners {
+				_ = listener.Close() // 关闭监听器
+			}
+			_ = handler.Close() // 关闭处理器
+		})
+	}
 	// 启动协程，监听关闭通道，收到信号时优雅地关闭服务
 	go func() {
 		<-closeChan
 		logger.Info("shutting down...")
-		_ = listener.Close() // 关闭监听器
-		_ = handler.Close()  // 关闭处理器
-	}()
-
-	// 在函数退出时确保关闭监听器和处理器
-	defer func() {
-		_ = listener.Close()
-		_ = handler.Close()
+		shutdown()
 	}()
 
 	ctx := context.Background()
 	var waitDone sync.WaitGroup
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			break
-		}
-		logger.Info("connection accepted")
-		waitDone.Add(1)
+	var acceptDone sync.WaitGroup
+	for _, listener := range listeners {
+		acceptDone.Add(1)
+		go func(listener net.Listener) {
+			defer acceptDone.Done()
+			// 监听器停止后关闭整个服务
+			defer shutdown()
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				logger.Info("connection accepted")
+				setKeepAlive(conn, keepAlive)
+				waitDone.Add(1)
 
-		go func() {
-			defer waitDone.Done()
-			handler.Handle(ctx, conn)
-		}()
+				go func() {
+					defer waitDone.Done()
+					handler.Handle(ctx, conn)
+				}()
+			}
+		}(listener)
 	}
+	acceptDone.Wait()
 	waitDone.Wait() // 等待所有连接处理完毕
 }